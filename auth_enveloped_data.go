@@ -0,0 +1,125 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+// AuthEncryptOptions customizes how messages are encrypted by
+// AuthEncryptWithOptions. The ContentEncryptionAlgorithm must be AES-GCM or
+// ChaCha20-Poly1305. oid.EncryptionAlgorithmAES256GCM is used if it's nil.
+type AuthEncryptOptions struct {
+	EncryptOptions
+
+	// AuthenticatedAttributes aren't encrypted, but are protected by the MAC
+	// along with the content.
+	AuthenticatedAttributes protocol.Attributes
+}
+
+// AuthEnvelopedData represents a message encrypted with an authenticated
+// encryption algorithm.
+type AuthEnvelopedData struct {
+	ped *protocol.AuthEnvelopedData
+}
+
+// AuthEncrypt creates a CMS AuthEnvelopedData from the content, encrypting it
+// with AES-256-GCM for each of the recipients' certificates. Recipients are
+// handled as for Encrypt. The DER encoded CMS message is returned.
+func AuthEncrypt(data []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return AuthEncryptWithOptions(data, recipients, AuthEncryptOptions{})
+}
+
+// AuthEncryptWithOptions is like AuthEncrypt, but allows the caller to
+// customize the algorithms, recipients and authenticated attributes with opts.
+func AuthEncryptWithOptions(data []byte, recipients []*x509.Certificate, opts AuthEncryptOptions) ([]byte, error) {
+	algo := opts.ContentEncryptionAlgorithm
+	if algo == nil {
+		algo = oid.EncryptionAlgorithmAES256GCM
+	}
+
+	ped, key, err := protocol.NewAuthEnvelopedData(oid.ContentTypeData, algo, data, opts.AuthenticatedAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = addRecipientInfos(&ped.RecipientInfos, recipients, opts.Passwords, opts.KeyEncryptionKeys, key, opts.RecipientInfoOptions); err != nil {
+		return nil, err
+	}
+
+	return ped.ContentInfoDER()
+}
+
+// ParseAuthEnvelopedData parses an AuthEnvelopedData from BER encoded data.
+func ParseAuthEnvelopedData(ber []byte) (*AuthEnvelopedData, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	ped, err := ci.AuthEnvelopedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthEnvelopedData{ped}, nil
+}
+
+// Decrypt decrypts the content using the private key for the recipient's
+// certificate, checking that neither it nor the authenticated attributes were
+// modified. The key is used as described for EnvelopedData.Decrypt. A
+// protocol.ErrNoRecipient is returned if the message wasn't encrypted for
+// cert, and a protocol.ErrDecryption if the key can't be decrypted or the MAC
+// doesn't match.
+func (aed *AuthEnvelopedData) Decrypt(cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	keySize, err := aed.ped.ContentEncryptionKeySize()
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := decryptKey(aed.ped.RecipientInfos, keySize, cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return aed.ped.Decrypt(cek)
+}
+
+// DecryptWithPassword decrypts the content using a password given to
+// AuthEncryptWithOptions. A protocol.ErrNoRecipient is returned if the message
+// wasn't encrypted for a password, and a protocol.ErrDecryption if the
+// password is wrong or the MAC doesn't match.
+func (aed *AuthEnvelopedData) DecryptWithPassword(password []byte) ([]byte, error) {
+	return decryptWithPassword(aed.ped.RecipientInfos, password, aed.ped.Decrypt)
+}
+
+// DecryptWithKeyEncryptionKey decrypts the content using a key-encryption key
+// given to AuthEncryptWithOptions. A protocol.ErrNoRecipient is returned if
+// the message wasn't encrypted for kek.ID, and a protocol.ErrDecryption if
+// kek.Key is wrong or the MAC doesn't match.
+func (aed *AuthEnvelopedData) DecryptWithKeyEncryptionKey(kek KeyEncryptionKey) ([]byte, error) {
+	cek, err := decryptWithKeyEncryptionKey(aed.ped.RecipientInfos, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return aed.ped.Decrypt(cek)
+}
+
+// AuthenticatedAttributes gets a copy of the message's authenticated
+// attributes. They can't be trusted until the message has been decrypted
+// successfully.
+func (aed *AuthEnvelopedData) AuthenticatedAttributes() protocol.Attributes {
+	return copyAttributes(aed.ped.AuthAttrs)
+}
+
+// GetAuthenticatedAttribute decodes the only value of the authenticated
+// attribute with the given type into val. protocol.ErrNoAttribute is returned
+// if there's no such attribute. Like AuthenticatedAttributes, the value can't
+// be trusted until the message has been decrypted successfully.
+func (aed *AuthEnvelopedData) GetAuthenticatedAttribute(typ asn1.ObjectIdentifier, val interface{}) error {
+	return getAttribute(aed.ped.AuthAttrs, typ, val)
+}
@@ -0,0 +1,262 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestAuthEncrypt(t *testing.T) {
+	data := []byte("hello, world!")
+	password := []byte("correct horse battery staple")
+	kek := KeyEncryptionKey{ID: []byte("backup"), Key: bytes.Repeat([]byte{0x01}, 32)}
+	x25519Cert, x25519Key := newX25519Recipient(t)
+
+	kemCerts, kemKeys := newKEMRecipients(t)
+
+	signingTime := time.Now().UTC().Truncate(time.Second)
+	attr, err := protocol.NewAttribute(oid.AttributeSigningTime, signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algos := []asn1.ObjectIdentifier{
+		nil,
+		oid.EncryptionAlgorithmAES128GCM,
+		oid.EncryptionAlgorithmAES192GCM,
+		oid.EncryptionAlgorithmChaCha20Poly1305,
+	}
+
+	for _, algo := range algos {
+		for _, authAttrs := range []protocol.Attributes{nil, {attr}} {
+			opts := AuthEncryptOptions{
+				EncryptOptions: EncryptOptions{
+					ContentEncryptionAlgorithm: algo,
+					RecipientInfoOptions:       protocol.RecipientInfoOptions{PasswordIterationCount: 1000},
+					Passwords:                  [][]byte{password},
+					KeyEncryptionKeys:          []KeyEncryptionKey{kek},
+				},
+				AuthenticatedAttributes: authAttrs,
+			}
+
+			certs := append([]*x509.Certificate{leaf.Certificate, intermediate.Certificate, x25519Cert}, kemCerts...)
+			keys := append([]crypto.PrivateKey{leaf.PrivateKey, intermediate.PrivateKey, x25519Key}, kemKeys...)
+
+			der, err := AuthEncryptWithOptions(data, certs, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aed, err := ParseAuthEnvelopedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aed.ped.Version != 0 {
+				t.Fatalf("expected version 0, got %d", aed.ped.Version)
+			}
+
+			if algo == nil {
+				if !aed.ped.AuthEncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm.Equal(oid.EncryptionAlgorithmAES256GCM) {
+					t.Fatal("expected AES-256-GCM by default")
+				}
+			}
+
+			for i, cert := range certs {
+				decrypted, err := aed.Decrypt(cert, keys[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, data) {
+					t.Fatal("bad decrypted data")
+				}
+			}
+
+			decrypted, err := aed.DecryptWithPassword(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("bad decrypted data")
+			}
+
+			if decrypted, err = aed.DecryptWithKeyEncryptionKey(kek); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("bad decrypted data")
+			}
+
+			var st time.Time
+			err = aed.GetAuthenticatedAttribute(oid.AttributeSigningTime, &st)
+			if authAttrs == nil {
+				if !errors.Is(err, protocol.ErrNoAttribute) {
+					t.Fatalf("expected ErrNoAttribute, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !st.Equal(signingTime) {
+				t.Fatalf("expected signing time %v, got %v", signingTime, st)
+			}
+		}
+	}
+}
+
+func TestAuthEnvelopedDataDecryptErrors(t *testing.T) {
+	data := []byte("hello, world!")
+
+	attr, err := protocol.NewAttribute(oid.AttributeSigningTime, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := AuthEncryptOptions{AuthenticatedAttributes: protocol.Attributes{attr}}
+	der, err := AuthEncryptWithOptions(data, []*x509.Certificate{leaf.Certificate}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func() *AuthEnvelopedData {
+		aed, err := ParseAuthEnvelopedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return aed
+	}
+
+	if _, err = parse().Decrypt(root.Certificate, root.PrivateKey); !errors.Is(err, protocol.ErrNoRecipient) {
+		t.Fatalf("expected ErrNoRecipient, got %v", err)
+	}
+
+	aed := parse()
+	aed.ped.MAC[0] ^= 0xFF
+	if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	aed = parse()
+	aed.ped.AuthEncryptedContentInfo.EncryptedContent.Bytes[0] ^= 0xFF
+	if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	// Changing or removing the authenticated attributes invalidates the MAC.
+	otherAttr, err := protocol.NewAttribute(oid.AttributeSigningTime, time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aed = parse()
+	aed.ped.AuthAttrs = protocol.Attributes{otherAttr}
+	if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	aed = parse()
+	aed.ped.AuthAttrs = nil
+	if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	// AuthEnvelopedData requires an authenticated encryption algorithm.
+	opts = AuthEncryptOptions{EncryptOptions: EncryptOptions{ContentEncryptionAlgorithm: oid.EncryptionAlgorithmAES256CBC}}
+	if _, err = AuthEncryptWithOptions(data, []*x509.Certificate{leaf.Certificate}, opts); !errors.Is(err, protocol.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	if _, err = AuthEncrypt(data, nil); err == nil {
+		t.Fatal("expected error encrypting without recipients")
+	}
+
+	// An EnvelopedData isn't an AuthEnvelopedData.
+	edDER, err := Encrypt(data, []*x509.Certificate{leaf.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthEnvelopedData(edDER); !errors.Is(err, protocol.ErrWrongType) {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestAuthEncryptWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	dir := t.TempDir()
+	contentFile := filepath.Join(dir, "content")
+	encryptedFile := filepath.Join(dir, "encrypted")
+
+	data := []byte("hello, world!")
+	if err = os.WriteFile(contentFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// OpenSSL doesn't support ChaCha20-Poly1305 in CMS.
+	tests := []struct {
+		cert *x509.Certificate
+		key  crypto.PrivateKey
+		algo asn1.ObjectIdentifier
+	}{
+		{leaf.Certificate, leaf.PrivateKey, oid.EncryptionAlgorithmAES256GCM},
+		{intermediate.Certificate, intermediate.PrivateKey, oid.EncryptionAlgorithmAES128GCM},
+	}
+
+	for _, test := range tests {
+		certFile, keyFile := writeRecipientPEM(t, dir, test.cert, test.key)
+
+		// Decrypt our message with OpenSSL.
+		opts := AuthEncryptOptions{EncryptOptions: EncryptOptions{ContentEncryptionAlgorithm: test.algo}}
+		der, err := AuthEncryptWithOptions(data, []*x509.Certificate{test.cert}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(encryptedFile, der, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command(opensslPath, "cms", "-decrypt", "-binary",
+			"-in", encryptedFile, "-inform", "DER",
+			"-recip", certFile, "-inkey", keyFile).CombinedOutput()
+		if err != nil {
+			t.Fatal(err, string(out))
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("bad data decrypted by openssl: %q", out)
+		}
+
+		// Decrypt OpenSSL's streamed BER message.
+		if out, err := exec.Command(opensslPath, "cms", "-encrypt", "-binary", "-stream", "-aes-256-gcm",
+			"-in", contentFile, "-outform", "DER", "-out", encryptedFile, "-recip", certFile).CombinedOutput(); err != nil {
+			t.Fatal(err, string(out))
+		}
+
+		ber, err := os.ReadFile(encryptedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aed, err := ParseAuthEnvelopedData(ber)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := aed.Decrypt(test.cert, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+	}
+}
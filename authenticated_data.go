@@ -0,0 +1,162 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// AuthenticateOptions customizes how messages are authenticated by
+// AuthenticateWithOptions. The zero value uses HMAC-SHA256 and the defaults
+// described for protocol.RecipientInfoOptions.
+type AuthenticateOptions struct {
+	// MACHash is the hash used with HMAC, such as crypto.SHA384. SHA-256 is
+	// used if it's zero.
+	MACHash crypto.Hash
+
+	// RecipientInfoOptions customize the RecipientInfos created for each
+	// recipient.
+	protocol.RecipientInfoOptions
+
+	// Passwords can also be used to verify the message. A
+	// PasswordRecipientInfo using PBKDF2 is added for each.
+	Passwords [][]byte
+
+	// KeyEncryptionKeys are previously distributed AES keys that can also be
+	// used to verify the message. A KEKRecipientInfo is added for each.
+	KeyEncryptionKeys []KeyEncryptionKey
+
+	// AuthenticatedAttributes are protected by the MAC along with the content.
+	// The content-type and message-digest attributes are added to them.
+	AuthenticatedAttributes protocol.Attributes
+}
+
+// AuthenticatedData represents a message authenticated with a MAC, whose key is
+// given to each recipient.
+type AuthenticatedData struct {
+	pad *protocol.AuthenticatedData
+}
+
+// Authenticate creates a CMS AuthenticatedData with an HMAC-SHA256 over the
+// content, giving the MAC key to each of the recipients' certificates.
+// Recipients are handled as for Encrypt. The content isn't encrypted. The DER
+// encoded CMS message is returned.
+func Authenticate(data []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return AuthenticateWithOptions(data, recipients, AuthenticateOptions{})
+}
+
+// AuthenticateWithOptions is like Authenticate, but allows the caller to
+// customize the MAC, recipients and authenticated attributes with opts.
+// Passwords and key-encryption keys given in opts can also be used to verify
+// the message, in which case recipients may be empty.
+func AuthenticateWithOptions(data []byte, recipients []*x509.Certificate, opts AuthenticateOptions) ([]byte, error) {
+	hash := opts.MACHash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+
+	eci, err := protocol.NewDataEncapsulatedContentInfo(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pad, key, err := protocol.NewAuthenticatedData(eci, hash, opts.AuthenticatedAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = addRecipientInfos(&pad.RecipientInfos, recipients, opts.Passwords, opts.KeyEncryptionKeys, key, opts.RecipientInfoOptions); err != nil {
+		return nil, err
+	}
+
+	return pad.ContentInfoDER()
+}
+
+// ParseAuthenticatedData parses an AuthenticatedData from BER encoded data.
+func ParseAuthenticatedData(ber []byte) (*AuthenticatedData, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	pad, err := ci.AuthenticatedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthenticatedData{pad}, nil
+}
+
+// GetData gets the encapsulated data from the AuthenticatedData. It can't be
+// trusted until the message has been verified.
+func (ad *AuthenticatedData) GetData() ([]byte, error) {
+	return ad.pad.EncapContentInfo.DataEContent()
+}
+
+// Verify checks the MAC using the MAC key decrypted with the private key for
+// the recipient's certificate, returning the content. The key is used as
+// described for EnvelopedData.Decrypt. A protocol.ErrNoRecipient is returned
+// if the message wasn't authenticated for cert, a protocol.ErrDecryption if the
+// MAC key can't be decrypted and a protocol.ErrMACVerification if the MAC
+// doesn't match.
+func (ad *AuthenticatedData) Verify(cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	keySize, err := ad.pad.MACKeySize()
+	if err != nil {
+		return nil, err
+	}
+
+	macKey, err := decryptKey(ad.pad.RecipientInfos, keySize, cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ad.verify(macKey)
+}
+
+// VerifyWithPassword checks the MAC using a password given to
+// AuthenticateWithOptions, returning the content. A protocol.ErrNoRecipient is
+// returned if the message wasn't authenticated for a password, a
+// protocol.ErrDecryption if the password is wrong and a
+// protocol.ErrMACVerification if the MAC doesn't match.
+func (ad *AuthenticatedData) VerifyWithPassword(password []byte) ([]byte, error) {
+	return decryptWithPassword(ad.pad.RecipientInfos, password, ad.verify)
+}
+
+// VerifyWithKeyEncryptionKey checks the MAC using a key-encryption key given
+// to AuthenticateWithOptions, returning the content. A protocol.ErrNoRecipient
+// is returned if the message wasn't authenticated for kek.ID, a
+// protocol.ErrDecryption if kek.Key is wrong and a
+// protocol.ErrMACVerification if the MAC doesn't match.
+func (ad *AuthenticatedData) VerifyWithKeyEncryptionKey(kek KeyEncryptionKey) ([]byte, error) {
+	macKey, err := decryptWithKeyEncryptionKey(ad.pad.RecipientInfos, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return ad.verify(macKey)
+}
+
+// verify checks the MAC with macKey and returns the content.
+func (ad *AuthenticatedData) verify(macKey []byte) ([]byte, error) {
+	if err := ad.pad.Verify(macKey); err != nil {
+		return nil, err
+	}
+
+	return ad.GetData()
+}
+
+// AuthenticatedAttributes gets a copy of the message's authenticated
+// attributes. They can't be trusted until the message has been verified.
+func (ad *AuthenticatedData) AuthenticatedAttributes() protocol.Attributes {
+	return copyAttributes(ad.pad.AuthAttrs)
+}
+
+// GetAuthenticatedAttribute decodes the only value of the authenticated
+// attribute with the given type into val. protocol.ErrNoAttribute is returned
+// if there's no such attribute. Like AuthenticatedAttributes, the value can't
+// be trusted until the message has been verified.
+func (ad *AuthenticatedData) GetAuthenticatedAttribute(typ asn1.ObjectIdentifier, val interface{}) error {
+	return getAttribute(ad.pad.AuthAttrs, typ, val)
+}
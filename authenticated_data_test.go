@@ -0,0 +1,230 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestAuthenticate(t *testing.T) {
+	data := []byte("hello, world!")
+	password := []byte("correct horse battery staple")
+	kek := KeyEncryptionKey{ID: []byte("backup"), Key: bytes.Repeat([]byte{0x01}, 16)}
+
+	signingTime := time.Now().UTC().Truncate(time.Second)
+	attr, err := protocol.NewAttribute(oid.AttributeSigningTime, signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kemCerts, kemKeys := newKEMRecipients(t)
+	recipients := append([]*x509.Certificate{leaf.Certificate, intermediate.Certificate}, kemCerts...)
+	keys := append([]crypto.PrivateKey{leaf.PrivateKey, intermediate.PrivateKey}, kemKeys...)
+
+	for _, hash := range []crypto.Hash{0, crypto.SHA384, crypto.SHA512} {
+		for _, authAttrs := range []protocol.Attributes{nil, {attr}} {
+			opts := AuthenticateOptions{
+				MACHash:                 hash,
+				RecipientInfoOptions:    protocol.RecipientInfoOptions{PasswordIterationCount: 1000},
+				Passwords:               [][]byte{password},
+				KeyEncryptionKeys:       []KeyEncryptionKey{kek},
+				AuthenticatedAttributes: authAttrs,
+			}
+
+			der, err := AuthenticateWithOptions(data, recipients, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ad, err := ParseAuthenticatedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ad.pad.Version != 0 {
+				t.Fatalf("expected version 0, got %d", ad.pad.Version)
+			}
+
+			expectedHash := hash
+			if expectedHash == 0 {
+				expectedHash = crypto.SHA256
+			}
+			if macHash, err := ad.pad.MACHash(); err != nil {
+				t.Fatal(err)
+			} else if macHash != expectedHash {
+				t.Fatalf("expected %v, got %v", expectedHash, macHash)
+			}
+
+			// The message-digest and content-type attributes are only added
+			// along with other authenticated attributes.
+			if authAttrs == nil {
+				if len(ad.pad.AuthAttrs) != 0 || len(ad.pad.DigestAlgorithm.Algorithm) != 0 {
+					t.Fatal("expected no authenticated attributes or digest algorithm")
+				}
+			} else {
+				if len(ad.pad.AuthAttrs) != 3 {
+					t.Fatalf("expected 3 authenticated attributes, got %d", len(ad.pad.AuthAttrs))
+				}
+
+				var st time.Time
+				if err = ad.GetAuthenticatedAttribute(oid.AttributeSigningTime, &st); err != nil {
+					t.Fatal(err)
+				} else if !st.Equal(signingTime) {
+					t.Fatalf("expected signing time %v, got %v", signingTime, st)
+				}
+			}
+
+			for i, cert := range recipients {
+				content, err := ad.Verify(cert, keys[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(content, data) {
+					t.Fatal("bad content")
+				}
+			}
+
+			content, err := ad.VerifyWithPassword(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, data) {
+				t.Fatal("bad content")
+			}
+
+			if content, err = ad.VerifyWithKeyEncryptionKey(kek); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, data) {
+				t.Fatal("bad content")
+			}
+		}
+	}
+}
+
+func TestAuthenticatedDataVerifyErrors(t *testing.T) {
+	data := []byte("hello, world!")
+	password := []byte("correct horse battery staple")
+
+	attr, err := protocol.NewAttribute(oid.AttributeSigningTime, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, authAttrs := range []protocol.Attributes{nil, {attr}} {
+		opts := AuthenticateOptions{
+			RecipientInfoOptions:    protocol.RecipientInfoOptions{PasswordIterationCount: 1000},
+			Passwords:               [][]byte{password},
+			AuthenticatedAttributes: authAttrs,
+		}
+
+		der, err := AuthenticateWithOptions(data, []*x509.Certificate{intermediate.Certificate}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parse := func() *AuthenticatedData {
+			ad, err := ParseAuthenticatedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ad
+		}
+
+		if _, err = parse().Verify(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrNoRecipient) {
+			t.Fatalf("expected ErrNoRecipient, got %v", err)
+		}
+		if _, err = parse().VerifyWithPassword([]byte("wrong password")); !errors.Is(err, protocol.ErrDecryption) {
+			t.Fatalf("expected ErrDecryption, got %v", err)
+		}
+		if _, err = parse().VerifyWithKeyEncryptionKey(KeyEncryptionKey{ID: []byte("unknown"), Key: make([]byte, 16)}); !errors.Is(err, protocol.ErrNoRecipient) {
+			t.Fatalf("expected ErrNoRecipient, got %v", err)
+		}
+
+		ad := parse()
+		ad.pad.MAC[0] ^= 0xFF
+		if _, err = ad.Verify(intermediate.Certificate, intermediate.PrivateKey); !errors.Is(err, protocol.ErrMACVerification) {
+			t.Fatalf("expected ErrMACVerification, got %v", err)
+		}
+
+		// The content is the end of the encoded eContent.
+		ad = parse()
+		econtent := ad.pad.EncapContentInfo.EContent.Bytes
+		econtent[len(econtent)-1] ^= 0xFF
+		if _, err = ad.Verify(intermediate.Certificate, intermediate.PrivateKey); !errors.Is(err, protocol.ErrMACVerification) {
+			t.Fatalf("expected ErrMACVerification, got %v", err)
+		}
+		if _, err = ad.VerifyWithPassword(password); !errors.Is(err, protocol.ErrMACVerification) {
+			t.Fatalf("expected ErrMACVerification, got %v", err)
+		}
+
+		if authAttrs == nil {
+			continue
+		}
+
+		// Changing the authenticated attributes invalidates the MAC.
+		otherAttr, err := protocol.NewAttribute(oid.AttributeSigningTime, time.Now().UTC().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad = parse()
+		ad.pad.AuthAttrs = append(ad.pad.AuthAttrs.RemoveAttribute(oid.AttributeSigningTime), otherAttr)
+		if _, err = ad.Verify(intermediate.Certificate, intermediate.PrivateKey); !errors.Is(err, protocol.ErrMACVerification) {
+			t.Fatalf("expected ErrMACVerification, got %v", err)
+		}
+
+		ad = parse()
+		ad.pad.AuthAttrs = nil
+		if _, err = ad.Verify(intermediate.Certificate, intermediate.PrivateKey); !errors.Is(err, protocol.ErrMACVerification) {
+			t.Fatalf("expected ErrMACVerification, got %v", err)
+		}
+	}
+
+	ctAttr, err := protocol.NewAttribute(oid.AttributeContentType, oid.ContentTypeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := AuthenticateOptions{AuthenticatedAttributes: protocol.Attributes{ctAttr}}
+	if _, err = AuthenticateWithOptions(data, []*x509.Certificate{leaf.Certificate}, opts); err == nil {
+		t.Fatal("expected error for duplicate content-type attribute")
+	}
+
+	opts = AuthenticateOptions{MACHash: crypto.MD5}
+	if _, err = AuthenticateWithOptions(data, []*x509.Certificate{leaf.Certificate}, opts); !errors.Is(err, protocol.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	if _, err = Authenticate(data, nil); err == nil {
+		t.Fatal("expected error authenticating without recipients")
+	}
+}
+
+func TestAuthenticatedDataOtherContentType(t *testing.T) {
+	eci, err := protocol.NewEncapsulatedContentInfo(oid.ContentTypeTSTInfo, []byte("not really a TSTInfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Authenticated attributes are required for content types other than
+	// id-data.
+	pad, key, err := protocol.NewAuthenticatedData(eci, crypto.SHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pad.AuthAttrs) != 2 {
+		t.Fatalf("expected 2 authenticated attributes, got %d", len(pad.AuthAttrs))
+	}
+	if err = pad.Verify(key); err != nil {
+		t.Fatal(err)
+	}
+
+	pad.AuthAttrs = nil
+	if err = pad.Verify(key); err == nil {
+		t.Fatal("expected error verifying without authenticated attributes")
+	}
+}
@@ -0,0 +1,670 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+	"github.com/github/ietf-cms/timestamp"
+)
+
+// CAdESLevel is a CAdES baseline signature level, as defined by ETSI EN 319
+// 122-1. Each level includes the requirements of the levels below it.
+type CAdESLevel int
+
+const (
+	// CAdESNone is the level of signatures that don't meet B-B.
+	CAdESNone CAdESLevel = iota
+
+	// CAdESBB signatures have the signing-time and signing-certificate-v2 (or
+	// legacy signing-certificate) signed attributes.
+	CAdESBB
+
+	// CAdESBT signatures also have a signature-time-stamp.
+	CAdESBT
+
+	// CAdESBLT signatures also contain the certificates and revocation data
+	// needed to validate the signer's and TSA's chains.
+	CAdESBLT
+
+	// CAdESBLTA signatures also have an archive-time-stamp-v3 covering the
+	// signature and its validation data.
+	CAdESBLTA
+)
+
+// String implements the fmt.Stringer interface.
+func (l CAdESLevel) String() string {
+	switch l {
+	case CAdESBB:
+		return "B-B"
+	case CAdESBT:
+		return "B-T"
+	case CAdESBLT:
+		return "B-LT"
+	case CAdESBLTA:
+		return "B-LTA"
+	default:
+		return "none"
+	}
+}
+
+// CAdESValidationData is the validation data added by UpgradeCAdESToBLT.
+type CAdESValidationData struct {
+	// Certificates are any certificates needed to build the signers' and
+	// TSAs' chains that aren't in the SignedData or timestamp tokens yet.
+	Certificates []*x509.Certificate
+
+	// CRLs are DER encoded CRLs.
+	CRLs [][]byte
+
+	// OCSPResponses are DER encoded OCSP responses.
+	OCSPResponses [][]byte
+}
+
+// SignCAdES adds a CAdES B-B signature to the SignedData. It is like
+// SignWithOptions, but doesn't allow the signing-time or
+// signing-certificate-v2 attributes to be omitted.
+func (sd *SignedData) SignCAdES(chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) error {
+	if opts.OmitSigningTime || opts.OmitSigningCertificate {
+		return errors.New("CAdES requires signing-time and signing-certificate-v2 attributes")
+	}
+
+	return sd.SignWithOptions(chain, signer, opts)
+}
+
+// UpgradeCAdESToBT upgrades B-B signatures to B-T by adding a
+// signature-time-stamp from the RFC3161 timestamping service at the given URL.
+// SignerInfos that already have a timestamp are left alone.
+func (sd *SignedData) UpgradeCAdESToBT(url string) error {
+	attrs := make([]*protocol.Attribute, len(sd.psd.SignerInfos))
+
+	// Fetch all timestamp tokens before adding any to sd. This avoids a partial
+	// failure.
+	for i, si := range sd.psd.SignerInfos {
+		if !isCAdESBB(si) {
+			return fmt.Errorf("signer %d isn't a CAdES B-B signature", i)
+		}
+
+		if hasTS, err := hasTimestamp(si); err != nil {
+			return err
+		} else if hasTS {
+			continue
+		}
+
+		attr, err := fetchTS(url, si)
+		if err != nil {
+			return err
+		}
+		attrs[i] = &attr
+	}
+
+	for i, attr := range attrs {
+		if attr != nil {
+			sd.psd.SignerInfos[i].UnsignedAttrs = append(sd.psd.SignerInfos[i].UnsignedAttrs, *attr)
+		}
+	}
+
+	return nil
+}
+
+// UpgradeCAdESToBLT upgrades B-T signatures to B-LT by adding validation data
+// to the SignedData's certificates and crls. The TSAs' certificates are copied
+// from the signature timestamps too. The caller is responsible for providing
+// the rest of the certificates and revocation data needed to validate the
+// signers' and TSAs' chains.
+func (sd *SignedData) UpgradeCAdESToBLT(vd CAdESValidationData) error {
+	certs := []*x509.Certificate{}
+
+	for i, si := range sd.psd.SignerInfos {
+		rv, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
+		if err != nil {
+			return fmt.Errorf("signer %d isn't a CAdES B-T signature: %v", i, err)
+		}
+
+		tst, err := ParseSignedData(rv.FullBytes)
+		if err != nil {
+			return err
+		}
+
+		tsCerts, err := tst.GetCertificates()
+		if err != nil {
+			return err
+		}
+		certs = append(certs, tsCerts...)
+	}
+
+	if err := sd.addCertificates(append(certs, vd.Certificates...)); err != nil {
+		return err
+	}
+
+	for _, crl := range vd.CRLs {
+		if err := sd.psd.AddCRL(crl); err != nil {
+			return err
+		}
+	}
+
+	for _, resp := range vd.OCSPResponses {
+		if err := sd.AddOCSPResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpgradeCAdESToBLTA upgrades B-LT signatures to B-LTA by adding an
+// archive-time-stamp-v3 from the RFC3161 timestamping service at the given
+// URL. Calling this again on a B-LTA signature adds another archive timestamp,
+// which can be used to renew the signature before the previous timestamp's
+// algorithms or certificates become untrustworthy. Any new validation data
+// should be added with UpgradeCAdESToBLT first. Each signer's chain, up to a
+// self-signed trust anchor, must be in the SignedData's certificates, with
+// revocation data for every certificate other than the anchor that was current
+// when the signature was timestamped or was issued later.
+func (sd *SignedData) UpgradeCAdESToBLTA(url string) error {
+	content, err := sd.psd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return errors.New("detached signature")
+	}
+
+	return sd.upgradeCAdESToBLTA(url, content)
+}
+
+// UpgradeCAdESToBLTADetached is like UpgradeCAdESToBLTA, but for detached
+// signatures over the provided data message.
+func (sd *SignedData) UpgradeCAdESToBLTADetached(url string, message []byte) error {
+	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return errors.New("signature not detached")
+	}
+
+	return sd.upgradeCAdESToBLTA(url, message)
+}
+
+func (sd *SignedData) upgradeCAdESToBLTA(url string, content []byte) error {
+	attrs := make([]protocol.Attribute, len(sd.psd.SignerInfos))
+
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		return err
+	}
+
+	// Fetch all timestamp tokens before adding any to sd. This avoids a partial
+	// failure.
+	for i, si := range sd.psd.SignerInfos {
+		if hasTS, err := hasTimestamp(si); err != nil {
+			return err
+		} else if !hasTS {
+			return fmt.Errorf("signer %d isn't a CAdES B-LT signature", i)
+		}
+
+		chain, err := signerChain(si, certs)
+		if err != nil {
+			return fmt.Errorf("signer %d isn't a CAdES B-LT signature: %v", i, err)
+		}
+		tsti, err := parseTimestamp(si)
+		if err != nil {
+			return err
+		}
+		if ok, err := sd.hasValidationData(certs, tsti.GenTime, chain); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("signer %d isn't a CAdES B-LT signature", i)
+		}
+
+		hash, err := si.Hash()
+		if err != nil {
+			return err
+		}
+
+		index, err := sd.atsHashIndex(si, hash)
+		if err != nil {
+			return err
+		}
+		indexDER, err := asn1.Marshal(index)
+		if err != nil {
+			return err
+		}
+
+		mi, err := sd.archiveTimestampImprint(si, content, hash, indexDER)
+		if err != nil {
+			return err
+		}
+
+		tst, err := doTSRequest(url, newTSRequest(mi))
+		if err != nil {
+			return err
+		}
+
+		// The hash index is stored as an unsigned attribute of the timestamp
+		// token's SignerInfo.
+		tsd, err := tst.SignedDataContent()
+		if err != nil {
+			return err
+		}
+		if len(tsd.SignerInfos) != 1 {
+			return protocol.ASN1Error{Message: "expected one SignerInfo in timestamp token"}
+		}
+		indexAttr, err := protocol.NewAttribute(oid.AttributeATSHashIndexV3, asn1.RawValue{FullBytes: indexDER})
+		if err != nil {
+			return err
+		}
+		tsd.SignerInfos[0].UnsignedAttrs = append(tsd.SignerInfos[0].UnsignedAttrs, indexAttr)
+
+		if tst, err = tsd.ContentInfo(); err != nil {
+			return err
+		}
+		if attrs[i], err = protocol.NewAttribute(oid.AttributeArchiveTimestampV3, tst); err != nil {
+			return err
+		}
+	}
+
+	for i := range attrs {
+		sd.psd.SignerInfos[i].UnsignedAttrs = append(sd.psd.SignerInfos[i].UnsignedAttrs, attrs[i])
+	}
+
+	return nil
+}
+
+// VerifyCAdES is like Verify, but reports the CAdES baseline level reached by
+// each SignerInfo, in order. An error is returned if any signature is invalid.
+// The timestamps and revocation data are validated using opts, but the
+// revocation data is only checked for presence and freshness at the time of
+// the signature timestamp, not for the certificates' status.
+func (sd *SignedData) VerifyCAdES(opts x509.VerifyOptions) ([]CAdESLevel, error) {
+	chains, err := sd.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := sd.psd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.cadesLevels(content, chains, opts)
+}
+
+// VerifyCAdESDetached is like VerifyCAdES, but for detached signatures over
+// the provided data message.
+func (sd *SignedData) VerifyCAdESDetached(message []byte, opts x509.VerifyOptions) ([]CAdESLevel, error) {
+	chains, err := sd.VerifyDetached(message, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.cadesLevels(message, chains, opts)
+}
+
+func (sd *SignedData) cadesLevels(content []byte, chains [][][]*x509.Certificate, opts x509.VerifyOptions) ([]CAdESLevel, error) {
+	v, err := sd.newVerifier(VerifyOptions{VerifyOptions: opts})
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make([]CAdESLevel, len(sd.psd.SignerInfos))
+	for i, si := range sd.psd.SignerInfos {
+		if levels[i], err = sd.cadesLevel(si, content, chains[i][0], v.tsOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	return levels, nil
+}
+
+// cadesLevel determines the level of a SignerInfo whose signature, signer
+// chain and timestamp have already been verified.
+func (sd *SignedData) cadesLevel(si protocol.SignerInfo, content []byte, chain []*x509.Certificate, tsOpts VerifyOptions) (CAdESLevel, error) {
+	if !isCAdESBB(si) {
+		return CAdESNone, nil
+	}
+
+	if hasTS, err := hasTimestamp(si); err != nil {
+		return CAdESNone, err
+	} else if !hasTS {
+		return CAdESBB, nil
+	}
+
+	rv, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
+	if err != nil {
+		return CAdESNone, err
+	}
+	tsti, tst, tsChain, err := verifyTimestampToken(rv.FullBytes, tsOpts)
+	if err != nil {
+		return CAdESNone, err
+	}
+
+	// The TSA's certificates may be in the timestamp token rather than the
+	// SignedData.
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		return CAdESNone, err
+	}
+	tsCerts, err := tst.GetCertificates()
+	if err != nil {
+		return CAdESNone, err
+	}
+	certs = append(certs, tsCerts...)
+
+	if ok, err := sd.hasValidationData(certs, tsti.GenTime, chain, tsChain[0]); err != nil {
+		return CAdESNone, err
+	} else if !ok {
+		return CAdESBT, nil
+	}
+
+	if !sd.hasArchiveTimestamp(si, content, tsOpts) {
+		return CAdESBLT, nil
+	}
+
+	return CAdESBLTA, nil
+}
+
+// isCAdESBB checks if si has the signed attributes required for B-B.
+func isCAdESBB(si protocol.SignerInfo) bool {
+	if !si.SignedAttrs.HasAttribute(oid.AttributeSigningTime) {
+		return false
+	}
+
+	return si.SignedAttrs.HasAttribute(oid.AttributeSigningCertificateV2) ||
+		si.SignedAttrs.HasAttribute(oid.AttributeSigningCertificate)
+}
+
+// hasValidationData checks that every certificate in the chains, other than
+// the trust anchors, is in certs and that the SignedData has a CRL or OCSP
+// response from its issuer that can be used to check its status at the time
+// of the signature timestamp, t.
+func (sd *SignedData) hasValidationData(certs []*x509.Certificate, t time.Time, chains ...[]*x509.Certificate) (bool, error) {
+	crls, err := sd.GetCRLs()
+	if err != nil {
+		return false, err
+	}
+
+	ocsps, err := sd.GetOCSPResponses()
+	if err != nil {
+		return false, err
+	}
+
+	for _, chain := range chains {
+		for i := 0; i < len(chain)-1; i++ {
+			cert, issuer := chain[i], chain[i+1]
+
+			if !containsCertificate(certs, cert) {
+				return false, nil
+			}
+
+			if !hasRevocationData(cert, issuer, crls, ocsps, t) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// signerChain builds si's certificate chain from certs, up to a self-signed
+// trust anchor. The chain isn't verified, since the caller only needs it to
+// find the validation data that has to be present.
+func signerChain(si protocol.SignerInfo, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	cert, err := si.FindCertificate(certs)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []*x509.Certificate{cert}
+	for len(chain) <= len(certs) {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return chain, nil
+		}
+
+		if cert = findIssuer(certs, cert); cert == nil {
+			return nil, fmt.Errorf("missing issuer of certificate %s", chain[len(chain)-1].Subject)
+		}
+		chain = append(chain, cert)
+	}
+
+	return nil, errors.New("certificate chain loops")
+}
+
+// findIssuer finds the certificate in certs that issued cert.
+func findIssuer(certs []*x509.Certificate, cert *x509.Certificate) *x509.Certificate {
+	for _, c := range certs {
+		if bytes.Equal(c.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasRevocationData checks if there is a CRL or OCSP response for cert from
+// its issuer that was current at t or issued after it. These are the same ones
+// checkCRL and checkOCSP use, but a revoked status still counts as revocation
+// data.
+func hasRevocationData(cert, issuer *x509.Certificate, crls []*x509.RevocationList, ocsps [][]byte, t time.Time) bool {
+	if found, _ := checkOCSP(cert, issuer, ocsps, t); found {
+		return true
+	}
+
+	found, _ := checkCRL(cert, issuer, crls, t)
+	return found
+}
+
+// hasArchiveTimestamp checks if si has a valid archive-time-stamp-v3.
+func (sd *SignedData) hasArchiveTimestamp(si protocol.SignerInfo, content []byte, tsOpts VerifyOptions) bool {
+	vals, err := si.UnsignedAttrs.GetValues(oid.AttributeArchiveTimestampV3)
+	if err != nil {
+		return false
+	}
+
+	for _, val := range vals {
+		for _, tst := range val.Elements {
+			if sd.checkArchiveTimestamp(si, content, tst.FullBytes, tsOpts) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkArchiveTimestamp verifies an archive-time-stamp-v3 token. Everything
+// listed in its hash index must still be present and the message imprint
+// must match the current signature.
+func (sd *SignedData) checkArchiveTimestamp(si protocol.SignerInfo, content, der []byte, tsOpts VerifyOptions) error {
+	tsti, tst, _, err := verifyTimestampToken(der, tsOpts)
+	if err != nil {
+		return err
+	}
+
+	if len(tst.psd.SignerInfos) != 1 {
+		return protocol.ASN1Error{Message: "expected one SignerInfo in timestamp token"}
+	}
+	indexRV, err := tst.psd.SignerInfos[0].UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeATSHashIndexV3)
+	if err != nil {
+		return err
+	}
+
+	index, err := protocol.ParseATSHashIndexV3(indexRV.FullBytes)
+	if err != nil {
+		return err
+	}
+
+	indexHash, err := index.Hash()
+	if err != nil {
+		return err
+	}
+
+	current, err := sd.atsHashIndex(si, indexHash)
+	if err != nil {
+		return err
+	}
+
+	if !containsDigests(current.CertificatesHashIndex, index.CertificatesHashIndex) ||
+		!containsDigests(current.CRLsHashIndex, index.CRLsHashIndex) ||
+		!containsDigests(current.UnsignedAttrValuesHashIndex, index.UnsignedAttrValuesHashIndex) {
+		return errors.New("archive timestamp covers missing data")
+	}
+
+	// The hash index algorithm must be the one used for the message imprint
+	// (ETSI EN 319 122-1 section 5.5.2).
+	if hash, err := tsti.MessageImprint.Hash(); err != nil {
+		return err
+	} else if hash != indexHash {
+		return errors.New("archive timestamp hash index and message imprint algorithms differ")
+	}
+
+	mi, err := sd.archiveTimestampImprint(si, content, indexHash, indexRV.FullBytes)
+	if err != nil {
+		return err
+	}
+	if !mi.Equal(tsti.MessageImprint) {
+		return ErrInvalidMessageImprint
+	}
+
+	return nil
+}
+
+func containsDigests(have, want [][]byte) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if bytes.Equal(h, w) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// atsHashIndex builds the ats-hash-index-v3 for si, covering the SignedData's
+// certificates and crls and si's unsigned attribute values.
+func (sd *SignedData) atsHashIndex(si protocol.SignerInfo, hash crypto.Hash) (protocol.ATSHashIndexV3, error) {
+	index := protocol.ATSHashIndexV3{
+		CertificatesHashIndex:       [][]byte{},
+		CRLsHashIndex:               [][]byte{},
+		UnsignedAttrValuesHashIndex: [][]byte{},
+	}
+
+	if hash != crypto.SHA256 {
+		digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+		if !ok {
+			return index, protocol.ErrUnsupported
+		}
+		index.HashIndAlgorithm = pkix.AlgorithmIdentifier{Algorithm: digestOID}
+	}
+
+	for _, rv := range sd.psd.Certificates {
+		index.CertificatesHashIndex = append(index.CertificatesHashIndex, digest(hash, rv.FullBytes))
+	}
+
+	for _, rv := range sd.psd.CRLs {
+		index.CRLsHashIndex = append(index.CRLsHashIndex, digest(hash, rv.FullBytes))
+	}
+
+	// Each value is hashed along with its attribute's type.
+	for _, attr := range si.UnsignedAttrs {
+		typ, err := asn1.Marshal(attr.Type)
+		if err != nil {
+			return index, err
+		}
+
+		vals, err := attr.Value()
+		if err != nil {
+			return index, err
+		}
+
+		for _, val := range vals.Elements {
+			index.UnsignedAttrValuesHashIndex = append(index.UnsignedAttrValuesHashIndex, digest(hash, typ, val.FullBytes))
+		}
+	}
+
+	return index, nil
+}
+
+// archiveTimestampImprint calculates the message imprint for an
+// archive-time-stamp-v3 (ETSI EN 319 122-1 section 5.5.3). This covers the
+// eContentType, the digest of the content, the signed fields of si and the
+// ats-hash-index-v3, all using the hash index algorithm. The content is the
+// eContent, or the external content for detached signatures, which is what the
+// message-digest attribute covers.
+func (sd *SignedData) archiveTimestampImprint(si protocol.SignerInfo, content []byte, hash crypto.Hash, indexDER []byte) (timestamp.MessageImprint, error) {
+	contentType, err := asn1.Marshal(sd.psd.EncapContentInfo.EContentType)
+	if err != nil {
+		return timestamp.MessageImprint{}, err
+	}
+
+	// The version, sid, digestAlgorithm, signedAttrs, signatureAlgorithm and
+	// signature fields, without the enclosing SEQUENCE.
+	si.UnsignedAttrs = nil
+	siDER, err := asn1.Marshal(si)
+	if err != nil {
+		return timestamp.MessageImprint{}, err
+	}
+	var siSeq asn1.RawValue
+	if _, err = asn1.Unmarshal(siDER, &siSeq); err != nil {
+		return timestamp.MessageImprint{}, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(contentType)
+	buf.Write(digest(hash, content))
+	buf.Write(siSeq.Bytes)
+	buf.Write(indexDER)
+
+	return timestamp.NewMessageImprint(hash, buf)
+}
+
+// addCertificates adds the certs that aren't in the SignedData yet.
+func (sd *SignedData) addCertificates(certs []*x509.Certificate) error {
+	existing, err := sd.GetCertificates()
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		if containsCertificate(existing, cert) {
+			continue
+		}
+
+		if err = sd.psd.AddCertificate(cert); err != nil {
+			return err
+		}
+		existing = append(existing, cert)
+	}
+
+	return nil
+}
+
+func digest(hash crypto.Hash, data ...[]byte) []byte {
+	h := hash.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
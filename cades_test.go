@@ -0,0 +1,386 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/github/fakeca"
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/timestamp"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCAdES(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hello, world!")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignCAdES(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{OmitSigningTime: true}); err == nil {
+		t.Fatal("expected error for missing signing-time")
+	}
+	if err = sd.SignCAdES(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBB)
+
+	// B-LT and B-LTA need B-T first.
+	if err = sd.UpgradeCAdESToBLT(CAdESValidationData{}); err == nil {
+		t.Fatal("expected error upgrading B-B to B-LT")
+	}
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err == nil {
+		t.Fatal("expected error upgrading B-B to B-LTA")
+	}
+
+	if err = sd.UpgradeCAdESToBT("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBT)
+
+	// Revocation data for the leaf and TSA, but not for their intermediates.
+	if err = sd.UpgradeCAdESToBLT(CAdESValidationData{
+		CRLs:          [][]byte{createCRL(t, crlIntermediate)},
+		OCSPResponses: [][]byte{createOCSPResponse(t, intermediate, tsa.ident)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBT)
+
+	// B-LTA needs the revocation data for the whole chain.
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err == nil {
+		t.Fatal("expected error upgrading to B-LTA without revocation data for the intermediates")
+	}
+
+	if err = sd.UpgradeCAdESToBLT(CAdESValidationData{
+		CRLs:          [][]byte{createCRL(t, crlRoot)},
+		OCSPResponses: [][]byte{createOCSPResponse(t, root, intermediate)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBLT)
+
+	// An unsigned attribute that will be covered by the archive timestamp.
+	signer := sd.Signers()[0]
+	if err = signer.SetUnsignedAttribute(asn1.ObjectIdentifier{1, 2, 3, 4}, "covered"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBLTA)
+
+	// Renewing adds a second archive timestamp.
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if vals, _ := signer.UnsignedAttributes().GetValues(oid.AttributeArchiveTimestampV3); len(vals) != 2 {
+		t.Fatalf("expected 2 archive timestamps, got %d", len(vals))
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBLTA)
+
+	// Removing data covered by the archive timestamps invalidates them.
+	signer.RemoveUnsignedAttribute(asn1.ObjectIdentifier{1, 2, 3, 4})
+	assertCAdESLevel(t, sd, nil, CAdESBLT)
+}
+
+func TestCAdESDetached(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hello, world!")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignCAdES(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detached()
+
+	if err = sd.UpgradeCAdESToBT("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.UpgradeCAdESToBLT(cadesValidationData(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err == nil {
+		t.Fatal("expected error for detached signature")
+	}
+	if err = sd.UpgradeCAdESToBLTADetached("https://google.com", data); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, data, CAdESBLTA)
+
+	// A plain signature doesn't meet B-B.
+	if err = sd.SignWithOptions(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{OmitSigningTime: true}); err == nil {
+		t.Fatal("expected error signing detached SignedData")
+	}
+	plain, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = plain.SignWithOptions(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{OmitSigningCertificate: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, plain, nil, CAdESNone)
+}
+
+func TestCAdESStaleRevocationData(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hello, world!")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignCAdES(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.UpgradeCAdESToBT("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The intermediate's CRL expired before the signature was timestamped, so
+	// it can't be used to check the leaf's status.
+	staleCRL, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: time.Now().Add(-time.Hour),
+	}, crlIntermediate.Certificate, crlIntermediate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.UpgradeCAdESToBLT(CAdESValidationData{
+		CRLs: [][]byte{staleCRL, createCRL(t, crlRoot)},
+		OCSPResponses: [][]byte{
+			createOCSPResponse(t, intermediate, tsa.ident),
+			createOCSPResponse(t, root, intermediate),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBT)
+	if err = sd.UpgradeCAdESToBLTA("https://google.com"); err == nil {
+		t.Fatal("expected error upgrading to B-LTA with a stale CRL")
+	}
+
+	// A CRL issued after the timestamp is fine.
+	if err = sd.UpgradeCAdESToBLT(CAdESValidationData{CRLs: [][]byte{createCRL(t, crlIntermediate)}}); err != nil {
+		t.Fatal(err)
+	}
+	assertCAdESLevel(t, sd, nil, CAdESBLT)
+}
+
+// TestCAdESArchiveTimestampImprint checks the archive-time-stamp-v3 message
+// imprint against one assembled from the encoded message, as described in ETSI
+// EN 319 122-1 section 5.5.3, rather than from the parsed structures.
+func TestCAdESArchiveTimestampImprint(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hello, world!")
+
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+		for _, detached := range []bool{false, true} {
+			sd, err := NewSignedData(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = sd.SignCAdES(crlLeaf.Chain(), crlLeaf.PrivateKey, SignOptions{DigestAlgorithm: hash}); err != nil {
+				t.Fatal(err)
+			}
+			if detached {
+				sd.Detached()
+			}
+			if err = sd.UpgradeCAdESToBT("https://google.com"); err != nil {
+				t.Fatal(err)
+			}
+			if err = sd.UpgradeCAdESToBLT(cadesValidationData(t)); err != nil {
+				t.Fatal(err)
+			}
+			if detached {
+				err = sd.UpgradeCAdESToBLTADetached("https://google.com", data)
+			} else {
+				err = sd.UpgradeCAdESToBLTA("https://google.com")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			der, err := sd.ToDER()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ci struct {
+				ContentType asn1.ObjectIdentifier
+				Content     asn1.RawValue
+			}
+			if _, err = asn1.Unmarshal(der, &ci); err != nil {
+				t.Fatal(err)
+			}
+			signedData := rawElements(t, rawElements(t, ci.Content.Bytes)[0].Bytes)
+			eci := rawElements(t, signedData[2].Bytes)
+			signerInfos := rawElements(t, signedData[len(signedData)-1].Bytes)
+			signerInfo := rawElements(t, signerInfos[0].Bytes)
+
+			// The eContent is only present if the signature isn't detached.
+			content := data
+			if len(eci) > 1 {
+				content = nil
+				if _, err = asn1.Unmarshal(eci[1].Bytes, &content); err != nil {
+					t.Fatal(err)
+				}
+			} else if !detached {
+				t.Fatal("missing eContent")
+			}
+
+			// The unsignedAttrs are the last field of the SignerInfo.
+			var token []byte
+			for _, attr := range rawElements(t, signerInfo[len(signerInfo)-1].Bytes) {
+				var a struct {
+					Type   asn1.ObjectIdentifier
+					Values asn1.RawValue
+				}
+				if _, err = asn1.Unmarshal(attr.FullBytes, &a); err != nil {
+					t.Fatal(err)
+				}
+				if a.Type.Equal(oid.AttributeArchiveTimestampV3) {
+					token = rawElements(t, a.Values.Bytes)[0].FullBytes
+				}
+			}
+			if token == nil {
+				t.Fatal("missing archive timestamp")
+			}
+
+			tst, err := ParseSignedData(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tsti, err := timestamp.ParseInfo(tst.psd.EncapContentInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, err := tst.psd.SignerInfos[0].UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeATSHashIndexV3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imprintHash, err := tsti.MessageImprint.Hash(); err != nil {
+				t.Fatal(err)
+			} else if imprintHash != hash {
+				t.Fatalf("expected %v message imprint, got %v", hash, imprintHash)
+			}
+
+			// The eContentType, the hash of the content, the SignerInfo's
+			// fields other than unsignedAttrs and the ats-hash-index-v3.
+			h := hash.New()
+			h.Write(eci[0].FullBytes)
+			h.Write(digest(hash, content))
+			for _, field := range signerInfo[:len(signerInfo)-1] {
+				h.Write(field.FullBytes)
+			}
+			h.Write(index.FullBytes)
+
+			if !bytes.Equal(tsti.MessageImprint.HashedMessage, h.Sum(nil)) {
+				t.Fatalf("bad archive timestamp message imprint with %v (detached: %v)", hash, detached)
+			}
+		}
+	}
+}
+
+// rawElements splits the contents of a constructed value into its elements.
+func rawElements(t *testing.T, contents []byte) []asn1.RawValue {
+	t.Helper()
+
+	var elements []asn1.RawValue
+	for rest := contents; len(rest) > 0; {
+		var element asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	return elements
+}
+
+// assertCAdESLevel round trips sd through DER and checks its level.
+func assertCAdESLevel(t *testing.T, sd *SignedData, message []byte, expected CAdESLevel) {
+	t.Helper()
+
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var levels []CAdESLevel
+	if message == nil {
+		levels, err = parsed.VerifyCAdES(crlRootOpts)
+	} else {
+		levels, err = parsed.VerifyCAdESDetached(message, crlRootOpts)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(levels) != 1 || levels[0] != expected {
+		t.Fatalf("expected level %s, got %v", expected, levels)
+	}
+}
+
+// cadesValidationData returns revocation data for the whole of crlLeaf's chain
+// and the TSA's chain.
+func cadesValidationData(t *testing.T) CAdESValidationData {
+	return CAdESValidationData{
+		CRLs: [][]byte{createCRL(t, crlIntermediate), createCRL(t, crlRoot)},
+		OCSPResponses: [][]byte{
+			createOCSPResponse(t, intermediate, tsa.ident),
+			createOCSPResponse(t, root, intermediate),
+		},
+	}
+}
+
+func createCRL(t *testing.T, issuer *fakeca.Identity) []byte {
+	t.Helper()
+
+	return createRevocationList(t, issuer, time.Now()).Raw
+}
+
+func createOCSPResponse(t *testing.T, issuer, subject *fakeca.Identity) []byte {
+	t.Helper()
+
+	return createOCSPResponseFrom(t, issuer, issuer, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: subject.Certificate.SerialNumber,
+		ThisUpdate:   time.Now(),
+		NextUpdate:   time.Now().Add(time.Hour),
+	})
+}
+
+// createOCSPResponseFrom creates an OCSP response about a certificate from
+// issuer, signed by responder.
+func createOCSPResponseFrom(t *testing.T, issuer, responder *fakeca.Identity, template ocsp.Response) []byte {
+	t.Helper()
+
+	if responder != issuer {
+		template.Certificate = responder.Certificate
+	}
+
+	der, err := ocsp.CreateResponse(issuer.Certificate, responder.Certificate, template, responder.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
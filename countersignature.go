@@ -0,0 +1,119 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// CounterSign countersigns this signer's signature (RFC5652 section 11.4).
+// Because the countersignature covers the signature value rather than the
+// content, this works on parsed and detached SignedData too. The
+// countersigner's chain is added to the SignedData's certificates.
+func (s Signer) CounterSign(chain []*x509.Certificate, signer crypto.Signer) error {
+	return s.CounterSignWithOptions(chain, signer, SignOptions{})
+}
+
+// CounterSignWithOptions is like CounterSign, but uses opts to select the
+// algorithms and signed attributes of the countersignature.
+func (s Signer) CounterSignWithOptions(chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) error {
+	return s.sd.psd.AddCounterSignature(s.signerInfo(), chain, signer, protocol.SignerInfoOptions(opts))
+}
+
+// CounterSignature is a verified countersignature.
+type CounterSignature struct {
+	// SignerInfo is the countersignature itself.
+	SignerInfo protocol.SignerInfo
+
+	// Chains are the verified chains for the countersigner's certificate.
+	Chains [][]*x509.Certificate
+
+	// CounterSignatures are the verified countersignatures of this
+	// countersignature.
+	CounterSignatures []CounterSignature
+}
+
+// VerifyCounterSignatures verifies the countersignatures on each SignerInfo,
+// recursively verifying any countersignatures they have in turn. The
+// countersignatures are returned in the order of the SignerInfos they
+// countersign. Each countersigner's certificate is verified using opts, or at
+// the time from the countersignature's own timestamp if it has one.
+//
+// The SignerInfos' own signatures aren't verified, since the content isn't
+// needed. Verify and VerifyDetached check countersignatures in addition to the
+// SignerInfos.
+//
+// WARNING: this function doesn't do any revocation checking.
+func (sd *SignedData) VerifyCounterSignatures(opts x509.VerifyOptions) ([][]CounterSignature, error) {
+	v, err := sd.newVerifier(VerifyOptions{VerifyOptions: opts})
+	if err != nil {
+		return nil, err
+	}
+
+	css := make([][]CounterSignature, 0, len(sd.psd.SignerInfos))
+	for _, si := range sd.psd.SignerInfos {
+		siCSs, err := v.verifyCounterSignatures(si)
+		if err != nil {
+			return nil, err
+		}
+
+		css = append(css, siCSs)
+	}
+
+	return css, nil
+}
+
+// verifyCounterSignatures verifies the countersignatures of si and any
+// countersignatures nested in them.
+func (v *verifier) verifyCounterSignatures(si protocol.SignerInfo) ([]CounterSignature, error) {
+	siCSs, err := si.CounterSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	var css []CounterSignature
+	for _, siCS := range siCSs {
+		cert, err := v.findCertificate(siCS)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the certificate is the one the signer meant to use if it's
+		// identified by a signing-certificate attribute.
+		if err = siCS.CheckSigningCertificate(cert); err != nil {
+			return nil, err
+		}
+
+		if err = si.CheckCounterSignature(siCS, cert); err != nil {
+			return nil, err
+		}
+
+		tsti, _, err := v.verifyTimestamp(siCS)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = v.checkAlgorithms(siCS, cert, tsti); err != nil {
+			return nil, err
+		}
+
+		chains, err := v.verifyCertificate(cert, tsti)
+		if err != nil {
+			return nil, err
+		}
+
+		nested, err := v.verifyCounterSignatures(siCS)
+		if err != nil {
+			return nil, err
+		}
+
+		css = append(css, CounterSignature{
+			SignerInfo:        siCS,
+			Chains:            chains,
+			CounterSignatures: nested,
+		})
+	}
+
+	return css, nil
+}
@@ -0,0 +1,140 @@
+package cms
+
+import (
+	"testing"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestCounterSign(t *testing.T) {
+	data := []byte("hello, world!")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detached()
+
+	// Countersign a parsed, detached signature.
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	notary := intermediate.Issue()
+	if err = sd.Signers()[0].CounterSign(notary.Chain(), notary.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Countersign the countersignature.
+	si := &sd.psd.SignerInfos[0]
+	css, err := si.CounterSignatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(css) != 1 {
+		t.Fatalf("expected 1 countersignature, got %d", len(css))
+	}
+	if css[0].SignedAttrs.HasAttribute(oid.AttributeContentType) {
+		t.Fatal("countersignature has content-type attribute")
+	}
+	if err = sd.psd.AddCounterSignature(&css[0], intermediate.Chain(), intermediate.PrivateKey, protocol.SignerInfoOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	attr, err := protocol.NewAttribute(oid.AttributeCounterSignature, css[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	si.UnsignedAttrs = protocol.Attributes{attr}
+
+	if der, err = sd.ToDER(); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := sd.VerifyCounterSignatures(rootOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verified) != 1 || len(verified[0]) != 1 {
+		t.Fatalf("unexpected countersignatures: %v", verified)
+	}
+	if cert := verified[0][0].Chains[0][0]; !cert.Equal(notary.Certificate) {
+		t.Fatal("expected countersignature by notary")
+	}
+	if nested := verified[0][0].CounterSignatures; len(nested) != 1 {
+		t.Fatalf("expected 1 nested countersignature, got %d", len(nested))
+	} else if cert := nested[0].Chains[0][0]; !cert.Equal(intermediate.Certificate) {
+		t.Fatal("expected nested countersignature by intermediate")
+	}
+
+	// The countersignature chains are also part of the detailed results.
+	results, err := sd.VerifyDetachedDetailed(data, VerifyOptions{VerifyOptions: rootOpts}, AllSigners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if css := results[0].CounterSignatures; len(css) != 1 {
+		t.Fatalf("expected 1 countersignature, got %d", len(css))
+	} else if cert := css[0].Chains[0][0]; !cert.Equal(notary.Certificate) {
+		t.Fatal("expected countersignature by notary")
+	} else if nested := css[0].CounterSignatures; len(nested) != 1 {
+		t.Fatalf("expected 1 nested countersignature, got %d", len(nested))
+	} else if cert := nested[0].Chains[0][0]; !cert.Equal(intermediate.Certificate) {
+		t.Fatal("expected nested countersignature by intermediate")
+	}
+}
+
+func TestCounterSignInvalid(t *testing.T) {
+	data := []byte("hello, world!")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(intermediate.Chain(), intermediate.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	signers := sd.Signers()
+	if err = signers[0].CounterSign(leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// The content-type attribute isn't allowed in countersignatures.
+	ct, _ := protocol.NewAttribute(oid.AttributeContentType, oid.ContentTypeData)
+	if err = signers[1].CounterSignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{SignedAttrs: protocol.Attributes{ct}}); err == nil {
+		t.Fatal("expected error for content-type attribute")
+	}
+
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the countersignature to the other signer, whose signature it
+	// doesn't cover.
+	sd.psd.SignerInfos[1].UnsignedAttrs = sd.psd.SignerInfos[0].UnsignedAttrs
+	sd.psd.SignerInfos[0].UnsignedAttrs = nil
+
+	if _, err = sd.Verify(rootOpts); err == nil {
+		t.Fatal("expected error for invalid countersignature")
+	}
+	if _, err = sd.VerifyCounterSignatures(rootOpts); err == nil {
+		t.Fatal("expected error for invalid countersignature")
+	}
+}
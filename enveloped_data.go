@@ -0,0 +1,164 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+// EncryptOptions customizes how messages are encrypted. The zero value uses
+// AES-256-CBC and the defaults described for protocol.RecipientInfoOptions.
+type EncryptOptions struct {
+	// ContentEncryptionAlgorithm is the OID of the algorithm used to encrypt
+	// the content, such as oid.EncryptionAlgorithmAES128CBC or
+	// oid.EncryptionAlgorithmAES256GCM. EnvelopedData has no room for an
+	// authentication tag, so EncryptWithOptions produces an AuthEnvelopedData
+	// (RFC5083) for AES-GCM and ChaCha20-Poly1305, as AuthEncryptWithOptions
+	// does. oid.EncryptionAlgorithmAES256CBC is used if it's nil.
+	ContentEncryptionAlgorithm asn1.ObjectIdentifier
+
+	// RecipientInfoOptions customize the RecipientInfos created for each
+	// recipient.
+	protocol.RecipientInfoOptions
+
+	// Passwords can also be used to decrypt the message. A
+	// PasswordRecipientInfo using PBKDF2 is added for each.
+	Passwords [][]byte
+
+	// KeyEncryptionKeys are previously distributed AES keys that can also be
+	// used to decrypt the message. A KEKRecipientInfo is added for each.
+	KeyEncryptionKeys []KeyEncryptionKey
+}
+
+// EnvelopedData represents an encrypted message.
+type EnvelopedData struct {
+	ped *protocol.EnvelopedData
+
+	// aed is set instead of ped for messages encrypted with an authenticated
+	// encryption algorithm.
+	aed *AuthEnvelopedData
+}
+
+// Encrypt creates a CMS EnvelopedData from the content, encrypting it for each
+// of the recipients' certificates. RSA recipients use key transport, EC and
+// X25519 recipients use ephemeral-static ECDH key agreement and ML-KEM
+// recipients use key encapsulation. A message can be encrypted for both a
+// classical and an ML-KEM certificate, so that it can be decrypted with
+// either, such as while migrating to post-quantum keys. ML-KEM requires Go 1.24
+// or later. The DER encoded CMS message is returned.
+func Encrypt(data []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return EncryptWithOptions(data, recipients, EncryptOptions{})
+}
+
+// EncryptWithOptions is like Encrypt, but allows the caller to customize the
+// algorithms and recipient identifiers with opts. Passwords and key-encryption
+// keys given in opts can also be used to decrypt the message, in which case
+// recipients may be empty. An AuthEnvelopedData is returned if the content
+// encryption algorithm is AES-GCM or ChaCha20-Poly1305.
+func EncryptWithOptions(data []byte, recipients []*x509.Certificate, opts EncryptOptions) ([]byte, error) {
+	algo := opts.ContentEncryptionAlgorithm
+	if algo == nil {
+		algo = oid.EncryptionAlgorithmAES256CBC
+	}
+
+	if protocol.IsAuthenticatedEncryptionAlgorithm(algo) {
+		return AuthEncryptWithOptions(data, recipients, AuthEncryptOptions{EncryptOptions: opts})
+	}
+
+	ped, key, err := protocol.NewEnvelopedData(oid.ContentTypeData, algo, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = addRecipientInfos(&ped.RecipientInfos, recipients, opts.Passwords, opts.KeyEncryptionKeys, key, opts.RecipientInfoOptions); err != nil {
+		return nil, err
+	}
+
+	return ped.ContentInfoDER()
+}
+
+// ParseEnvelopedData parses an EnvelopedData from BER encoded data. The
+// AuthEnvelopedData produced by EncryptWithOptions for authenticated encryption
+// algorithms is also accepted, so that any message it returns can be parsed and
+// decrypted the same way.
+func ParseEnvelopedData(ber []byte) (*EnvelopedData, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	if ci.ContentType.Equal(oid.ContentTypeAuthEnvelopedData) {
+		ped, err := ci.AuthEnvelopedDataContent()
+		if err != nil {
+			return nil, err
+		}
+
+		return &EnvelopedData{aed: &AuthEnvelopedData{ped}}, nil
+	}
+
+	ped, err := ci.EnvelopedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopedData{ped: ped}, nil
+}
+
+// Decrypt decrypts the content using the private key for the recipient's
+// certificate. For key transport recipients, key must be a crypto.Decrypter
+// with an RSA public key, such as an *rsa.PrivateKey or a key held in an HSM.
+// For key agreement recipients, key must be an *ecdsa.PrivateKey or a
+// protocol.ECDHPrivateKey, such as an *ecdh.PrivateKey. For ML-KEM recipients,
+// key must be a protocol.KEMPrivateKey, such as an *mlkem.DecapsulationKey768. A
+// protocol.ErrNoRecipient is returned if the message wasn't encrypted for
+// cert, and a protocol.ErrDecryption if the key or content can't be
+// decrypted.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	if ed.aed != nil {
+		return ed.aed.Decrypt(cert, key)
+	}
+
+	keySize, err := ed.ped.ContentEncryptionKeySize()
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := decryptKey(ed.ped.RecipientInfos, keySize, cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed.ped.Decrypt(cek)
+}
+
+// DecryptWithPassword decrypts the content using a password given to
+// EncryptWithOptions. A protocol.ErrNoRecipient is returned if the message
+// wasn't encrypted for a password, and a protocol.ErrDecryption if the
+// password is wrong.
+func (ed *EnvelopedData) DecryptWithPassword(password []byte) ([]byte, error) {
+	if ed.aed != nil {
+		return ed.aed.DecryptWithPassword(password)
+	}
+
+	return decryptWithPassword(ed.ped.RecipientInfos, password, ed.ped.Decrypt)
+}
+
+// DecryptWithKeyEncryptionKey decrypts the content using a key-encryption key
+// given to EncryptWithOptions. A protocol.ErrNoRecipient is returned if the
+// message wasn't encrypted for kek.ID, and a protocol.ErrDecryption if
+// kek.Key is wrong.
+func (ed *EnvelopedData) DecryptWithKeyEncryptionKey(kek KeyEncryptionKey) ([]byte, error) {
+	if ed.aed != nil {
+		return ed.aed.DecryptWithKeyEncryptionKey(kek)
+	}
+
+	cek, err := decryptWithKeyEncryptionKey(ed.ped.RecipientInfos, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed.ped.Decrypt(cek)
+}
@@ -0,0 +1,638 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/fakeca"
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestEncrypt(t *testing.T) {
+	data := []byte("hello, world!")
+
+	algos := []asn1.ObjectIdentifier{
+		oid.EncryptionAlgorithmAES128CBC,
+		oid.EncryptionAlgorithmAES192CBC,
+		oid.EncryptionAlgorithmAES256CBC,
+	}
+
+	for _, algo := range algos {
+		for _, riOpts := range []protocol.RecipientInfoOptions{{}, {OAEPHash: crypto.SHA256}, {SubjectKeyIdentifier: true}} {
+			opts := EncryptOptions{ContentEncryptionAlgorithm: algo, RecipientInfoOptions: riOpts}
+
+			der, err := EncryptWithOptions(data, []*x509.Certificate{otherRoot.Certificate, leaf.Certificate}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ed, err := ParseEnvelopedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedVersion := 0
+			if riOpts.SubjectKeyIdentifier {
+				expectedVersion = 2
+			}
+			if ed.ped.Version != expectedVersion {
+				t.Fatalf("expected version %d, got %d", expectedVersion, ed.ped.Version)
+			}
+
+			for _, ident := range []*fakeca.Identity{otherRoot, leaf} {
+				decrypted, err := ed.Decrypt(ident.Certificate, ident.PrivateKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, data) {
+					t.Fatal("bad decrypted data")
+				}
+			}
+		}
+	}
+}
+
+func TestEncryptAuthenticatedEncryptionAlgorithm(t *testing.T) {
+	data := []byte("hello, world!")
+	recipients := []*x509.Certificate{leaf.Certificate}
+	password := []byte("correct horse battery staple")
+
+	// EnvelopedData has nowhere to put an authentication tag, so these produce
+	// an AuthEnvelopedData.
+	for _, algo := range []asn1.ObjectIdentifier{oid.EncryptionAlgorithmAES128GCM, oid.EncryptionAlgorithmAES256GCM, oid.EncryptionAlgorithmChaCha20Poly1305} {
+		der, err := EncryptWithOptions(data, recipients, EncryptOptions{ContentEncryptionAlgorithm: algo, Passwords: [][]byte{password}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := Parse(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aed, ok := msg.(*AuthEnvelopedData)
+		if !ok {
+			t.Fatalf("expected *AuthEnvelopedData, got %T", msg)
+		}
+		if !aed.ped.AuthEncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm.Equal(algo) {
+			t.Fatal("bad content encryption algorithm")
+		}
+		if decrypted, err := aed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+
+		// It can be handled like any other message from EncryptWithOptions.
+		ed, err := ParseEnvelopedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := ed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+		if decrypted, err := ed.DecryptWithPassword(password); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+	}
+
+	// An EnvelopedData claiming to use one can't be decrypted.
+	der, err := Encrypt(data, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed, err := ParseEnvelopedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmAlgo, _, err := protocol.NewContentEncryptionAlgorithm(oid.EncryptionAlgorithmAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed.ped.EncryptedContentInfo.ContentEncryptionAlgorithm = gcmAlgo
+	if _, err = ed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != protocol.ErrUnsupported {
+		t.Fatalf("expected %v, got %v", protocol.ErrUnsupported, err)
+	}
+}
+
+func TestEncryptKeyAgreement(t *testing.T) {
+	data := []byte("hello, world!")
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384 := intermediate.Issue(fakeca.PrivateKey(p384Key))
+	x25519Cert, x25519Key := newX25519Recipient(t)
+
+	recipients := []*x509.Certificate{intermediate.Certificate, p384.Certificate, x25519Cert, leaf.Certificate}
+	keys := []crypto.PrivateKey{intermediate.PrivateKey, p384.PrivateKey, x25519Key, leaf.PrivateKey}
+
+	riOpts := []protocol.RecipientInfoOptions{
+		{},
+		{SubjectKeyIdentifier: true},
+		{KeyAgreementHash: crypto.SHA512, KeyWrapAlgorithm: oid.KeyWrapAlgorithmAES192},
+	}
+
+	for _, algo := range []asn1.ObjectIdentifier{oid.EncryptionAlgorithmAES128CBC, oid.EncryptionAlgorithmAES256CBC} {
+		for _, riOpts := range riOpts {
+			opts := EncryptOptions{ContentEncryptionAlgorithm: algo, RecipientInfoOptions: riOpts}
+
+			der, err := EncryptWithOptions(data, recipients, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ed, err := ParseEnvelopedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ed.ped.Version != 2 {
+				t.Fatalf("expected version 2, got %d", ed.ped.Version)
+			}
+
+			karis, err := ed.ped.RecipientInfos.KeyAgreeRecipientInfos()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(karis) != 3 {
+				t.Fatalf("expected 3 KeyAgreeRecipientInfos, got %d", len(karis))
+			}
+			for _, kari := range karis {
+				if kari.Version != 3 {
+					t.Fatalf("expected version 3, got %d", kari.Version)
+				}
+			}
+
+			for i, cert := range recipients {
+				decrypted, err := ed.Decrypt(cert, keys[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decrypted, data) {
+					t.Fatal("bad decrypted data")
+				}
+			}
+		}
+	}
+
+	der, err := Encrypt(data, []*x509.Certificate{intermediate.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := ParseEnvelopedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdhKey, err := intermediate.PrivateKey.(*ecdsa.PrivateKey).ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := ed.Decrypt(intermediate.Certificate, opaqueKey(ecdhKey)); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, data) {
+		t.Fatal("bad decrypted data")
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ed.Decrypt(intermediate.Certificate, otherKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+	if _, err = ed.Decrypt(intermediate.Certificate, p384Key); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+	if _, err = ed.Decrypt(intermediate.Certificate, leaf.PrivateKey); err == nil {
+		t.Fatal("expected error decrypting with RSA key")
+	}
+	if _, err = ed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrNoRecipient) {
+		t.Fatalf("expected ErrNoRecipient, got %v", err)
+	}
+}
+
+func TestEncryptPasswordAndKEK(t *testing.T) {
+	data := []byte("hello, world!")
+	password := []byte("correct horse battery staple")
+	keks := []KeyEncryptionKey{
+		{ID: []byte("backup-128"), Key: bytes.Repeat([]byte{0x01}, 16)},
+		{ID: []byte("backup-256"), Key: bytes.Repeat([]byte{0x02}, 32)},
+	}
+
+	for _, prf := range []crypto.Hash{0, crypto.SHA1, crypto.SHA512} {
+		opts := EncryptOptions{
+			RecipientInfoOptions: protocol.RecipientInfoOptions{PasswordPRF: prf, PasswordIterationCount: 1000},
+			Passwords:            [][]byte{[]byte("other password"), password},
+			KeyEncryptionKeys:    keks,
+		}
+
+		der, err := EncryptWithOptions(data, []*x509.Certificate{leaf.Certificate}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ed, err := ParseEnvelopedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ed.ped.Version != 3 {
+			t.Fatalf("expected version 3, got %d", ed.ped.Version)
+		}
+
+		decrypted, err := ed.DecryptWithPassword(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+
+		for _, kek := range keks {
+			if decrypted, err = ed.DecryptWithKeyEncryptionKey(kek); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("bad decrypted data")
+			}
+		}
+
+		// The certificate holder can still decrypt the message.
+		if decrypted, err = ed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+
+		if _, err = ed.DecryptWithPassword([]byte("wrong password")); !errors.Is(err, protocol.ErrDecryption) {
+			t.Fatalf("expected ErrDecryption, got %v", err)
+		}
+		if _, err = ed.DecryptWithKeyEncryptionKey(KeyEncryptionKey{ID: keks[0].ID, Key: keks[1].Key[:16]}); !errors.Is(err, protocol.ErrDecryption) {
+			t.Fatalf("expected ErrDecryption, got %v", err)
+		}
+		if _, err = ed.DecryptWithKeyEncryptionKey(KeyEncryptionKey{ID: []byte("unknown"), Key: keks[0].Key}); !errors.Is(err, protocol.ErrNoRecipient) {
+			t.Fatalf("expected ErrNoRecipient, got %v", err)
+		}
+	}
+
+	// Only a KEK recipient.
+	der, err := EncryptWithOptions(data, nil, EncryptOptions{KeyEncryptionKeys: keks[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := ParseEnvelopedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ed.ped.Version != 2 {
+		t.Fatalf("expected version 2, got %d", ed.ped.Version)
+	}
+	if _, err = ed.DecryptWithPassword(password); !errors.Is(err, protocol.ErrNoRecipient) {
+		t.Fatalf("expected ErrNoRecipient, got %v", err)
+	}
+
+	if _, err = EncryptWithOptions(data, nil, EncryptOptions{}); err == nil {
+		t.Fatal("expected error encrypting without recipients")
+	}
+	if _, err = EncryptWithOptions(data, nil, EncryptOptions{KeyEncryptionKeys: []KeyEncryptionKey{{ID: []byte("bad"), Key: []byte("short")}}}); err == nil {
+		t.Fatal("expected error encrypting with bad KEK size")
+	}
+}
+
+func TestEnvelopedDataDecryptErrors(t *testing.T) {
+	data := []byte("hello, world!")
+
+	der, err := Encrypt(data, []*x509.Certificate{leaf.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := ParseEnvelopedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ed.Decrypt(root.Certificate, root.PrivateKey); !errors.Is(err, protocol.ErrNoRecipient) {
+		t.Fatalf("expected ErrNoRecipient, got %v", err)
+	}
+
+	if decrypted, err := ed.Decrypt(leaf.Certificate, opaqueKey(leaf.PrivateKey)); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, data) {
+		t.Fatal("bad decrypted data")
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ed.Decrypt(leaf.Certificate, otherKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	ed.ped.EncryptedContentInfo.EncryptedContent.Bytes[0] ^= 0xFF
+	if _, err = ed.Decrypt(leaf.Certificate, leaf.PrivateKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Ident := intermediate.Issue(fakeca.PrivateKey(ed25519Key))
+	if _, err = Encrypt(data, []*x509.Certificate{ed25519Ident.Certificate}); err == nil {
+		t.Fatal("expected error encrypting for Ed25519 certificate")
+	}
+}
+
+func TestEncryptWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	dir := t.TempDir()
+	contentFile := filepath.Join(dir, "content")
+	encryptedFile := filepath.Join(dir, "encrypted")
+
+	data := []byte("hello, world!")
+	if err = os.WriteFile(contentFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384 := intermediate.Issue(fakeca.PrivateKey(p384Key))
+
+	// OpenSSL only matches SubjectKeyIdentifiers against the certificate's
+	// extension, which only the CA certificates have. OpenSSL 3.0 doesn't
+	// support X25519 recipients.
+	tests := []struct {
+		cert *x509.Certificate
+		key  crypto.PrivateKey
+		opts EncryptOptions
+	}{
+		{leaf.Certificate, leaf.PrivateKey, EncryptOptions{}},
+		{leaf.Certificate, leaf.PrivateKey, EncryptOptions{RecipientInfoOptions: protocol.RecipientInfoOptions{OAEPHash: crypto.SHA256}}},
+		{otherRoot.Certificate, otherRoot.PrivateKey, EncryptOptions{ContentEncryptionAlgorithm: oid.EncryptionAlgorithmAES128CBC, RecipientInfoOptions: protocol.RecipientInfoOptions{SubjectKeyIdentifier: true}}},
+		{intermediate.Certificate, intermediate.PrivateKey, EncryptOptions{}},
+		{intermediate.Certificate, intermediate.PrivateKey, EncryptOptions{RecipientInfoOptions: protocol.RecipientInfoOptions{SubjectKeyIdentifier: true, KeyAgreementHash: crypto.SHA384, KeyWrapAlgorithm: oid.KeyWrapAlgorithmAES256}}},
+		{p384.Certificate, p384.PrivateKey, EncryptOptions{}},
+	}
+
+	// Decrypt our messages with OpenSSL.
+	for _, test := range tests {
+		certFile, keyFile := writeRecipientPEM(t, dir, test.cert, test.key)
+
+		der, err := EncryptWithOptions(data, []*x509.Certificate{test.cert}, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(encryptedFile, der, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(opensslPath, "cms", "-decrypt", "-binary",
+			"-in", encryptedFile, "-inform", "DER",
+			"-recip", certFile, "-inkey", keyFile)
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatal(err, string(out))
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("bad data decrypted by openssl: %q", out)
+		}
+	}
+
+	// Decrypt OpenSSL's streamed BER messages.
+	openSSLTests := []struct {
+		cert *x509.Certificate
+		key  crypto.PrivateKey
+		args []string
+	}{
+		{leaf.Certificate, leaf.PrivateKey, []string{"-aes-128-cbc"}},
+		{leaf.Certificate, leaf.PrivateKey, []string{"-aes-256-cbc", "-keyopt", "rsa_padding_mode:oaep", "-keyopt", "rsa_oaep_md:sha256"}},
+		{intermediate.Certificate, intermediate.PrivateKey, []string{"-aes-256-cbc"}},
+		{intermediate.Certificate, intermediate.PrivateKey, []string{"-aes-128-cbc", "-keyid", "-keyopt", "ecdh_kdf_md:sha256"}},
+		{p384.Certificate, p384.PrivateKey, []string{"-aes-192-cbc", "-keyopt", "ecdh_kdf_md:sha384"}},
+	}
+	for _, test := range openSSLTests {
+		certFile, _ := writeRecipientPEM(t, dir, test.cert, test.key)
+		args := append([]string{"cms", "-encrypt", "-binary", "-stream",
+			"-in", contentFile, "-outform", "DER", "-out", encryptedFile, "-recip", certFile}, test.args...)
+
+		if out, err := exec.Command(opensslPath, args...).CombinedOutput(); err != nil {
+			t.Fatal(err, string(out))
+		}
+
+		ber, err := os.ReadFile(encryptedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ed, err := ParseEnvelopedData(ber)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := ed.Decrypt(test.cert, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+	}
+}
+
+func TestEncryptPasswordAndKEKWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	dir := t.TempDir()
+	contentFile := filepath.Join(dir, "content")
+	encryptedFile := filepath.Join(dir, "encrypted")
+
+	data := []byte("hello, world!")
+	if err = os.WriteFile(contentFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("correct horse battery staple")
+	kek := KeyEncryptionKey{ID: []byte("backup"), Key: bytes.Repeat([]byte{0x01}, 32)}
+	passwordArgs := []string{"-pwri_password", string(password)}
+	kekArgs := []string{"-secretkey", hex.EncodeToString(kek.Key), "-secretkeyid", hex.EncodeToString(kek.ID)}
+
+	// Decrypt our messages with OpenSSL.
+	opts := EncryptOptions{
+		RecipientInfoOptions: protocol.RecipientInfoOptions{PasswordIterationCount: 1000},
+		Passwords:            [][]byte{password},
+	}
+	der, err := EncryptWithOptions(data, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(encryptedFile, der, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := append([]string{"cms", "-decrypt", "-binary", "-in", encryptedFile, "-inform", "DER"}, passwordArgs...)
+	if out, err := exec.Command(opensslPath, args...).CombinedOutput(); err != nil {
+		t.Fatal(err, string(out))
+	} else if !bytes.Equal(out, data) {
+		t.Fatalf("bad data decrypted by openssl: %q", out)
+	}
+
+	if der, err = EncryptWithOptions(data, nil, EncryptOptions{KeyEncryptionKeys: []KeyEncryptionKey{kek}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(encryptedFile, der, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args = append([]string{"cms", "-decrypt", "-binary", "-in", encryptedFile, "-inform", "DER"}, kekArgs...)
+	if out, err := exec.Command(opensslPath, args...).CombinedOutput(); err != nil {
+		t.Fatal(err, string(out))
+	} else if !bytes.Equal(out, data) {
+		t.Fatalf("bad data decrypted by openssl: %q", out)
+	}
+
+	// Decrypt OpenSSL's messages.
+	for _, recipArgs := range [][]string{passwordArgs, kekArgs} {
+		args := append([]string{"cms", "-encrypt", "-binary", "-aes-128-cbc",
+			"-in", contentFile, "-outform", "DER", "-out", encryptedFile}, recipArgs...)
+		if out, err := exec.Command(opensslPath, args...).CombinedOutput(); err != nil {
+			t.Fatal(err, string(out))
+		}
+
+		ber, err := os.ReadFile(encryptedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ed, err := ParseEnvelopedData(ber)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decrypted []byte
+		if recipArgs[0] == "-pwri_password" {
+			decrypted, err = ed.DecryptWithPassword(password)
+		} else {
+			decrypted, err = ed.DecryptWithKeyEncryptionKey(kek)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatal("bad decrypted data")
+		}
+	}
+}
+
+// writeRecipientPEM writes a certificate and private key to PEM files in dir,
+// returning their names.
+// opaqueKey hides everything about key except the interface that Decrypt needs
+// for its type. A key held elsewhere, such as in an HSM, only needs to
+// implement crypto.Decrypter for RSA, protocol.ECDHPrivateKey for EC and X25519
+// or protocol.KEMPrivateKey for ML-KEM.
+func opaqueKey(key crypto.PrivateKey) crypto.PrivateKey {
+	switch key := key.(type) {
+	case protocol.KEMPrivateKey:
+		return struct{ protocol.KEMPrivateKey }{key}
+	case protocol.ECDHPrivateKey:
+		return struct{ protocol.ECDHPrivateKey }{key}
+	case crypto.Decrypter:
+		return struct{ crypto.Decrypter }{key}
+	default:
+		return key
+	}
+}
+
+func writeRecipientPEM(t *testing.T, dir string, cert *x509.Certificate, key crypto.PrivateKey) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// newX25519Recipient parses the X25519 recipient fixture. Go can't issue
+// certificates for X25519 keys.
+func newX25519Recipient(t *testing.T) (*x509.Certificate, *ecdh.PrivateKey) {
+	t.Helper()
+
+	cert, err := x509.ParseCertificate(fixtureX25519Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(fixtureX25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key.(*ecdh.PrivateKey)
+}
+
+// fixtureX25519Certificate was issued for fixtureX25519Key by an Ed25519 CA
+// using `openssl x509 -req -force_pubkey`, since X25519 keys can't sign
+// certificate requests. It has a SubjectKeyIdentifier extension.
+var fixtureX25519Certificate = mustBase64Decode("" +
+	"MIIBMTCB5KADAgECAgEBMAUGAytlcDAUMRIwEAYDVQQDDAlYMjU1MTkgQ0Ew" +
+	"IBcNMjYxMDE2MDY1NzQyWhgPMjEyNjA5MjIwNjU3NDJaMBsxGTAXBgNVBAMM" +
+	"EFgyNTUxOSByZWNpcGllbnQwKjAFBgMrZW4DIQBxso1zAwojdQunzg7fye9n" +
+	"nxDhnsgJJPJr/aYqN4h0aqNSMFAwHQYDVR0OBBYEFKVqwlPcD+0tbfG27zCB" +
+	"Khql4h38MA4GA1UdDwEB/wQEAwIDCDAfBgNVHSMEGDAWgBT2cntv+EXtdyby" +
+	"mCAjIMWNAc0FfjAFBgMrZXADQQBB51NBZkkEQDVBqm+RtaIKh95IySAu8i9p" +
+	"8BqhyhXVjv6b9m4XgF0PCuck+9dVpImGZ6niF+a4BfTUmmiCjNsC",
+)
+
+// fixtureX25519Key is the PKCS#8 encoded X25519 private key for
+// fixtureX25519Certificate.
+var fixtureX25519Key = mustBase64Decode("" +
+	"MC4CAQAwBQYDK2VuBCIEIEDzzZSpqvAZE048n3rizq/umZF7M4ZvJAHOUa2z" +
+	"apVf",
+)
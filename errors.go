@@ -0,0 +1,146 @@
+package cms
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidMessageImprint is returned when a timestamp token's message
+// imprint doesn't match the data it's meant to cover.
+var ErrInvalidMessageImprint = errors.New("invalid message imprint")
+
+// ErrKeyNotPinned is returned when VerifyOptions.PinnedKeys is set and a
+// signer's key isn't one of them.
+var ErrKeyNotPinned = errors.New("signer's key isn't pinned")
+
+// DigestMismatchError is returned when a SignerInfo's message-digest attribute
+// doesn't match the content.
+type DigestMismatchError struct {
+	// SignerIndex is the position of the SignerInfo in the SignedData.
+	SignerIndex int
+
+	// SID is the SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Expected is the value of the message-digest attribute.
+	Expected []byte
+
+	// Actual is the digest of the content.
+	Actual []byte
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("signer %d: invalid message digest", e.SignerIndex)
+}
+
+// SignatureError is returned when a SignerInfo's signature or signed
+// attributes are invalid.
+type SignatureError struct {
+	// SignerIndex is the position of the SignerInfo in the SignedData.
+	SignerIndex int
+
+	// SID is the SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signer %d: invalid signature: %v", e.SignerIndex, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// CertificateNotFoundError is returned when a SignerInfo's certificate isn't
+// among the SignedData's certificates, or doesn't match the SignerInfo's
+// signing-certificate attribute.
+type CertificateNotFoundError struct {
+	// SignerIndex is the position of the SignerInfo in the SignedData.
+	SignerIndex int
+
+	// SID is the SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Err is the underlying error, such as protocol.ErrNoCertificate or
+	// protocol.ErrSigningCertificateMismatch.
+	Err error
+}
+
+func (e *CertificateNotFoundError) Error() string {
+	return fmt.Sprintf("signer %d: certificate not found: %v", e.SignerIndex, e.Err)
+}
+
+func (e *CertificateNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// TimestampError is returned when a SignerInfo's signature timestamp is
+// invalid. If the timestamp token's own signature or chain is invalid, Err is
+// the error from verifying the token, in which the TSA is signer 0.
+type TimestampError struct {
+	// SignerIndex is the position of the SignerInfo in the SignedData.
+	SignerIndex int
+
+	// SID is the SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *TimestampError) Error() string {
+	return fmt.Sprintf("signer %d: invalid timestamp: %v", e.SignerIndex, e.Err)
+}
+
+func (e *TimestampError) Unwrap() error {
+	return e.Err
+}
+
+// ChainError is returned when a SignerInfo's certificate can't be verified,
+// because no chain to a trusted root could be built, the certificate wasn't
+// valid at the signing time, it has been revoked or its key isn't pinned.
+type ChainError struct {
+	// SignerIndex is the position of the SignerInfo in the SignedData.
+	SignerIndex int
+
+	// SID is the SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Err is the underlying error, such as an x509.UnknownAuthorityError or
+	// ErrKeyNotPinned.
+	Err error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("signer %d: invalid certificate chain: %v", e.SignerIndex, e.Err)
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// CounterSignatureError is returned when one of a SignerInfo's
+// countersignatures is invalid.
+type CounterSignatureError struct {
+	// SignerIndex is the position of the countersigned SignerInfo in the
+	// SignedData.
+	SignerIndex int
+
+	// SID is the countersigned SignerInfo's raw SignerIdentifier.
+	SID asn1.RawValue
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *CounterSignatureError) Error() string {
+	return fmt.Sprintf("signer %d: invalid countersignature: %v", e.SignerIndex, e.Err)
+}
+
+func (e *CounterSignatureError) Unwrap() error {
+	return e.Err
+}
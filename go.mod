@@ -1,10 +1,13 @@
 // Deprecated: Use the "github.com/github/smimesign/ietf-cms" module instead.
 module github.com/github/ietf-cms
 
-go 1.12
+go 1.21
 
 require (
+	github.com/cloudflare/circl v1.3.7
 	github.com/github/fakeca v0.1.0
-	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
+	golang.org/x/crypto v0.17.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
 )
+
+require golang.org/x/sys v0.15.0 // indirect
@@ -0,0 +1,15 @@
+//go:build !go1.24
+
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"testing"
+)
+
+// newKEMRecipients returns no recipients because crypto/mlkem was added in Go
+// 1.24.
+func newKEMRecipients(t *testing.T) ([]*x509.Certificate, []crypto.PrivateKey) {
+	return nil, nil
+}
@@ -0,0 +1,212 @@
+//go:build go1.24
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestEncryptKEM(t *testing.T) {
+	data := []byte("hello, world!")
+
+	key768, err := mlkem.GenerateKey768()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert768 := newMLKEMRecipient(t, oid.KEMAlgorithmMLKEM768, key768.EncapsulationKey().Bytes())
+
+	key1024, err := mlkem.GenerateKey1024()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert1024 := newMLKEMRecipient(t, oid.KEMAlgorithmMLKEM1024, key1024.EncapsulationKey().Bytes())
+
+	// A hybrid message can be decrypted with either the classical or the
+	// ML-KEM keys.
+	recipients := []*x509.Certificate{leaf.Certificate, intermediate.Certificate, cert768, cert1024}
+	keys := []crypto.PrivateKey{leaf.PrivateKey, intermediate.PrivateKey, key768, key1024}
+
+	riOpts := []protocol.RecipientInfoOptions{
+		{},
+		{SubjectKeyIdentifier: true},
+		{KeyWrapAlgorithm: oid.KeyWrapAlgorithmAES128},
+	}
+
+	for _, riOpts := range riOpts {
+		opts := EncryptOptions{RecipientInfoOptions: riOpts}
+
+		der, err := EncryptWithOptions(data, recipients, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ed, err := ParseEnvelopedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ed.ped.Version != 3 {
+			t.Fatalf("expected version 3, got %d", ed.ped.Version)
+		}
+
+		kemris, err := ed.ped.RecipientInfos.KEMRecipientInfos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kemris) != 2 {
+			t.Fatalf("expected 2 KEMRecipientInfos, got %d", len(kemris))
+		}
+		for _, kemri := range kemris {
+			if kemri.Version != 0 {
+				t.Fatalf("expected version 0, got %d", kemri.Version)
+			}
+			if !kemri.KDF.Algorithm.Equal(oid.KeyDerivationAlgorithmHKDFWithSHA256) {
+				t.Fatalf("expected HKDF-SHA256, got %v", kemri.KDF.Algorithm)
+			}
+		}
+
+		for i, cert := range recipients {
+			decrypted, err := ed.Decrypt(cert, keys[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Fatal("bad decrypted data")
+			}
+		}
+	}
+
+	der, err := Encrypt(data, []*x509.Certificate{cert768})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := ParseEnvelopedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, err := ed.Decrypt(cert768, opaqueKey(key768)); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, data) {
+		t.Fatal("bad decrypted data")
+	}
+
+	otherKey, err := mlkem.GenerateKey768()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ed.Decrypt(cert768, otherKey); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+	if _, err = ed.Decrypt(cert768, key1024); !errors.Is(err, protocol.ErrDecryption) {
+		t.Fatalf("expected ErrDecryption, got %v", err)
+	}
+	if _, err = ed.Decrypt(cert768, intermediate.PrivateKey); err == nil {
+		t.Fatal("expected error decrypting with EC key")
+	}
+	if _, err = ed.Decrypt(cert1024, key1024); !errors.Is(err, protocol.ErrNoRecipient) {
+		t.Fatalf("expected ErrNoRecipient, got %v", err)
+	}
+}
+
+// newMLKEMRecipient issues a certificate for an ML-KEM encapsulation key. Go
+// can't issue certificates for ML-KEM keys, so we replace the
+// SubjectPublicKeyInfo of a certificate for another key and sign it again.
+func newMLKEMRecipient(t *testing.T, algo asn1.ObjectIdentifier, encapsulationKey []byte) *x509.Certificate {
+	t.Helper()
+
+	var cert struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(intermediate.Issue().Certificate.Raw, &cert); err != nil {
+		t.Fatal(err)
+	}
+
+	var fields []asn1.RawValue
+	for rest := cert.TBSCertificate.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, field)
+	}
+
+	spki, err := asn1.Marshal(struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}{
+		Algorithm:        pkix.AlgorithmIdentifier{Algorithm: algo},
+		SubjectPublicKey: asn1.BitString{Bytes: encapsulationKey, BitLength: 8 * len(encapsulationKey)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fields are version, serialNumber, signature, issuer, validity,
+	// subject and subjectPublicKeyInfo, followed by the extensions.
+	var tbs []byte
+	for i, field := range fields {
+		if i == 6 {
+			tbs = append(tbs, spki...)
+		} else {
+			tbs = append(tbs, field.FullBytes...)
+		}
+	}
+
+	cert.TBSCertificate = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: tbs}
+	tbsDER, err := asn1.Marshal(cert.TBSCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(tbsDER)
+	sig, err := intermediate.PrivateKey.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Signature = asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)}
+
+	der, err := asn1.Marshal(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parsed.CheckSignatureFrom(intermediate.Certificate); err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+// newKEMRecipients returns an ML-KEM-768 recipient to include in tests of
+// other message types.
+func newKEMRecipients(t *testing.T) ([]*x509.Certificate, []crypto.PrivateKey) {
+	t.Helper()
+
+	key, err := mlkem.GenerateKey768()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newMLKEMRecipient(t, oid.KEMAlgorithmMLKEM768, key.EncapsulationKey().Bytes())
+
+	return []*x509.Certificate{cert}, []crypto.PrivateKey{key}
+}
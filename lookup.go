@@ -0,0 +1,94 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// maxLookupChainLength limits how many issuers are looked up for a certificate
+// found using a CertificateLookup.
+const maxLookupChainLength = 10
+
+// CertificateLookup finds certificates that aren't included in a SignedData,
+// for example because it was signed after calling SetCertificates(nil).
+type CertificateLookup interface {
+	// LookupIssuerAndSerialNumber finds the certificate with the given issuer
+	// and serial number. Nil is returned if there isn't one.
+	LookupIssuerAndSerialNumber(isn protocol.IssuerAndSerialNumber) (*x509.Certificate, error)
+
+	// LookupSubjectKeyIdentifier finds the certificate with the given subject
+	// key identifier. Nil is returned if there isn't one.
+	LookupSubjectKeyIdentifier(ski []byte) (*x509.Certificate, error)
+}
+
+// findCertificate finds si's certificate among the SignedData's certificates
+// and VerifyOptions.Certificates, or else using VerifyOptions.CertificateLookup.
+// The issuers of a certificate found using the lookup are looked up by their
+// subject key identifiers and added to the intermediates.
+func (v *verifier) findCertificate(si protocol.SignerInfo) (*x509.Certificate, error) {
+	cert, err := si.FindCertificate(v.certs)
+	if !errors.Is(err, protocol.ErrNoCertificate) || v.opts.CertificateLookup == nil {
+		return cert, err
+	}
+
+	if cert, err = lookupCertificate(v.opts.CertificateLookup, si); err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, protocol.ErrNoCertificate
+	}
+
+	if err = v.lookupIssuers(cert); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// lookupCertificate looks up si's certificate by its SID.
+func lookupCertificate(lookup CertificateLookup, si protocol.SignerInfo) (*x509.Certificate, error) {
+	switch si.Version {
+	case 1: // SID is issuer and serial number
+		isn, err := si.IssuerAndSerialNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		return lookup.LookupIssuerAndSerialNumber(isn)
+	case 3: // SID is SubjectKeyIdentifier
+		ski, err := si.SubjectKeyIdentifier()
+		if err != nil {
+			return nil, err
+		}
+
+		return lookup.LookupSubjectKeyIdentifier(ski)
+	default:
+		return nil, protocol.ErrUnsupported
+	}
+}
+
+// lookupIssuers adds the issuers of cert that can be found by their subject key
+// identifiers to the intermediates, stopping at a self-issued certificate.
+func (v *verifier) lookupIssuers(cert *x509.Certificate) error {
+	for i := 0; i < maxLookupChainLength; i++ {
+		if len(cert.AuthorityKeyId) == 0 || bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return nil
+		}
+
+		issuer, err := v.opts.CertificateLookup.LookupSubjectKeyIdentifier(cert.AuthorityKeyId)
+		if err != nil {
+			return err
+		}
+		if issuer == nil {
+			return nil
+		}
+
+		v.opts.Intermediates.AddCert(issuer)
+		cert = issuer
+	}
+
+	return nil
+}
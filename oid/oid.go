@@ -8,23 +8,35 @@ import (
 )
 
 var (
-	ContentTypeData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
-	ContentTypeSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
-	ContentTypeTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	ContentTypeData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	ContentTypeSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	ContentTypeEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	ContentTypeAuthenticatedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 2}
+	ContentTypeAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+	ContentTypeTSTInfo           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
 
-	AttributeContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
-	AttributeMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
-	AttributeSigningTime    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
-	AttributeTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	AttributeContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	AttributeMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	AttributeSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	AttributeCounterSignature     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	AttributeTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	AttributeSigningCertificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	AttributeSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	AttributeArchiveTimestampV3   = asn1.ObjectIdentifier{0, 4, 0, 1733, 2, 4}
+	AttributeATSHashIndexV3       = asn1.ObjectIdentifier{0, 4, 0, 19122, 1, 5}
 
-	PublicKeyAlgorithmRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
-	PublicKeyAlgorithmECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	PublicKeyAlgorithmRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	PublicKeyAlgorithmECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	PublicKeyAlgorithmEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+	PublicKeyAlgorithmEd448   = asn1.ObjectIdentifier{1, 3, 101, 113}
+	PublicKeyAlgorithmX25519  = asn1.ObjectIdentifier{1, 3, 101, 110}
 
-	DigestAlgorithmSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
-	DigestAlgorithmMD5    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
-	DigestAlgorithmSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
-	DigestAlgorithmSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
-	DigestAlgorithmSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	DigestAlgorithmSHA1     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	DigestAlgorithmMD5      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
+	DigestAlgorithmSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	DigestAlgorithmSHA384   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	DigestAlgorithmSHA512   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	DigestAlgorithmSHAKE256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 12}
 
 	SignatureAlgorithmMD2WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 2}
 	SignatureAlgorithmMD5WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}
@@ -40,6 +52,54 @@ var (
 	SignatureAlgorithmECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
 	SignatureAlgorithmECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
 	SignatureAlgorithmISOSHA1WithRSA  = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 29}
+	SignatureAlgorithmEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+	SignatureAlgorithmEd448           = asn1.ObjectIdentifier{1, 3, 101, 113}
+
+	MaskGenerationFunctionMGF1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	KeyEncryptionAlgorithmRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	KeyEncryptionAlgorithmRSAOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+
+	PSourceAlgorithmSpecified = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 9}
+
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA224KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 0}
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+
+	KeyWrapAlgorithmAES128 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	KeyWrapAlgorithmAES192 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	KeyWrapAlgorithmAES256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+
+	KeyEncryptionAlgorithmPWRIKEK = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 9}
+	KeyDerivationAlgorithmPBKDF2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	OtherRecipientInfoTypeKEM = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 13, 3}
+
+	KEMAlgorithmMLKEM768  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 4, 2}
+	KEMAlgorithmMLKEM1024 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 4, 3}
+
+	KeyDerivationAlgorithmHKDFWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 28}
+	KeyDerivationAlgorithmHKDFWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 29}
+	KeyDerivationAlgorithmHKDFWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 30}
+
+	MACAlgorithmHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	MACAlgorithmHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	MACAlgorithmHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	MACAlgorithmHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	MACAlgorithmHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	EncryptionAlgorithmAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	EncryptionAlgorithmAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	EncryptionAlgorithmAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	EncryptionAlgorithmAES128GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	EncryptionAlgorithmAES192GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	EncryptionAlgorithmAES256GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+
+	EncryptionAlgorithmChaCha20Poly1305 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 18}
+
+	RevocationInfoFormatOCSPResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 16, 2}
 
 	ExtensionSubjectKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 14}
 )
@@ -53,6 +113,54 @@ var DigestAlgorithmToCryptoHash = map[string]crypto.Hash{
 	DigestAlgorithmSHA512.String(): crypto.SHA512,
 }
 
+// KeyAgreementAlgorithmToCryptoHash maps the dhSinglePass-stdDH key agreement
+// OIDs to the crypto.Hash values used by their KDFs.
+var KeyAgreementAlgorithmToCryptoHash = map[string]crypto.Hash{
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA1KDF.String():   crypto.SHA1,
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA224KDF.String(): crypto.SHA224,
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA256KDF.String(): crypto.SHA256,
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA384KDF.String(): crypto.SHA384,
+	KeyAgreementAlgorithmDHSinglePassStdDHSHA512KDF.String(): crypto.SHA512,
+}
+
+// CryptoHashToKeyAgreementAlgorithm maps crypto.Hash values to the
+// dhSinglePass-stdDH key agreement OIDs using them for their KDFs.
+var CryptoHashToKeyAgreementAlgorithm = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   KeyAgreementAlgorithmDHSinglePassStdDHSHA1KDF,
+	crypto.SHA224: KeyAgreementAlgorithmDHSinglePassStdDHSHA224KDF,
+	crypto.SHA256: KeyAgreementAlgorithmDHSinglePassStdDHSHA256KDF,
+	crypto.SHA384: KeyAgreementAlgorithmDHSinglePassStdDHSHA384KDF,
+	crypto.SHA512: KeyAgreementAlgorithmDHSinglePassStdDHSHA512KDF,
+}
+
+// HMACAlgorithmToCryptoHash maps the HMAC OIDs to the crypto.Hash values they
+// use.
+var HMACAlgorithmToCryptoHash = map[string]crypto.Hash{
+	MACAlgorithmHMACWithSHA1.String():   crypto.SHA1,
+	MACAlgorithmHMACWithSHA224.String(): crypto.SHA224,
+	MACAlgorithmHMACWithSHA256.String(): crypto.SHA256,
+	MACAlgorithmHMACWithSHA384.String(): crypto.SHA384,
+	MACAlgorithmHMACWithSHA512.String(): crypto.SHA512,
+}
+
+// CryptoHashToHMACAlgorithm maps crypto.Hash values to the HMAC OIDs using
+// them.
+var CryptoHashToHMACAlgorithm = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   MACAlgorithmHMACWithSHA1,
+	crypto.SHA224: MACAlgorithmHMACWithSHA224,
+	crypto.SHA256: MACAlgorithmHMACWithSHA256,
+	crypto.SHA384: MACAlgorithmHMACWithSHA384,
+	crypto.SHA512: MACAlgorithmHMACWithSHA512,
+}
+
+// HKDFAlgorithmToCryptoHash maps the HKDF OIDs to the crypto.Hash values they
+// use.
+var HKDFAlgorithmToCryptoHash = map[string]crypto.Hash{
+	KeyDerivationAlgorithmHKDFWithSHA256.String(): crypto.SHA256,
+	KeyDerivationAlgorithmHKDFWithSHA384.String(): crypto.SHA384,
+	KeyDerivationAlgorithmHKDFWithSHA512.String(): crypto.SHA512,
+}
+
 // CryptoHashToDigestAlgorithm maps crypto.Hash values to digest OIDs.
 var CryptoHashToDigestAlgorithm = map[crypto.Hash]asn1.ObjectIdentifier{
 	crypto.SHA1:   DigestAlgorithmSHA1,
@@ -65,29 +173,37 @@ var CryptoHashToDigestAlgorithm = map[crypto.Hash]asn1.ObjectIdentifier{
 // X509SignatureAlgorithmToDigestAlgorithm maps x509.SignatureAlgorithm to
 // digestAlgorithm OIDs.
 var X509SignatureAlgorithmToDigestAlgorithm = map[x509.SignatureAlgorithm]asn1.ObjectIdentifier{
-	x509.SHA1WithRSA:     DigestAlgorithmSHA1,
-	x509.MD5WithRSA:      DigestAlgorithmMD5,
-	x509.SHA256WithRSA:   DigestAlgorithmSHA256,
-	x509.SHA384WithRSA:   DigestAlgorithmSHA384,
-	x509.SHA512WithRSA:   DigestAlgorithmSHA512,
-	x509.ECDSAWithSHA1:   DigestAlgorithmSHA1,
-	x509.ECDSAWithSHA256: DigestAlgorithmSHA256,
-	x509.ECDSAWithSHA384: DigestAlgorithmSHA384,
-	x509.ECDSAWithSHA512: DigestAlgorithmSHA512,
+	x509.SHA1WithRSA:      DigestAlgorithmSHA1,
+	x509.MD5WithRSA:       DigestAlgorithmMD5,
+	x509.SHA256WithRSA:    DigestAlgorithmSHA256,
+	x509.SHA384WithRSA:    DigestAlgorithmSHA384,
+	x509.SHA512WithRSA:    DigestAlgorithmSHA512,
+	x509.SHA256WithRSAPSS: DigestAlgorithmSHA256,
+	x509.SHA384WithRSAPSS: DigestAlgorithmSHA384,
+	x509.SHA512WithRSAPSS: DigestAlgorithmSHA512,
+	x509.ECDSAWithSHA1:    DigestAlgorithmSHA1,
+	x509.ECDSAWithSHA256:  DigestAlgorithmSHA256,
+	x509.ECDSAWithSHA384:  DigestAlgorithmSHA384,
+	x509.ECDSAWithSHA512:  DigestAlgorithmSHA512,
+	x509.PureEd25519:      DigestAlgorithmSHA512,
 }
 
 // X509SignatureAlgorithmToPublicKeyAlgorithm maps x509.SignatureAlgorithm to
 // signatureAlgorithm OIDs.
 var X509SignatureAlgorithmToPublicKeyAlgorithm = map[x509.SignatureAlgorithm]asn1.ObjectIdentifier{
-	x509.SHA1WithRSA:     PublicKeyAlgorithmRSA,
-	x509.MD5WithRSA:      PublicKeyAlgorithmRSA,
-	x509.SHA256WithRSA:   PublicKeyAlgorithmRSA,
-	x509.SHA384WithRSA:   PublicKeyAlgorithmRSA,
-	x509.SHA512WithRSA:   PublicKeyAlgorithmRSA,
-	x509.ECDSAWithSHA1:   PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA256: PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA384: PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA512: PublicKeyAlgorithmECDSA,
+	x509.SHA1WithRSA:      PublicKeyAlgorithmRSA,
+	x509.MD5WithRSA:       PublicKeyAlgorithmRSA,
+	x509.SHA256WithRSA:    PublicKeyAlgorithmRSA,
+	x509.SHA384WithRSA:    PublicKeyAlgorithmRSA,
+	x509.SHA512WithRSA:    PublicKeyAlgorithmRSA,
+	x509.SHA256WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.SHA384WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.SHA512WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.ECDSAWithSHA1:    PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA256:  PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA384:  PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA512:  PublicKeyAlgorithmECDSA,
+	x509.PureEd25519:      PublicKeyAlgorithmEd25519,
 }
 
 // PublicKeyAndDigestAlgorithmToX509SignatureAlgorithm maps digest and signature
@@ -121,6 +237,7 @@ var SignatureAlgorithmToX509SignatureAlgorithm = map[string]x509.SignatureAlgori
 	SignatureAlgorithmECDSAWithSHA384.String(): x509.ECDSAWithSHA384,
 	SignatureAlgorithmECDSAWithSHA512.String(): x509.ECDSAWithSHA512,
 	SignatureAlgorithmDSAWithSHA1.String():     x509.DSAWithSHA1,
+	SignatureAlgorithmEd25519.String():         x509.PureEd25519,
 }
 
 // X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm maps X509 public key and
@@ -139,4 +256,16 @@ var X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm = map[x509.PublicKeyAlgo
 		DigestAlgorithmSHA384.String(): SignatureAlgorithmECDSAWithSHA384,
 		DigestAlgorithmSHA512.String(): SignatureAlgorithmECDSAWithSHA512,
 	},
+	// RFC8419 requires SHA-512 for the message digest with Ed25519.
+	x509.Ed25519: map[string]asn1.ObjectIdentifier{
+		DigestAlgorithmSHA512.String(): SignatureAlgorithmEd25519,
+	},
+}
+
+// RSAPSSDigestAlgorithmToX509SignatureAlgorithm maps the digest OIDs used in
+// RSASSA-PSS parameters to x509.SignatureAlgorithm values.
+var RSAPSSDigestAlgorithmToX509SignatureAlgorithm = map[string]x509.SignatureAlgorithm{
+	DigestAlgorithmSHA256.String(): x509.SHA256WithRSAPSS,
+	DigestAlgorithmSHA384.String(): x509.SHA384WithRSAPSS,
+	DigestAlgorithmSHA512.String(): x509.SHA512WithRSAPSS,
 }
@@ -0,0 +1,35 @@
+package cms
+
+import (
+	"github.com/github/ietf-cms/protocol"
+)
+
+// Parse parses a CMS message of any supported type from BER encoded data. It
+// returns a *SignedData, *EnvelopedData, *AuthEnvelopedData or
+// *AuthenticatedData depending on the message's content type, for use when the
+// type isn't known in advance. protocol.ErrUnsupported is returned for any
+// other type of message.
+func Parse(ber []byte) (interface{}, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ci.ParseContent()
+	if err != nil {
+		return nil, err
+	}
+
+	switch content := content.(type) {
+	case *protocol.SignedData:
+		return &SignedData{content}, nil
+	case *protocol.EnvelopedData:
+		return &EnvelopedData{ped: content}, nil
+	case *protocol.AuthEnvelopedData:
+		return &AuthEnvelopedData{content}, nil
+	case *protocol.AuthenticatedData:
+		return &AuthenticatedData{content}, nil
+	default:
+		return nil, protocol.ErrUnsupported
+	}
+}
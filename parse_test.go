@@ -0,0 +1,95 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte("hello, world!")
+	recipients := []*x509.Certificate{leaf.Certificate}
+
+	signed, err := Sign(data, leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, ok := msg.(*SignedData)
+	if !ok {
+		t.Fatalf("expected *SignedData, got %T", msg)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	enveloped, err := Encrypt(data, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = Parse(enveloped); err != nil {
+		t.Fatal(err)
+	}
+	ed, ok := msg.(*EnvelopedData)
+	if !ok {
+		t.Fatalf("expected *EnvelopedData, got %T", msg)
+	}
+	if plaintext, err := ed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, data) {
+		t.Fatal("bad plaintext")
+	}
+
+	authEnveloped, err := AuthEncrypt(data, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = Parse(authEnveloped); err != nil {
+		t.Fatal(err)
+	}
+	aed, ok := msg.(*AuthEnvelopedData)
+	if !ok {
+		t.Fatalf("expected *AuthEnvelopedData, got %T", msg)
+	}
+	if plaintext, err := aed.Decrypt(leaf.Certificate, leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, data) {
+		t.Fatal("bad plaintext")
+	}
+
+	authenticated, err := Authenticate(data, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = Parse(authenticated); err != nil {
+		t.Fatal(err)
+	}
+	ad, ok := msg.(*AuthenticatedData)
+	if !ok {
+		t.Fatalf("expected *AuthenticatedData, got %T", msg)
+	}
+	if content, err := ad.Verify(leaf.Certificate, leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(content, data) {
+		t.Fatal("bad content")
+	}
+
+	// Other content types aren't supported.
+	other, err := asn1.Marshal(protocol.ContentInfo{
+		ContentType: oid.ContentTypeData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x04, 0x00}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Parse(other); err != protocol.ErrUnsupported {
+		t.Fatalf("expected %v, got %v", protocol.ErrUnsupported, err)
+	}
+}
@@ -0,0 +1,83 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// PinnedKey is a public key that signers are trusted to use without a
+// certificate chain. See VerifyOptions.PinnedKeys.
+type PinnedKey struct {
+	// PublicKey is the trusted key. It must have an Equal method, like the key
+	// types in the standard library do.
+	PublicKey crypto.PublicKey
+
+	// SPKIHash is the SHA-256 digest of the trusted key's DER encoded
+	// SubjectPublicKeyInfo. It is only used if PublicKey is nil, and allows
+	// pinning keys that Go doesn't support, such as Ed448 keys.
+	SPKIHash []byte
+}
+
+// matches checks if cert has the pinned key.
+func (pk PinnedKey) matches(cert *x509.Certificate) bool {
+	if pk.PublicKey == nil {
+		spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return bytes.Equal(pk.SPKIHash, spkiHash[:])
+	}
+
+	pub, ok := pk.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+// pinnedKey finds the first of opts.PinnedKeys that cert has. Nil is returned
+// if there isn't one.
+func (v *verifier) pinnedKey(cert *x509.Certificate) *PinnedKey {
+	for i := range v.opts.PinnedKeys {
+		if v.opts.PinnedKeys[i].matches(cert) {
+			return &v.opts.PinnedKeys[i]
+		}
+	}
+
+	return nil
+}
+
+// keyCertificate returns a certificate with nothing but the pinned key, which
+// is enough to check a signature with it. Nil is returned if only SPKIHash is
+// set or the key can't be encoded.
+func (pk PinnedKey) keyCertificate() *x509.Certificate {
+	if pk.PublicKey == nil {
+		return nil
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(pk.PublicKey)
+	if err != nil {
+		return nil
+	}
+
+	return &x509.Certificate{PublicKey: pk.PublicKey, RawSubjectPublicKeyInfo: spki}
+}
+
+// pinnedKeyForSID finds the first of opts.PinnedKeys identified by si's subject
+// key identifier, for signers that didn't include their certificate. The key
+// identifier is derived from the key as it is for certificates without a
+// SubjectKeyIdentifier extension. The key is returned along with a
+// certificate holding just the key. Nils are returned if there isn't one.
+func (v *verifier) pinnedKeyForSID(si protocol.SignerInfo) (*PinnedKey, *x509.Certificate) {
+	ski, err := si.SubjectKeyIdentifier()
+	if err != nil {
+		return nil, nil
+	}
+
+	for i := range v.opts.PinnedKeys {
+		cert := v.opts.PinnedKeys[i].keyCertificate()
+		if cert != nil && protocol.MatchesSubjectKeyIdentifier(cert, ski) {
+			return &v.opts.PinnedKeys[i], cert
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// AuthEnvelopedData ::= SEQUENCE {
+//   version CMSVersion,
+//   originatorInfo [0] IMPLICIT OriginatorInfo OPTIONAL,
+//   recipientInfos RecipientInfos,
+//   authEncryptedContentInfo EncryptedContentInfo,
+//   authAttrs [1] IMPLICIT AuthAttributes OPTIONAL,
+//   mac MessageAuthenticationCode,
+//   unauthAttrs [2] IMPLICIT UnauthAttributes OPTIONAL }
+//
+// AuthAttributes ::= SET SIZE (1..MAX) OF Attribute
+//
+// UnauthAttributes ::= SET SIZE (1..MAX) OF Attribute
+//
+// MessageAuthenticationCode ::= OCTET STRING
+type AuthEnvelopedData struct {
+	Version                  int
+	OriginatorInfo           asn1.RawValue  `asn1:"optional,tag:0"`
+	RecipientInfos           RecipientInfos `asn1:"set"`
+	AuthEncryptedContentInfo EncryptedContentInfo
+	AuthAttrs                Attributes `asn1:"set,optional,tag:1"`
+	MAC                      []byte
+	UnauthAttrs              Attributes `asn1:"set,optional,tag:2"`
+}
+
+// AuthEnvelopedDataContent gets the content assuming contentType is
+// authEnvelopedData.
+func (ci ContentInfo) AuthEnvelopedDataContent() (*AuthEnvelopedData, error) {
+	if !ci.ContentType.Equal(oid.ContentTypeAuthEnvelopedData) {
+		return nil, ErrWrongType
+	}
+
+	aed := new(AuthEnvelopedData)
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, aed); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return aed, nil
+}
+
+// NewAuthEnvelopedData encrypts content of the given type with a random key,
+// using the authenticated encryption algorithm algo (AES-GCM or
+// ChaCha20-Poly1305). The authAttrs, which may be nil, are authenticated along
+// with the content. The AuthEnvelopedData is returned along with the key,
+// which must be given to each recipient by adding a RecipientInfo.
+func NewAuthEnvelopedData(contentType, algo asn1.ObjectIdentifier, content []byte, authAttrs Attributes) (*AuthEnvelopedData, []byte, error) {
+	if !IsAuthenticatedEncryptionAlgorithm(algo) {
+		return nil, nil, ErrUnsupported
+	}
+
+	algoID, key, err := NewContentEncryptionAlgorithm(algo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The authenticated attributes are encoded with an EXPLICIT SET OF tag, as
+	// with signed attributes (RFC5083 section 2.2).
+	var aad []byte
+	if len(authAttrs) > 0 {
+		if aad, err = authAttrs.MarshaledForSigning(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ciphertext, mac, err := AuthEncryptContent(algoID, key, content, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &AuthEnvelopedData{
+		RecipientInfos:           RecipientInfos{},
+		AuthEncryptedContentInfo: NewEncryptedContentInfo(contentType, algoID, ciphertext),
+		AuthAttrs:                authAttrs,
+		MAC:                      mac,
+	}, key, nil
+}
+
+// Decrypt decrypts the content with the content-encryption key recovered from
+// one of the RecipientInfos, checking the MAC over the content and the
+// authenticated attributes. ErrDecryption is returned if it doesn't match.
+func (aed *AuthEnvelopedData) Decrypt(key []byte) ([]byte, error) {
+	eci := aed.AuthEncryptedContentInfo
+	if !IsAuthenticatedEncryptionAlgorithm(eci.ContentEncryptionAlgorithm.Algorithm) {
+		return nil, ErrUnsupported
+	}
+
+	ciphertext, err := eci.EncryptedContentValue()
+	if err != nil {
+		return nil, err
+	}
+
+	var aad []byte
+	if len(aed.AuthAttrs) > 0 {
+		if aad, err = aed.AuthAttrs.MarshaledForVerification(); err != nil {
+			return nil, err
+		}
+	}
+
+	return AuthDecryptContent(eci.ContentEncryptionAlgorithm, key, ciphertext, aed.MAC, aad)
+}
+
+// ContentEncryptionKeySize gets the size of the key needed to decrypt the
+// content.
+func (aed *AuthEnvelopedData) ContentEncryptionKeySize() (int, error) {
+	return ContentEncryptionKeySize(aed.AuthEncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+}
+
+// ContentInfo returns the AuthEnvelopedData wrapped in a ContentInfo packet.
+// The version is always 0, since we never add other certificate or CRL types
+// to the OriginatorInfo.
+func (aed *AuthEnvelopedData) ContentInfo() (ContentInfo, error) {
+	der, err := asn1.Marshal(*aed)
+	if err != nil {
+		return ContentInfo{}, err
+	}
+
+	return ContentInfo{
+		ContentType: oid.ContentTypeAuthEnvelopedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      der,
+			IsCompound: true,
+		},
+	}, nil
+}
+
+// ContentInfoDER returns the AuthEnvelopedData wrapped in a ContentInfo packet
+// and DER encoded.
+func (aed *AuthEnvelopedData) ContentInfoDER() ([]byte, error) {
+	ci, err := aed.ContentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ci)
+}
@@ -0,0 +1,273 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// ErrMACVerification is returned when the MAC of an AuthenticatedData doesn't
+// match its content.
+var ErrMACVerification = errors.New("cms/protocol: MAC verification failed")
+
+// AuthenticatedData ::= SEQUENCE {
+//   version CMSVersion,
+//   originatorInfo [0] IMPLICIT OriginatorInfo OPTIONAL,
+//   recipientInfos RecipientInfos,
+//   macAlgorithm MessageAuthenticationCodeAlgorithm,
+//   digestAlgorithm [1] DigestAlgorithmIdentifier OPTIONAL,
+//   encapContentInfo EncapsulatedContentInfo,
+//   authAttrs [2] IMPLICIT AuthAttributes OPTIONAL,
+//   mac MessageAuthenticationCode,
+//   unauthAttrs [3] IMPLICIT UnauthAttributes OPTIONAL }
+//
+// MessageAuthenticationCodeAlgorithm ::= AlgorithmIdentifier
+type AuthenticatedData struct {
+	Version          int
+	OriginatorInfo   asn1.RawValue  `asn1:"optional,tag:0"`
+	RecipientInfos   RecipientInfos `asn1:"set"`
+	MACAlgorithm     pkix.AlgorithmIdentifier
+	DigestAlgorithm  pkix.AlgorithmIdentifier `asn1:"optional,tag:1"`
+	EncapContentInfo EncapsulatedContentInfo
+	AuthAttrs        Attributes `asn1:"set,optional,tag:2"`
+	MAC              []byte
+	UnauthAttrs      Attributes `asn1:"set,optional,tag:3"`
+}
+
+// AuthenticatedDataContent gets the content assuming contentType is
+// authenticatedData.
+func (ci ContentInfo) AuthenticatedDataContent() (*AuthenticatedData, error) {
+	if !ci.ContentType.Equal(oid.ContentTypeAuthenticatedData) {
+		return nil, ErrWrongType
+	}
+
+	ad := new(AuthenticatedData)
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, ad); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return ad, nil
+}
+
+// NewAuthenticatedData computes an HMAC over the encapsulated content with a
+// random key, using hash. If authAttrs are given or the content isn't id-data,
+// the content-type and message-digest attributes are added to them and the
+// MAC is computed over the authenticated attributes instead (RFC5652 section
+// 9.2). The AuthenticatedData is returned along with the MAC key, which must be
+// given to each recipient by adding a RecipientInfo.
+func NewAuthenticatedData(eci EncapsulatedContentInfo, hash crypto.Hash, authAttrs Attributes) (*AuthenticatedData, []byte, error) {
+	macOID, ok := oid.CryptoHashToHMACAlgorithm[hash]
+	if !ok || !hash.Available() {
+		return nil, nil, ErrUnsupported
+	}
+
+	digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+	if !ok {
+		return nil, nil, ErrUnsupported
+	}
+
+	content, err := eci.EContentValue()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ad := &AuthenticatedData{
+		RecipientInfos:   RecipientInfos{},
+		MACAlgorithm:     pkix.AlgorithmIdentifier{Algorithm: macOID, Parameters: asn1.NullRawValue},
+		EncapContentInfo: eci,
+	}
+
+	macMessage := content
+	if len(authAttrs) > 0 || !eci.IsTypeData() {
+		ad.DigestAlgorithm = pkix.AlgorithmIdentifier{Algorithm: digestOID}
+
+		h := hash.New()
+		h.Write(content)
+
+		if ad.AuthAttrs, err = authenticatedAttributes(h.Sum(nil), eci.EContentType, authAttrs); err != nil {
+			return nil, nil, err
+		}
+
+		if macMessage, err = ad.AuthAttrs.MarshaledForSigning(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key := make([]byte, hash.Size())
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	mac := hmac.New(hash.New, key)
+	mac.Write(macMessage)
+	ad.MAC = mac.Sum(nil)
+
+	return ad, key, nil
+}
+
+// authenticatedAttributes builds the sorted AuthAttributes for a new
+// AuthenticatedData.
+func authenticatedAttributes(messageDigest []byte, contentType asn1.ObjectIdentifier, extra Attributes) (Attributes, error) {
+	mdAttr, err := NewAttribute(oid.AttributeMessageDigest, messageDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	ctAttr, err := NewAttribute(oid.AttributeContentType, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []Attribute{mdAttr, ctAttr}
+	for _, attr := range extra {
+		if Attributes(attrs).HasAttribute(attr.Type) {
+			return nil, fmt.Errorf("duplicate authenticated attribute: %s", attr.Type)
+		}
+		attrs = append(attrs, attr)
+	}
+
+	return sortAttributes(attrs...)
+}
+
+// MACHash gets the crypto.Hash used with HMAC.
+func (ad *AuthenticatedData) MACHash() (crypto.Hash, error) {
+	params := ad.MACAlgorithm.Parameters.FullBytes
+	if len(params) > 0 && !bytes.Equal(params, asn1.NullBytes) {
+		return 0, ErrUnsupported
+	}
+
+	hash := oid.HMACAlgorithmToCryptoHash[ad.MACAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// MACKeySize gets the size of the MAC key. We use keys the size of the HMAC
+// hash output, as recommended by RFC2104.
+func (ad *AuthenticatedData) MACKeySize() (int, error) {
+	hash, err := ad.MACHash()
+	if err != nil {
+		return 0, err
+	}
+
+	return hash.Size(), nil
+}
+
+// Verify checks the MAC with the key recovered from one of the
+// RecipientInfos. If there are authenticated attributes, the message-digest
+// attribute is also checked against the content. ErrMACVerification is
+// returned if either doesn't match.
+func (ad *AuthenticatedData) Verify(key []byte) error {
+	hash, err := ad.MACHash()
+	if err != nil {
+		return err
+	}
+
+	content, err := ad.EncapContentInfo.EContentValue()
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return ASN1Error{"missing encapsulated content"}
+	}
+
+	macMessage := content
+	if len(ad.AuthAttrs) > 0 {
+		if err = ad.checkAuthenticatedAttributes(content); err != nil {
+			return err
+		}
+
+		if macMessage, err = ad.AuthAttrs.MarshaledForVerification(); err != nil {
+			return err
+		}
+	} else if !ad.EncapContentInfo.IsTypeData() {
+		return ASN1Error{"missing authenticated attributes"}
+	}
+
+	mac := hmac.New(hash.New, key)
+	mac.Write(macMessage)
+	if !hmac.Equal(mac.Sum(nil), ad.MAC) {
+		return ErrMACVerification
+	}
+
+	return nil
+}
+
+// checkAuthenticatedAttributes validates the mandatory content-type and
+// message-digest attributes.
+func (ad *AuthenticatedData) checkAuthenticatedAttributes(content []byte) error {
+	rv, err := ad.AuthAttrs.GetOnlyAttributeValueBytes(oid.AttributeContentType)
+	if err != nil {
+		return err
+	}
+
+	var contentType asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(rv.FullBytes, &contentType); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return ErrTrailingData
+	}
+	if !contentType.Equal(ad.EncapContentInfo.EContentType) {
+		return ASN1Error{"invalid ContentType attribute"}
+	}
+
+	if rv, err = ad.AuthAttrs.GetOnlyAttributeValueBytes(oid.AttributeMessageDigest); err != nil {
+		return err
+	}
+	if rv.Class != asn1.ClassUniversal || rv.Tag != asn1.TagOctetString {
+		return ASN1Error{"bad class or tag"}
+	}
+
+	h, err := NewDigest(ad.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	h.Write(content)
+
+	if !bytes.Equal(h.Sum(nil), rv.Bytes) {
+		return ErrMACVerification
+	}
+
+	return nil
+}
+
+// ContentInfo returns the AuthenticatedData wrapped in a ContentInfo packet.
+// The version is always 0, since we never add an OriginatorInfo.
+func (ad *AuthenticatedData) ContentInfo() (ContentInfo, error) {
+	der, err := asn1.Marshal(*ad)
+	if err != nil {
+		return ContentInfo{}, err
+	}
+
+	return ContentInfo{
+		ContentType: oid.ContentTypeAuthenticatedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      der,
+			IsCompound: true,
+		},
+	}, nil
+}
+
+// ContentInfoDER returns the AuthenticatedData wrapped in a ContentInfo packet
+// and DER encoded.
+func (ad *AuthenticatedData) ContentInfoDER() ([]byte, error) {
+	ci, err := ad.ContentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ci)
+}
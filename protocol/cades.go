@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// ATSHashIndexV3 is the value of the ats-hash-index-v3 attribute, which is an
+// unsigned attribute of the TimeStampToken in a CAdES archive-time-stamp-v3
+// attribute (ETSI EN 319 122-1 section 5.5.2). It lists the hashes of the
+// certificates, revocation data and unsigned attribute values covered by the
+// archive timestamp.
+//
+// ATSHashIndexV3 ::= SEQUENCE {
+//   hashIndAlgorithm AlgorithmIdentifier DEFAULT {algorithm id-sha256},
+//   certificatesHashIndex SEQUENCE OF OCTET STRING,
+//   crlsHashIndex SEQUENCE OF OCTET STRING,
+//   unsignedAttrValuesHashIndex SEQUENCE OF OCTET STRING }
+type ATSHashIndexV3 struct {
+	HashIndAlgorithm            pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertificatesHashIndex       [][]byte
+	CRLsHashIndex               [][]byte
+	UnsignedAttrValuesHashIndex [][]byte
+}
+
+// Hash gets the crypto.Hash identified by the hashIndAlgorithm field.
+func (index ATSHashIndexV3) Hash() (crypto.Hash, error) {
+	if len(index.HashIndAlgorithm.Algorithm) == 0 {
+		return crypto.SHA256, nil
+	}
+
+	hash := oid.DigestAlgorithmToCryptoHash[index.HashIndAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// ParseATSHashIndexV3 parses a DER encoded ATSHashIndexV3. Go's asn1 parser
+// can't tell an omitted hashIndAlgorithm from certificatesHashIndex, since
+// both are SEQUENCEs, so the fields are parsed one at a time.
+func ParseATSHashIndexV3(der []byte) (ATSHashIndexV3, error) {
+	var (
+		index ATSHashIndexV3
+		seq   asn1.RawValue
+	)
+
+	if rest, err := asn1.Unmarshal(der, &seq); err != nil {
+		return index, err
+	} else if len(rest) > 0 {
+		return index, ErrTrailingData
+	}
+	if seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence {
+		return index, ASN1Error{"bad ATSHashIndexV3 class or tag"}
+	}
+
+	// An AlgorithmIdentifier starts with an OBJECT IDENTIFIER, whereas the hash
+	// indexes contain OCTET STRINGs.
+	var (
+		first asn1.RawValue
+		rest  = seq.Bytes
+		err   error
+	)
+	if _, err = asn1.Unmarshal(rest, &first); err != nil {
+		return index, err
+	}
+	if len(first.Bytes) > 0 && first.Bytes[0] == asn1.TagOID {
+		if rest, err = asn1.Unmarshal(rest, &index.HashIndAlgorithm); err != nil {
+			return index, err
+		}
+	}
+
+	for _, field := range []*[][]byte{&index.CertificatesHashIndex, &index.CRLsHashIndex, &index.UnsignedAttrValuesHashIndex} {
+		if rest, err = asn1.Unmarshal(rest, field); err != nil {
+			return index, err
+		}
+	}
+	if len(rest) > 0 {
+		return index, ErrTrailingData
+	}
+
+	return index, nil
+}
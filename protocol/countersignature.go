@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// Countersignature ::= SignerInfo
+//
+// A countersignature is stored as a value of the countersignature unsigned
+// attribute of the SignerInfo it countersigns (RFC5652 section 11.4). It signs
+// the contents octets of that SignerInfo's signature value rather than the
+// SignedData's content, so countersignatures can be added without having the
+// content and can themselves be countersigned.
+
+// AddCounterSignature countersigns the signature of si, adding the
+// countersignature attribute to its UnsignedAttrs and the countersigner's
+// chain to the SignedData's certificates. si should point at one of
+// sd.SignerInfos. The content-type attribute isn't allowed in countersignatures
+// and may not be included in opts.SignedAttrs.
+func (sd *SignedData) AddCounterSignature(si *SignerInfo, chain []*x509.Certificate, signer crypto.Signer, opts SignerInfoOptions) error {
+	if opts.SignedAttrs.HasAttribute(oid.AttributeContentType) {
+		return errors.New("content-type attribute not allowed in countersignature")
+	}
+
+	chain, err := signerChain(chain, signer)
+	if err != nil {
+		return err
+	}
+
+	psi, err := prepareSignerInfo(bytes.NewReader(si.Signature), nil, chain, opts)
+	if err != nil {
+		return err
+	}
+
+	cs := psi.SignerInfo
+	if cs.Signature, err = psi.sign(signer); err != nil {
+		return err
+	}
+
+	if err = si.CheckCounterSignature(cs, chain[0]); err != nil {
+		return err
+	}
+
+	for _, cert := range chain {
+		if err = sd.AddCertificate(cert); err != nil {
+			return err
+		}
+	}
+
+	return si.addCounterSignature(cs)
+}
+
+// addCounterSignature adds cs as a value of the countersignature attribute,
+// creating the attribute if needed.
+func (si *SignerInfo) addCounterSignature(cs SignerInfo) error {
+	for i := range si.UnsignedAttrs {
+		if si.UnsignedAttrs[i].Type.Equal(oid.AttributeCounterSignature) {
+			return si.UnsignedAttrs[i].AddValue(cs)
+		}
+	}
+
+	attr, err := NewAttribute(oid.AttributeCounterSignature, cs)
+	if err != nil {
+		return err
+	}
+
+	si.UnsignedAttrs = append(si.UnsignedAttrs, attr)
+
+	return nil
+}
+
+// CounterSignatures gets the countersignatures of si from all of its
+// countersignature attributes. Nested countersignatures can be found by
+// calling CounterSignatures on the results.
+func (si SignerInfo) CounterSignatures() ([]SignerInfo, error) {
+	vals, err := si.UnsignedAttrs.GetValues(oid.AttributeCounterSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	var css []SignerInfo
+	for _, val := range vals {
+		for _, elt := range val.Elements {
+			var cs SignerInfo
+			if rest, err := asn1.Unmarshal(elt.FullBytes, &cs); err != nil {
+				return nil, err
+			} else if len(rest) > 0 {
+				return nil, ErrTrailingData
+			}
+
+			css = append(css, cs)
+		}
+	}
+
+	return css, nil
+}
+
+// CheckCounterSignature checks that cs is a valid countersignature of si's
+// signature, made with cert's key. If cs has SignedAttrs, they must include
+// the digest of si's signature and not include a content-type attribute.
+// Otherwise, the signature is over si's signature itself.
+func (si SignerInfo) CheckCounterSignature(cs SignerInfo, cert *x509.Certificate) error {
+	signedMessage := si.Signature
+
+	if cs.SignedAttrs != nil {
+		if cs.SignedAttrs.HasAttribute(oid.AttributeContentType) {
+			return ASN1Error{"content-type attribute in countersignature"}
+		}
+
+		md, err := cs.NewHash()
+		if err != nil {
+			return err
+		}
+		if _, err = md.Write(si.Signature); err != nil {
+			return err
+		}
+
+		expected, err := cs.GetMessageDigestAttribute()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(md.Sum(nil), expected) {
+			return errors.New("invalid countersignature message digest")
+		}
+
+		if signedMessage, err = cs.SignedAttrs.MarshaledForVerification(); err != nil {
+			return err
+		}
+	}
+
+	return cs.CheckSignature(cert, signedMessage)
+}
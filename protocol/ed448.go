@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/github/ietf-cms/oid"
+)
+
+// ErrEd448Verification is returned when an Ed448 signature is invalid.
+var ErrEd448Verification = errors.New("cms/protocol: Ed448 verification error")
+
+// verifyEd448 verifies a pure Ed448 signature with an empty context, as
+// required by RFC8419 section 3.1. Go doesn't implement Ed448, so circl's
+// implementation is used.
+func verifyEd448(pub, message, sig []byte) error {
+	if !ed448.Verify(ed448.PublicKey(pub), message, sig, "") {
+		return ErrEd448Verification
+	}
+
+	return nil
+}
+
+// ed448PublicKey extracts the raw Ed448 public key from a DER encoded
+// SubjectPublicKeyInfo.
+func ed448PublicKey(spki []byte) ([]byte, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oid.PublicKeyAlgorithmEd448) {
+		return nil, errors.New("Ed448 signature with non-Ed448 certificate")
+	}
+	if len(info.PublicKey.Bytes) != ed448.PublicKeySize {
+		return nil, ASN1Error{"bad Ed448 public key length"}
+	}
+
+	return info.PublicKey.Bytes, nil
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// Test vectors from RFC8032 section 7.4.
+var ed448Vectors = []struct {
+	name              string
+	pub, message, sig string
+}{
+	{
+		name:    "blank",
+		pub:     "5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e96778edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180",
+		message: "",
+		sig:     "533a37f6bbe457251f023c0d88f976ae2dfb504a843e34d2074fd823d41a591f2b233f034f628281f2fd7a22ddd47d7828c59bd0a21bfd3980ff0d2028d4b18a9df63e006c5d1c2d345b925d8dc00b4104852db99ac5c7cdda8530a113a0f4dbb61149f05a7363268c71d95808ff2e652600",
+	},
+	{
+		name:    "1 octet",
+		pub:     "43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798c0866aea01eb00742802b8438ea4cb82169c235160627b4c3a9480",
+		message: "03",
+		sig:     "26b8f91727bd62897af15e41eb43c377efb9c610d48f2335cb0bd0087810f4352541b143c4b981b7e18f62de8ccdf633fc1bf037ab7cd779805e0dbcc0aae1cbcee1afb2e027df36bc04dcecbf154336c19f0af7e0a6472905e799f1953d2a0ff3348ab21aa4adafd1d234441cf807c03a00",
+	},
+}
+
+func TestVerifyEd448Vectors(t *testing.T) {
+	for _, v := range ed448Vectors {
+		pub := mustDecodeHex(t, v.pub)
+		message := mustDecodeHex(t, v.message)
+		sig := mustDecodeHex(t, v.sig)
+
+		if err := verifyEd448(pub, message, sig); err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+
+		// Wrong message.
+		if err := verifyEd448(pub, append(message, 0x00), sig); err != ErrEd448Verification {
+			t.Fatalf("%s: expected %v, got %v", v.name, ErrEd448Verification, err)
+		}
+
+		// Tampered signature.
+		tampered := append([]byte(nil), sig...)
+		tampered[0] ^= 0x01
+		if err := verifyEd448(pub, message, tampered); err != ErrEd448Verification {
+			t.Fatalf("%s: expected %v, got %v", v.name, ErrEd448Verification, err)
+		}
+
+		// Truncated signature.
+		if err := verifyEd448(pub, message, sig[:len(sig)-1]); err != ErrEd448Verification {
+			t.Fatalf("%s: expected %v, got %v", v.name, ErrEd448Verification, err)
+		}
+
+		// Non-canonical S, with S+L in place of S.
+		if err := verifyEd448(pub, message, addEd448Order(t, sig)); err != ErrEd448Verification {
+			t.Fatalf("%s: expected %v, got %v", v.name, ErrEd448Verification, err)
+		}
+
+		// Truncated public key.
+		if err := verifyEd448(pub[:len(pub)-1], message, sig); err != ErrEd448Verification {
+			t.Fatalf("%s: expected %v, got %v", v.name, ErrEd448Verification, err)
+		}
+	}
+
+	// Signature from another key.
+	pub := mustDecodeHex(t, ed448Vectors[0].pub)
+	message := mustDecodeHex(t, ed448Vectors[1].message)
+	sig := mustDecodeHex(t, ed448Vectors[1].sig)
+	if err := verifyEd448(pub, message, sig); err != ErrEd448Verification {
+		t.Fatalf("expected %v, got %v", ErrEd448Verification, err)
+	}
+}
+
+// addEd448Order returns a copy of sig with the group order L added to its
+// little-endian S component.
+func addEd448Order(t *testing.T, sig []byte) []byte {
+	order := mustDecodeHex(t, "f34458ab92c27823558fc58d72c26c219036d6ae49db4ec4e923ca7cffffffffffffffffffffffffffffffffffffffffffffffffffffff3f00")
+
+	out := append([]byte(nil), sig...)
+	s := out[57:]
+	var carry uint16
+	for i := range s {
+		sum := uint16(s[i]) + uint16(order[i]) + carry
+		s[i] = byte(sum)
+		carry = sum >> 8
+	}
+	return out
+}
+
+func TestEd448PublicKey(t *testing.T) {
+	pub := mustDecodeHex(t, ed448Vectors[0].pub)
+
+	spki, err := marshalEd448PublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ed448PublicKey(spki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed, pub) {
+		t.Fatal("bad public key")
+	}
+
+	if spki, err = marshalEd448PublicKey(pub[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ed448PublicKey(spki); err == nil {
+		t.Fatal("expected error for short public key")
+	}
+
+	if _, err = ed448PublicKey(append(spki, 0x00)); err != ErrTrailingData {
+		t.Fatalf("expected %v, got %v", ErrTrailingData, err)
+	}
+}
+
+func marshalEd448PublicKey(pub []byte) ([]byte, error) {
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid.PublicKeyAlgorithmEd448},
+		PublicKey: asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
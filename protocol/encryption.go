@@ -0,0 +1,327 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrDecryption is returned when encrypted content or keys can't be decrypted.
+// The reason isn't given, to avoid acting as a padding oracle.
+var ErrDecryption = errors.New("cms/protocol: decryption failed")
+
+const gcmNonceSize = 12
+
+// contentEncryptionKeySizes are the key sizes of the supported content
+// encryption algorithms.
+var contentEncryptionKeySizes = map[string]int{
+	oid.EncryptionAlgorithmAES128CBC.String(): 16,
+	oid.EncryptionAlgorithmAES192CBC.String(): 24,
+	oid.EncryptionAlgorithmAES256CBC.String(): 32,
+	oid.EncryptionAlgorithmAES128GCM.String(): 16,
+	oid.EncryptionAlgorithmAES192GCM.String(): 24,
+	oid.EncryptionAlgorithmAES256GCM.String(): 32,
+
+	oid.EncryptionAlgorithmChaCha20Poly1305.String(): chacha20poly1305.KeySize,
+}
+
+// AEADChaCha20Poly1305Nonce ::= OCTET STRING (SIZE(12))
+//
+// GCMParameters ::= SEQUENCE {
+//   aes-nonce OCTET STRING, -- recommended size is 12 octets
+//   aes-ICVlen AES-GCMICVlen DEFAULT 12 }
+//
+// AES-GCMICVlen ::= INTEGER (12 | 13 | 14 | 15 | 16)
+type GCMParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// ContentEncryptionKeySize gets the key size in bytes of a content encryption
+// algorithm. ErrUnsupported is returned for unsupported algorithms.
+func ContentEncryptionKeySize(algo asn1.ObjectIdentifier) (int, error) {
+	size, ok := contentEncryptionKeySizes[algo.String()]
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	return size, nil
+}
+
+// IsAuthenticatedEncryptionAlgorithm checks if algo is one of the authenticated
+// encryption algorithms: AES-GCM or ChaCha20-Poly1305.
+func IsAuthenticatedEncryptionAlgorithm(algo asn1.ObjectIdentifier) bool {
+	return isGCM(algo) || algo.Equal(oid.EncryptionAlgorithmChaCha20Poly1305)
+}
+
+// isGCM checks if algo is one of the AES-GCM algorithms.
+func isGCM(algo asn1.ObjectIdentifier) bool {
+	return algo.Equal(oid.EncryptionAlgorithmAES128GCM) ||
+		algo.Equal(oid.EncryptionAlgorithmAES192GCM) ||
+		algo.Equal(oid.EncryptionAlgorithmAES256GCM)
+}
+
+// NewContentEncryptionAlgorithm generates a random key for the content
+// encryption algorithm algo, returning it along with an AlgorithmIdentifier
+// that has a random IV or nonce. AES-GCM uses a 16 byte authentication tag.
+// ChaCha20-Poly1305 always does.
+func NewContentEncryptionAlgorithm(algo asn1.ObjectIdentifier) (pkix.AlgorithmIdentifier, []byte, error) {
+	size, err := ContentEncryptionKeySize(algo)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	key := make([]byte, size)
+	if _, err = rand.Read(key); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	var params interface{}
+	if IsAuthenticatedEncryptionAlgorithm(algo) {
+		nonce := make([]byte, gcmNonceSize)
+		if _, err = rand.Read(nonce); err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+
+		if isGCM(algo) {
+			params = GCMParameters{Nonce: nonce, ICVLen: 16}
+		} else {
+			params = nonce
+		}
+	} else {
+		iv := make([]byte, aes.BlockSize)
+		if _, err = rand.Read(iv); err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+
+		params = iv
+	}
+
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  algo,
+		Parameters: asn1.RawValue{FullBytes: der},
+	}, key, nil
+}
+
+// EncryptContent encrypts plaintext with key using the content encryption
+// algorithm algo. For AES-GCM and ChaCha20-Poly1305, the authentication tag is
+// returned separately from the ciphertext. It is nil for AES-CBC.
+func EncryptContent(algo pkix.AlgorithmIdentifier, key, plaintext []byte) (ciphertext, tag []byte, err error) {
+	if IsAuthenticatedEncryptionAlgorithm(algo.Algorithm) {
+		return AuthEncryptContent(algo, key, plaintext, nil)
+	}
+
+	block, iv, err := newCBC(algo, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// PKCS#7 padding (RFC5652 section 6.3).
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext = make([]byte, len(plaintext)+padding)
+	copy(ciphertext, plaintext)
+	for i := len(plaintext); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(padding)
+	}
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return ciphertext, nil, nil
+}
+
+// DecryptContent decrypts ciphertext with key using the content encryption
+// algorithm algo. For AES-GCM and ChaCha20-Poly1305, tag is the authentication
+// tag. ErrDecryption is returned if the padding or tag is invalid.
+func DecryptContent(algo pkix.AlgorithmIdentifier, key, ciphertext, tag []byte) ([]byte, error) {
+	if IsAuthenticatedEncryptionAlgorithm(algo.Algorithm) {
+		return AuthDecryptContent(algo, key, ciphertext, tag, nil)
+	}
+
+	block, iv, err := newCBC(algo, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrDecryption
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrDecryption
+	}
+
+	good := 1
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		good &= subtle.ConstantTimeByteEq(b, byte(padding))
+	}
+	if good != 1 {
+		return nil, ErrDecryption
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// AuthEncryptContent encrypts plaintext with key using the authenticated
+// encryption algorithm algo, also authenticating the additional data aad. The
+// authentication tag is returned separately from the ciphertext.
+// ErrUnsupported is returned if algo isn't AES-GCM or ChaCha20-Poly1305.
+func AuthEncryptContent(algo pkix.AlgorithmIdentifier, key, plaintext, aad []byte) (ciphertext, tag []byte, err error) {
+	aead, nonce, err := newAEAD(algo, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealed := aead.Seal(nil, nonce, plaintext, aad)
+	split := len(sealed) - aead.Overhead()
+
+	return sealed[:split], sealed[split:], nil
+}
+
+// AuthDecryptContent decrypts ciphertext with key using the authenticated
+// encryption algorithm algo, checking the authentication tag over it and the
+// additional data aad. ErrDecryption is returned if the tag is invalid.
+func AuthDecryptContent(algo pkix.AlgorithmIdentifier, key, ciphertext, tag, aad []byte) ([]byte, error) {
+	aead, nonce, err := newAEAD(algo, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tag) != aead.Overhead() {
+		return nil, ErrDecryption
+	}
+
+	plaintext, err := aead.Open(nil, nonce, append(ciphertext[:len(ciphertext):len(ciphertext)], tag...), aad)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	return plaintext, nil
+}
+
+// newCBC creates the block cipher and gets the IV for an AES-CBC algorithm.
+func newCBC(algo pkix.AlgorithmIdentifier, key []byte) (cipher.Block, []byte, error) {
+	if err := checkContentEncryptionKey(algo, key); err != nil {
+		return nil, nil, err
+	}
+
+	var iv []byte
+	if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, ErrTrailingData
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, nil, ASN1Error{"bad AES-CBC IV length"}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, iv, nil
+}
+
+// newAEAD creates the AEAD and gets the nonce for an AES-GCM or
+// ChaCha20-Poly1305 algorithm.
+func newAEAD(algo pkix.AlgorithmIdentifier, key []byte) (cipher.AEAD, []byte, error) {
+	if isGCM(algo.Algorithm) {
+		return newGCM(algo, key)
+	}
+
+	if !algo.Algorithm.Equal(oid.EncryptionAlgorithmChaCha20Poly1305) {
+		return nil, nil, ErrUnsupported
+	}
+
+	if err := checkContentEncryptionKey(algo, key); err != nil {
+		return nil, nil, err
+	}
+
+	var nonce []byte
+	if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &nonce); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, ErrTrailingData
+	}
+	if len(nonce) != chacha20poly1305.NonceSize {
+		return nil, nil, ASN1Error{"bad ChaCha20-Poly1305 nonce length"}
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, nonce, nil
+}
+
+// newGCM creates the AEAD, with the tag size from the parameters, and gets the
+// nonce for an AES-GCM algorithm.
+func newGCM(algo pkix.AlgorithmIdentifier, key []byte) (cipher.AEAD, []byte, error) {
+	if err := checkContentEncryptionKey(algo, key); err != nil {
+		return nil, nil, err
+	}
+
+	var params GCMParameters
+	if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, ErrTrailingData
+	}
+	if len(params.Nonce) == 0 {
+		return nil, nil, ASN1Error{"missing AES-GCM nonce"}
+	}
+	if params.ICVLen < 12 || params.ICVLen > 16 {
+		return nil, nil, ASN1Error{"bad AES-GCM ICV length"}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Go can't combine non-standard nonce and tag sizes.
+	var aead cipher.AEAD
+	switch {
+	case len(params.Nonce) == gcmNonceSize:
+		aead, err = cipher.NewGCMWithTagSize(block, params.ICVLen)
+	case params.ICVLen == 16:
+		aead, err = cipher.NewGCMWithNonceSize(block, len(params.Nonce))
+	default:
+		err = ErrUnsupported
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, params.Nonce, nil
+}
+
+// checkContentEncryptionKey checks that key is the right size for algo.
+func checkContentEncryptionKey(algo pkix.AlgorithmIdentifier, key []byte) error {
+	size, err := ContentEncryptionKeySize(algo.Algorithm)
+	if err != nil {
+		return err
+	}
+	if len(key) != size {
+		return ErrDecryption
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+func TestEncryptContent(t *testing.T) {
+	algos := []asn1.ObjectIdentifier{
+		oid.EncryptionAlgorithmAES128CBC,
+		oid.EncryptionAlgorithmAES256GCM,
+		oid.EncryptionAlgorithmChaCha20Poly1305,
+	}
+
+	for _, algo := range algos {
+		for _, plaintext := range [][]byte{{}, []byte("hello"), bytes.Repeat([]byte{'x'}, 32)} {
+			algoID, key, err := NewContentEncryptionAlgorithm(algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ciphertext, tag, err := EncryptContent(algoID, key, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decrypted, err := DecryptContent(algoID, key, ciphertext, tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Fatal("bad decrypted content")
+			}
+
+			if len(ciphertext) > 0 {
+				ciphertext[len(ciphertext)-1] ^= 0xFF
+				if _, err = DecryptContent(algoID, key, ciphertext, tag); err != ErrDecryption {
+					t.Fatalf("expected ErrDecryption, got %v", err)
+				}
+			}
+
+			if _, err = DecryptContent(algoID, key[1:], ciphertext, tag); err != ErrDecryption {
+				t.Fatalf("expected ErrDecryption, got %v", err)
+			}
+		}
+	}
+
+	if _, _, err := NewContentEncryptionAlgorithm(oid.DigestAlgorithmSHA256); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestParseRSAESOAEPParams(t *testing.T) {
+	// Parameters with all the defaults may be absent.
+	params, err := ParseRSAESOAEPParams(pkix.AlgorithmIdentifier{Algorithm: oid.KeyEncryptionAlgorithmRSAOAEP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := params.OAEPOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Hash != crypto.SHA1 || opts.MGFHash != crypto.SHA1 || len(opts.Label) != 0 {
+		t.Fatalf("bad default OAEP options: %+v", opts)
+	}
+
+	algo, err := NewRSAESOAEPAlgorithmIdentifier(crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params, err = ParseRSAESOAEPParams(algo); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err = params.OAEPOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Hash != crypto.SHA384 || opts.MGFHash != crypto.SHA384 || len(opts.Label) != 0 {
+		t.Fatalf("bad OAEP options: %+v", opts)
+	}
+
+	if _, err = ParseRSAESOAEPParams(pkix.AlgorithmIdentifier{Algorithm: oid.KeyEncryptionAlgorithmRSA}); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// Test vectors from RFC3394 section 4.
+	tests := []struct{ kek, key, wrapped string }{
+		{
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	}
+
+	for _, test := range tests {
+		kek, _ := hex.DecodeString(test.kek)
+		key, _ := hex.DecodeString(test.key)
+		expected, _ := hex.DecodeString(test.wrapped)
+
+		wrapped, err := aesKeyWrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Fatalf("bad wrapped key: %X", wrapped)
+		}
+
+		unwrapped, err := aesKeyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("bad unwrapped key: %X", unwrapped)
+		}
+
+		wrapped[len(wrapped)-1] ^= 0xFF
+		if _, err = aesKeyUnwrap(kek, wrapped); err != ErrDecryption {
+			t.Fatalf("expected ErrDecryption, got %v", err)
+		}
+	}
+}
+
+func TestPWRIKeyWrap(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x01}, 32)
+	iv := bytes.Repeat([]byte{0x02}, aes.BlockSize)
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{3, 16, 24, 28, 32} {
+		key := bytes.Repeat([]byte{0x03}, size)
+
+		wrapped, err := pwriKeyWrap(block, iv, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wrapped) < 2*aes.BlockSize || len(wrapped)%aes.BlockSize != 0 {
+			t.Fatalf("bad wrapped key length: %d", len(wrapped))
+		}
+
+		unwrapped, err := pwriKeyUnwrap(block, iv, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("bad unwrapped key: %X", unwrapped)
+		}
+
+		wrapped[0] ^= 0xFF
+		if _, err = pwriKeyUnwrap(block, iv, wrapped); err != ErrDecryption {
+			t.Fatalf("expected ErrDecryption, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// EnvelopedData ::= SEQUENCE {
+//   version CMSVersion,
+//   originatorInfo [0] IMPLICIT OriginatorInfo OPTIONAL,
+//   recipientInfos RecipientInfos,
+//   encryptedContentInfo EncryptedContentInfo,
+//   unprotectedAttrs [1] IMPLICIT UnprotectedAttributes OPTIONAL }
+//
+// OriginatorInfo ::= SEQUENCE {
+//   certs [0] IMPLICIT CertificateSet OPTIONAL,
+//   crls [1] IMPLICIT RevocationInfoChoices OPTIONAL }
+//
+// UnprotectedAttributes ::= SET SIZE (1..MAX) OF Attribute
+type EnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue  `asn1:"optional,tag:0"`
+	RecipientInfos       RecipientInfos `asn1:"set"`
+	EncryptedContentInfo EncryptedContentInfo
+	UnprotectedAttrs     Attributes `asn1:"set,optional,tag:1"`
+}
+
+// EnvelopedDataContent gets the content assuming contentType is envelopedData.
+func (ci ContentInfo) EnvelopedDataContent() (*EnvelopedData, error) {
+	if !ci.ContentType.Equal(oid.ContentTypeEnvelopedData) {
+		return nil, ErrWrongType
+	}
+
+	ed := new(EnvelopedData)
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, ed); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return ed, nil
+}
+
+// NewEnvelopedData encrypts content of the given type with a random key, using
+// the content encryption algorithm algo. The EnvelopedData is returned along
+// with the key, which must be given to each recipient by adding a
+// RecipientInfo. Authenticated encryption algorithms, such as AES-GCM and
+// ChaCha20-Poly1305, can only be used with AuthEnvelopedData (RFC5083), so
+// ErrUnsupported is returned for them. Use NewAuthEnvelopedData instead.
+func NewEnvelopedData(contentType, algo asn1.ObjectIdentifier, content []byte) (*EnvelopedData, []byte, error) {
+	if IsAuthenticatedEncryptionAlgorithm(algo) {
+		return nil, nil, ErrUnsupported
+	}
+
+	algoID, key, err := NewContentEncryptionAlgorithm(algo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, _, err := EncryptContent(algoID, key, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &EnvelopedData{
+		RecipientInfos:       RecipientInfos{},
+		EncryptedContentInfo: NewEncryptedContentInfo(contentType, algoID, ciphertext),
+	}, key, nil
+}
+
+// Decrypt decrypts the content with the content-encryption key recovered from
+// one of the RecipientInfos. ErrUnsupported is returned for authenticated
+// encryption algorithms, which EnvelopedData has no way to carry the tag for.
+func (ed *EnvelopedData) Decrypt(key []byte) ([]byte, error) {
+	eci := ed.EncryptedContentInfo
+	if IsAuthenticatedEncryptionAlgorithm(eci.ContentEncryptionAlgorithm.Algorithm) {
+		return nil, ErrUnsupported
+	}
+
+	ciphertext, err := eci.EncryptedContentValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptContent(eci.ContentEncryptionAlgorithm, key, ciphertext, nil)
+}
+
+// ContentEncryptionKeySize gets the size of the key needed to decrypt the
+// content.
+func (ed *EnvelopedData) ContentEncryptionKeySize() (int, error) {
+	return ContentEncryptionKeySize(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+}
+
+// updateVersion sets the version as described in RFC5652 section 6.1. We never
+// add other certificate or CRL types to the OriginatorInfo, so version 4 isn't
+// used.
+func (ed *EnvelopedData) updateVersion() error {
+	version, err := ed.RecipientInfos.version()
+	if err != nil {
+		return err
+	}
+
+	if version < 2 && (len(ed.OriginatorInfo.FullBytes) > 0 || ed.UnprotectedAttrs != nil) {
+		version = 2
+	}
+
+	ed.Version = version
+
+	return nil
+}
+
+// ContentInfo returns the EnvelopedData wrapped in a ContentInfo packet. The
+// version is updated to match the RecipientInfos first.
+func (ed *EnvelopedData) ContentInfo() (ContentInfo, error) {
+	if err := ed.updateVersion(); err != nil {
+		return ContentInfo{}, err
+	}
+
+	der, err := asn1.Marshal(*ed)
+	if err != nil {
+		return ContentInfo{}, err
+	}
+
+	return ContentInfo{
+		ContentType: oid.ContentTypeEnvelopedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      der,
+			IsCompound: true,
+		},
+	}, nil
+}
+
+// ContentInfoDER returns the EnvelopedData wrapped in a ContentInfo packet and
+// DER encoded.
+func (ed *EnvelopedData) ContentInfoDER() ([]byte, error) {
+	ci, err := ed.ContentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ci)
+}
+
+// EncryptedContentInfo ::= SEQUENCE {
+//   contentType ContentType,
+//   contentEncryptionAlgorithm ContentEncryptionAlgorithmIdentifier,
+//   encryptedContent [0] IMPLICIT EncryptedContent OPTIONAL }
+//
+// ContentEncryptionAlgorithmIdentifier ::= AlgorithmIdentifier
+//
+// EncryptedContent ::= OCTET STRING
+type EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// NewEncryptedContentInfo creates a new EncryptedContentInfo.
+func NewEncryptedContentInfo(contentType asn1.ObjectIdentifier, algo pkix.AlgorithmIdentifier, ciphertext []byte) EncryptedContentInfo {
+	return EncryptedContentInfo{
+		ContentType:                contentType,
+		ContentEncryptionAlgorithm: algo,
+		EncryptedContent: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      ciphertext,
+			IsCompound: false,
+		},
+	}
+}
+
+// EncryptedContentValue gets the EncryptedContent OCTET STRING value. A nil
+// byte slice is returned if the OPTIONAL encryptedContent field is missing.
+func (eci EncryptedContentInfo) EncryptedContentValue() ([]byte, error) {
+	if eci.EncryptedContent.Bytes == nil {
+		return nil, nil
+	}
+
+	if !eci.EncryptedContent.IsCompound {
+		return eci.EncryptedContent.Bytes, nil
+	}
+
+	// As with EContentValue, BER encoders may split the value into a
+	// constructed OCTET STRING.
+	var (
+		value  []byte
+		octets asn1.RawValue
+		rest   = eci.EncryptedContent.Bytes
+	)
+	for len(rest) > 0 {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &octets); err != nil {
+			return nil, err
+		}
+
+		if octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString || octets.IsCompound {
+			return nil, ASN1Error{"bad class or tag"}
+		}
+
+		value = append(value, octets.Bytes...)
+	}
+
+	return value, nil
+}
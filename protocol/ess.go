@@ -0,0 +1,277 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// ErrSigningCertificateMismatch is returned when the signer's certificate
+// doesn't match the signing-certificate or signing-certificate-v2 attribute.
+var ErrSigningCertificateMismatch = errors.New("cms/protocol: certificate doesn't match signing-certificate attribute")
+
+// SigningCertificateV2 ::= SEQUENCE {
+//   certs SEQUENCE OF ESSCertIDv2,
+//   policies SEQUENCE OF PolicyInformation OPTIONAL }
+type SigningCertificateV2 struct {
+	Certs    []ESSCertIDv2
+	Policies asn1.RawValue `asn1:"optional"`
+}
+
+// ESSCertIDv2 ::= SEQUENCE {
+//   hashAlgorithm AlgorithmIdentifier DEFAULT {algorithm id-sha256},
+//   certHash Hash,
+//   issuerSerial IssuerSerial OPTIONAL }
+//
+// Hash ::= OCTET STRING
+type ESSCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  IssuerSerial `asn1:"optional"`
+}
+
+// SigningCertificate ::= SEQUENCE {
+//   certs SEQUENCE OF ESSCertID,
+//   policies SEQUENCE OF PolicyInformation OPTIONAL }
+type SigningCertificate struct {
+	Certs    []ESSCertID
+	Policies asn1.RawValue `asn1:"optional"`
+}
+
+// ESSCertID ::= SEQUENCE {
+//   certHash Hash, -- SHA-1
+//   issuerSerial IssuerSerial OPTIONAL }
+type ESSCertID struct {
+	CertHash     []byte
+	IssuerSerial IssuerSerial `asn1:"optional"`
+}
+
+// IssuerSerial ::= SEQUENCE {
+//   issuer GeneralNames,
+//   serialNumber CertificateSerialNumber }
+type IssuerSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// NewSigningCertificateV2Attribute creates a signing-certificate-v2 attribute
+// (RFC5035) identifying cert by its hash. The issuerSerial field is included if
+// includeIssuerSerial is true.
+func NewSigningCertificateV2Attribute(cert *x509.Certificate, hash crypto.Hash, includeIssuerSerial bool) (Attribute, error) {
+	id, err := NewESSCertIDv2(cert, hash, includeIssuerSerial)
+	if err != nil {
+		return Attribute{}, err
+	}
+
+	return NewAttribute(oid.AttributeSigningCertificateV2, SigningCertificateV2{Certs: []ESSCertIDv2{id}})
+}
+
+// NewESSCertIDv2 creates an ESSCertIDv2 for cert. The hashAlgorithm is
+// omitted when it is the default SHA-256.
+func NewESSCertIDv2(cert *x509.Certificate, hash crypto.Hash, includeIssuerSerial bool) (ESSCertIDv2, error) {
+	var id ESSCertIDv2
+
+	digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+	if !ok || !hash.Available() {
+		return id, ErrUnsupported
+	}
+	if hash != crypto.SHA256 {
+		id.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: digestOID}
+	}
+
+	h := hash.New()
+	h.Write(cert.Raw)
+	id.CertHash = h.Sum(nil)
+
+	if includeIssuerSerial {
+		var err error
+		if id.IssuerSerial, err = NewIssuerSerial(cert); err != nil {
+			return id, err
+		}
+	}
+
+	return id, nil
+}
+
+// NewIssuerSerial creates an IssuerSerial for cert, with the issuer as a
+// directoryName.
+func NewIssuerSerial(cert *x509.Certificate) (IssuerSerial, error) {
+	// GeneralName ::= CHOICE { ... directoryName [4] Name, ... }
+	directoryName, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        4,
+		IsCompound: true,
+		Bytes:      cert.RawIssuer,
+	})
+	if err != nil {
+		return IssuerSerial{}, err
+	}
+
+	return IssuerSerial{
+		Issuer: asn1.RawValue{
+			Class:      asn1.ClassUniversal,
+			Tag:        asn1.TagSequence,
+			IsCompound: true,
+			Bytes:      directoryName,
+		},
+		SerialNumber: new(big.Int).Set(cert.SerialNumber),
+	}, nil
+}
+
+// Matches checks if the IssuerSerial identifies cert. One of the
+// GeneralNames must be a directoryName matching the cert's issuer.
+func (is IssuerSerial) Matches(cert *x509.Certificate) bool {
+	if is.SerialNumber == nil || is.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+
+	for rest := is.Issuer.Bytes; len(rest) > 0; {
+		var (
+			name asn1.RawValue
+			err  error
+		)
+		if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+			return false
+		}
+
+		if name.Class == asn1.ClassContextSpecific && name.Tag == 4 && bytes.Equal(name.Bytes, cert.RawIssuer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hash gets the crypto.Hash identified by the hashAlgorithm field.
+func (id ESSCertIDv2) Hash() (crypto.Hash, error) {
+	if len(id.HashAlgorithm.Algorithm) == 0 {
+		return crypto.SHA256, nil
+	}
+
+	hash := oid.DigestAlgorithmToCryptoHash[id.HashAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// Matches checks if the ESSCertIDv2 identifies cert.
+func (id ESSCertIDv2) Matches(cert *x509.Certificate) (bool, error) {
+	hash, err := id.Hash()
+	if err != nil {
+		return false, err
+	}
+
+	h := hash.New()
+	h.Write(cert.Raw)
+	if !bytes.Equal(h.Sum(nil), id.CertHash) {
+		return false, nil
+	}
+
+	if id.IssuerSerial.SerialNumber != nil && !id.IssuerSerial.Matches(cert) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Matches checks if the ESSCertID identifies cert.
+func (id ESSCertID) Matches(cert *x509.Certificate) bool {
+	digest := sha1.Sum(cert.Raw)
+	if !bytes.Equal(digest[:], id.CertHash) {
+		return false
+	}
+
+	if id.IssuerSerial.SerialNumber != nil && !id.IssuerSerial.Matches(cert) {
+		return false
+	}
+
+	return true
+}
+
+// GetSigningCertificateV2Attribute gets the signed signing-certificate-v2
+// attribute from the SignerInfo.
+func (si SignerInfo) GetSigningCertificateV2Attribute() (SigningCertificateV2, error) {
+	var sc SigningCertificateV2
+
+	rv, err := si.SignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeSigningCertificateV2)
+	if err != nil {
+		return sc, err
+	}
+
+	if rest, err := asn1.Unmarshal(rv.FullBytes, &sc); err != nil {
+		return sc, err
+	} else if len(rest) > 0 {
+		return sc, ErrTrailingData
+	}
+
+	if len(sc.Certs) == 0 {
+		return sc, ASN1Error{"empty signing-certificate-v2 attribute"}
+	}
+
+	return sc, nil
+}
+
+// GetSigningCertificateAttribute gets the signed signing-certificate attribute
+// from the SignerInfo.
+func (si SignerInfo) GetSigningCertificateAttribute() (SigningCertificate, error) {
+	var sc SigningCertificate
+
+	rv, err := si.SignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeSigningCertificate)
+	if err != nil {
+		return sc, err
+	}
+
+	if rest, err := asn1.Unmarshal(rv.FullBytes, &sc); err != nil {
+		return sc, err
+	} else if len(rest) > 0 {
+		return sc, ErrTrailingData
+	}
+
+	if len(sc.Certs) == 0 {
+		return sc, ASN1Error{"empty signing-certificate attribute"}
+	}
+
+	return sc, nil
+}
+
+// CheckSigningCertificate checks that cert is the certificate identified by
+// the signing-certificate-v2 and signing-certificate attributes, if present.
+// The first ESSCertID in each attribute identifies the signer's certificate
+// (RFC5035 section 3). ErrSigningCertificateMismatch is returned if it
+// doesn't match.
+func (si SignerInfo) CheckSigningCertificate(cert *x509.Certificate) error {
+	if si.SignedAttrs.HasAttribute(oid.AttributeSigningCertificateV2) {
+		sc, err := si.GetSigningCertificateV2Attribute()
+		if err != nil {
+			return err
+		}
+
+		if ok, err := sc.Certs[0].Matches(cert); err != nil {
+			return err
+		} else if !ok {
+			return ErrSigningCertificateMismatch
+		}
+	}
+
+	if si.SignedAttrs.HasAttribute(oid.AttributeSigningCertificate) {
+		sc, err := si.GetSigningCertificateAttribute()
+		if err != nil {
+			return err
+		}
+
+		if !sc.Certs[0].Matches(cert) {
+			return ErrSigningCertificateMismatch
+		}
+	}
+
+	return nil
+}
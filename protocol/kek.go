@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// KEKRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 4
+//   kekid KEKIdentifier,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   encryptedKey EncryptedKey }
+type KEKRecipientInfo struct {
+	Version                int
+	KEKID                  KEKIdentifier
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// KEKIdentifier ::= SEQUENCE {
+//   keyIdentifier OCTET STRING,
+//   date GeneralizedTime OPTIONAL,
+//   other OtherKeyAttribute OPTIONAL }
+type KEKIdentifier struct {
+	KeyIdentifier []byte
+	Date          time.Time     `asn1:"optional,generalized"`
+	Other         asn1.RawValue `asn1:"optional"`
+}
+
+// keyWrapAlgorithms are the AES key wrap algorithms for each key-encryption
+// key size.
+var keyWrapAlgorithms = map[int]asn1.ObjectIdentifier{
+	16: oid.KeyWrapAlgorithmAES128,
+	24: oid.KeyWrapAlgorithmAES192,
+	32: oid.KeyWrapAlgorithmAES256,
+}
+
+// NewKEKRecipientInfo encrypts the content-encryption key with a previously
+// distributed AES key-encryption key, identified by keyID. The AES key wrap
+// algorithm matching the size of kek is used.
+func NewKEKRecipientInfo(keyID, kek, key []byte) (KEKRecipientInfo, error) {
+	wrapAlgo, ok := keyWrapAlgorithms[len(kek)]
+	if !ok {
+		return KEKRecipientInfo{}, ErrUnsupported
+	}
+
+	encryptedKey, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return KEKRecipientInfo{}, err
+	}
+
+	return KEKRecipientInfo{
+		Version:                4,
+		KEKID:                  KEKIdentifier{KeyIdentifier: keyID},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: wrapAlgo},
+		EncryptedKey:           encryptedKey,
+	}, nil
+}
+
+// DecryptKey unwraps the content-encryption key with kek. ErrDecryption is
+// returned if kek is wrong.
+func (kekri KEKRecipientInfo) DecryptKey(kek []byte) ([]byte, error) {
+	kekSize, err := KeyWrapKeySize(kekri.KeyEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kek) != kekSize {
+		return nil, ErrDecryption
+	}
+
+	return aesKeyUnwrap(kek, kekri.EncryptedKey)
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	"github.com/github/ietf-cms/oid"
+	"golang.org/x/crypto/hkdf"
+)
+
+// OtherRecipientInfo ::= SEQUENCE {
+//   oriType OBJECT IDENTIFIER,
+//   oriValue ANY DEFINED BY oriType }
+type OtherRecipientInfo struct {
+	ORIType  asn1.ObjectIdentifier
+	ORIValue asn1.RawValue
+}
+
+// KEMRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 0
+//   rid RecipientIdentifier,
+//   kem KEMAlgorithmIdentifier,
+//   kemct OCTET STRING,
+//   kdf KeyDerivationAlgorithmIdentifier,
+//   kekLength INTEGER (1..65535),
+//   ukm [0] EXPLICIT UserKeyingMaterial OPTIONAL,
+//   wrap KeyEncryptionAlgorithmIdentifier,
+//   encryptedKey EncryptedKey }
+//
+// It is carried in an OtherRecipientInfo with the id-ori-kem type (RFC9629).
+type KEMRecipientInfo struct {
+	Version      int
+	RID          asn1.RawValue
+	KEM          pkix.AlgorithmIdentifier
+	KEMCT        []byte
+	KDF          pkix.AlgorithmIdentifier
+	KEKLength    int
+	UKM          []byte `asn1:"optional,explicit,tag:0"`
+	Wrap         pkix.AlgorithmIdentifier
+	EncryptedKey []byte
+}
+
+// CMSORIforKEMOtherInfo ::= SEQUENCE {
+//   wrap KeyEncryptionAlgorithmIdentifier,
+//   kekLength INTEGER (1..65535),
+//   ukm [0] EXPLICIT UserKeyingMaterial OPTIONAL }
+type cmsORIforKEMOtherInfo struct {
+	Wrap      pkix.AlgorithmIdentifier
+	KEKLength int
+	UKM       []byte `asn1:"optional,explicit,tag:0"`
+}
+
+// KEMPrivateKey is a private key that can decapsulate a shared secret, such as
+// an *mlkem.DecapsulationKey768, an *mlkem.DecapsulationKey1024 or a key held
+// in an HSM.
+type KEMPrivateKey interface {
+	Decapsulate(ciphertext []byte) ([]byte, error)
+}
+
+// kemPublicKey is implemented by *mlkem.EncapsulationKey768 and
+// *mlkem.EncapsulationKey1024.
+type kemPublicKey interface {
+	Encapsulate() (sharedKey, ciphertext []byte)
+}
+
+// IsKEMCertificate checks if cert has an ML-KEM public key, which needs a
+// KEMRecipientInfo.
+func IsKEMCertificate(cert *x509.Certificate) bool {
+	_, _, err := parseKEMPublicKeyInfo(cert)
+	return err == nil
+}
+
+// NewKEMRecipientInfo encrypts the content-encryption key for the ML-KEM key
+// in cert, as described in RFC9629 and draft-ietf-lamps-cms-kyber. The
+// key-encryption key is derived from the shared secret with HKDF-SHA256 and
+// used to wrap the key with opts.KeyWrapAlgorithm, or AES-256 key wrap if it's
+// nil.
+func NewKEMRecipientInfo(cert *x509.Certificate, key []byte, opts RecipientInfoOptions) (KEMRecipientInfo, error) {
+	kemAlgo, pub, err := parseKEMPublicKey(cert)
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	wrapAlgo := opts.KeyWrapAlgorithm
+	if wrapAlgo == nil {
+		wrapAlgo = oid.KeyWrapAlgorithmAES256
+	}
+
+	kekSize, err := KeyWrapKeySize(wrapAlgo)
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	// The version is 0 for either type of RecipientIdentifier.
+	rid, _, err := opts.recipientIdentifier(cert)
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	// AES key wrap algorithms have absent parameters.
+	wrapAlgoID := pkix.AlgorithmIdentifier{Algorithm: wrapAlgo}
+
+	sharedSecret, kemct := pub.Encapsulate()
+
+	kek, err := kemKDF(crypto.SHA256, sharedSecret, wrapAlgoID, kekSize, nil)
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	encryptedKey, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	return KEMRecipientInfo{
+		Version:      0,
+		RID:          rid,
+		KEM:          pkix.AlgorithmIdentifier{Algorithm: kemAlgo},
+		KEMCT:        kemct,
+		KDF:          pkix.AlgorithmIdentifier{Algorithm: oid.KeyDerivationAlgorithmHKDFWithSHA256},
+		KEKLength:    kekSize,
+		Wrap:         wrapAlgoID,
+		EncryptedKey: encryptedKey,
+	}, nil
+}
+
+// KDFHash gets the crypto.Hash used by the HKDF key derivation algorithm.
+func (kemri KEMRecipientInfo) KDFHash() (crypto.Hash, error) {
+	hash := oid.HKDFAlgorithmToCryptoHash[kemri.KDF.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// MatchesCertificate checks if the RecipientIdentifier identifies cert.
+func (kemri KEMRecipientInfo) MatchesCertificate(cert *x509.Certificate) bool {
+	return matchesRecipientIdentifier(kemri.RID, cert)
+}
+
+// DecryptKey decapsulates the shared secret with the recipient's private key,
+// derives the key-encryption key and unwraps the content-encryption key. The
+// key may be held in an HSM. ErrDecryption is returned if the key can't be
+// unwrapped.
+func (kemri KEMRecipientInfo) DecryptKey(key KEMPrivateKey) ([]byte, error) {
+	if !kemri.KEM.Algorithm.Equal(oid.KEMAlgorithmMLKEM768) && !kemri.KEM.Algorithm.Equal(oid.KEMAlgorithmMLKEM1024) {
+		return nil, ErrUnsupported
+	}
+
+	hash, err := kemri.KDFHash()
+	if err != nil {
+		return nil, err
+	}
+
+	kekSize, err := KeyWrapKeySize(kemri.Wrap.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if kemri.KEKLength != kekSize {
+		return nil, ASN1Error{"bad KEK length"}
+	}
+
+	// ML-KEM decapsulation only fails for malformed ciphertexts. A wrong key
+	// gives a random shared secret, which fails to unwrap the key.
+	sharedSecret, err := key.Decapsulate(kemri.KEMCT)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	kek, err := kemKDF(hash, sharedSecret, kemri.Wrap, kekSize, kemri.UKM)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesKeyUnwrap(kek, kemri.EncryptedKey)
+}
+
+// parseKEMPublicKey gets the ML-KEM algorithm and encapsulation key from cert.
+// ML-KEM requires Go 1.24, so ErrUnsupported is returned when built with an
+// older version.
+func parseKEMPublicKey(cert *x509.Certificate) (asn1.ObjectIdentifier, kemPublicKey, error) {
+	algo, key, err := parseKEMPublicKeyInfo(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub, err := newMLKEMEncapsulationKey(algo, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return algo, pub, nil
+}
+
+// parseKEMPublicKeyInfo gets the ML-KEM algorithm and the encoded encapsulation
+// key from cert. crypto/x509 doesn't parse ML-KEM public keys.
+func parseKEMPublicKeyInfo(cert *x509.Certificate) (asn1.ObjectIdentifier, []byte, error) {
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, ErrTrailingData
+	}
+
+	// The ML-KEM algorithms have absent parameters.
+	if len(spki.Algorithm.Parameters.FullBytes) > 0 {
+		return nil, nil, ErrUnsupported
+	}
+
+	algo := spki.Algorithm.Algorithm
+	if !algo.Equal(oid.KEMAlgorithmMLKEM768) && !algo.Equal(oid.KEMAlgorithmMLKEM1024) {
+		return nil, nil, errors.New("KEM recipients require an ML-KEM certificate")
+	}
+
+	return algo, spki.SubjectPublicKey.RightAlign(), nil
+}
+
+// kemKDF derives a key-encryption key of the given size from the KEM shared
+// secret using HKDF with no salt, with the CMSORIforKEMOtherInfo described in
+// RFC9629 section 5 as the info.
+func kemKDF(hash crypto.Hash, sharedSecret []byte, wrapAlgo pkix.AlgorithmIdentifier, size int, ukm []byte) ([]byte, error) {
+	info, err := asn1.Marshal(cmsORIforKEMOtherInfo{
+		Wrap:      wrapAlgo,
+		KEKLength: size,
+		UKM:       ukm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kek := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(hash.New, sharedSecret, nil, info), kek); err != nil {
+		return nil, err
+	}
+
+	return kek, nil
+}
@@ -0,0 +1,435 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// KeyAgreeRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 3
+//   originator [0] EXPLICIT OriginatorIdentifierOrKey,
+//   ukm [1] EXPLICIT UserKeyingMaterial OPTIONAL,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   recipientEncryptedKeys RecipientEncryptedKeys }
+//
+// OriginatorIdentifierOrKey ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   subjectKeyIdentifier [0] SubjectKeyIdentifier,
+//   originatorKey [1] OriginatorPublicKey }
+//
+// UserKeyingMaterial ::= OCTET STRING
+//
+// RecipientEncryptedKeys ::= SEQUENCE OF RecipientEncryptedKey
+type KeyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue `asn1:"explicit,tag:0"`
+	UKM                    []byte        `asn1:"optional,explicit,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []RecipientEncryptedKey
+}
+
+// OriginatorPublicKey ::= SEQUENCE {
+//   algorithm AlgorithmIdentifier,
+//   publicKey BIT STRING }
+type OriginatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// RecipientEncryptedKey ::= SEQUENCE {
+//   rid KeyAgreeRecipientIdentifier,
+//   encryptedKey EncryptedKey }
+//
+// KeyAgreeRecipientIdentifier ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   rKeyId [0] IMPLICIT RecipientKeyIdentifier }
+type RecipientEncryptedKey struct {
+	RID          asn1.RawValue
+	EncryptedKey []byte
+}
+
+// RecipientKeyIdentifier ::= SEQUENCE {
+//   subjectKeyIdentifier SubjectKeyIdentifier,
+//   date GeneralizedTime OPTIONAL,
+//   other OtherKeyAttribute OPTIONAL }
+type RecipientKeyIdentifier struct {
+	SubjectKeyIdentifier []byte
+	Date                 time.Time     `asn1:"optional,generalized"`
+	Other                asn1.RawValue `asn1:"optional"`
+}
+
+// ECC-CMS-SharedInfo ::= SEQUENCE {
+//   keyInfo AlgorithmIdentifier,
+//   entityUInfo [0] EXPLICIT OCTET STRING OPTIONAL,
+//   suppPubInfo [2] EXPLICIT OCTET STRING }
+type eccCMSSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	EntityUInfo []byte `asn1:"optional,explicit,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+// ECDHPrivateKey is a private key that can perform ECDH key agreement, such as
+// an *ecdh.PrivateKey or a key held in an HSM.
+type ECDHPrivateKey interface {
+	ECDH(remote *ecdh.PublicKey) ([]byte, error)
+}
+
+// NewKeyAgreeRecipientInfo encrypts the content-encryption key for the EC or
+// X25519 key in cert, using ephemeral-static ECDH as described in RFC5753 and
+// RFC8418.
+func NewKeyAgreeRecipientInfo(cert *x509.Certificate, key []byte, opts RecipientInfoOptions) (KeyAgreeRecipientInfo, error) {
+	pub, err := ecdhPublicKey(cert)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	hash, wrapAlgo := opts.KeyAgreementHash, opts.KeyWrapAlgorithm
+	if hash == 0 || wrapAlgo == nil {
+		defaultHash, defaultWrapAlgo := keyAgreementDefaults(pub.Curve())
+		if hash == 0 {
+			hash = defaultHash
+		}
+		if wrapAlgo == nil {
+			wrapAlgo = defaultWrapAlgo
+		}
+	}
+
+	keyAgreementOID, ok := oid.CryptoHashToKeyAgreementAlgorithm[hash]
+	if !ok || !hash.Available() {
+		return KeyAgreeRecipientInfo{}, ErrUnsupported
+	}
+
+	kekSize, err := KeyWrapKeySize(wrapAlgo)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	rid, err := opts.keyAgreeRecipientIdentifier(cert)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	z, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	// AES key wrap algorithms have absent parameters.
+	wrapAlgoID := pkix.AlgorithmIdentifier{Algorithm: wrapAlgo}
+
+	kek, err := x963KDF(hash, z, wrapAlgoID, nil, kekSize)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	encryptedKey, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	originator, err := newOriginatorPublicKey(ephemeral.PublicKey())
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	wrapAlgoDER, err := asn1.Marshal(wrapAlgoID)
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	return KeyAgreeRecipientInfo{
+		Version:    3,
+		Originator: originator,
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  keyAgreementOID,
+			Parameters: asn1.RawValue{FullBytes: wrapAlgoDER},
+		},
+		RecipientEncryptedKeys: []RecipientEncryptedKey{{RID: rid, EncryptedKey: encryptedKey}},
+	}, nil
+}
+
+// keyAgreementDefaults gets the KDF hash and key wrap algorithm recommended for
+// a curve by RFC5753 and RFC8418.
+func keyAgreementDefaults(curve ecdh.Curve) (crypto.Hash, asn1.ObjectIdentifier) {
+	switch curve {
+	case ecdh.P384():
+		return crypto.SHA384, oid.KeyWrapAlgorithmAES256
+	case ecdh.P521():
+		return crypto.SHA512, oid.KeyWrapAlgorithmAES256
+	default:
+		return crypto.SHA256, oid.KeyWrapAlgorithmAES128
+	}
+}
+
+// newOriginatorPublicKey creates the [0] EXPLICIT OriginatorIdentifierOrKey
+// holding an ephemeral public key as an originatorKey.
+func newOriginatorPublicKey(pub *ecdh.PublicKey) (asn1.RawValue, error) {
+	// The parameters are absent, since the curve is the recipient's.
+	algo := oid.PublicKeyAlgorithmECDSA
+	if pub.Curve() == ecdh.X25519() {
+		algo = oid.PublicKeyAlgorithmX25519
+	}
+
+	der, err := asn1.MarshalWithParams(OriginatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: algo},
+		PublicKey: asn1.BitString{Bytes: pub.Bytes(), BitLength: 8 * len(pub.Bytes())},
+	}, "tag:1")
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		Bytes:      der,
+		IsCompound: true,
+	}, nil
+}
+
+// OriginatorPublicKey gets the originator's ephemeral public key, assuming the
+// originator is an originatorKey.
+func (kari KeyAgreeRecipientInfo) OriginatorPublicKey() (OriginatorPublicKey, error) {
+	var (
+		opk OriginatorPublicKey
+		rv  asn1.RawValue
+	)
+
+	if rest, err := asn1.Unmarshal(kari.Originator.Bytes, &rv); err != nil {
+		return opk, err
+	} else if len(rest) > 0 {
+		return opk, ErrTrailingData
+	}
+
+	if rv.Class != asn1.ClassContextSpecific || rv.Tag != 1 {
+		return opk, ErrWrongType
+	}
+
+	if rest, err := asn1.UnmarshalWithParams(rv.FullBytes, &opk, "tag:1"); err != nil {
+		return opk, err
+	} else if len(rest) > 0 {
+		return opk, ErrTrailingData
+	}
+
+	return opk, nil
+}
+
+// ecdhPublicKey parses the public key on the recipient's curve.
+func (opk OriginatorPublicKey) ecdhPublicKey(curve ecdh.Curve) (*ecdh.PublicKey, error) {
+	expected := oid.PublicKeyAlgorithmECDSA
+	if curve == ecdh.X25519() {
+		expected = oid.PublicKeyAlgorithmX25519
+	}
+	if !opk.Algorithm.Algorithm.Equal(expected) {
+		return nil, ErrUnsupported
+	}
+
+	return curve.NewPublicKey(opk.PublicKey.RightAlign())
+}
+
+// KDFHash gets the crypto.Hash used by the KDF of the dhSinglePass-stdDH key
+// agreement algorithm.
+func (kari KeyAgreeRecipientInfo) KDFHash() (crypto.Hash, error) {
+	hash := oid.KeyAgreementAlgorithmToCryptoHash[kari.KeyEncryptionAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// KeyWrapAlgorithm gets the key wrap algorithm from the parameters of the key
+// agreement algorithm.
+func (kari KeyAgreeRecipientInfo) KeyWrapAlgorithm() (pkix.AlgorithmIdentifier, error) {
+	var algo pkix.AlgorithmIdentifier
+	if rest, err := asn1.Unmarshal(kari.KeyEncryptionAlgorithm.Parameters.FullBytes, &algo); err != nil {
+		return algo, err
+	} else if len(rest) > 0 {
+		return algo, ErrTrailingData
+	}
+
+	return algo, nil
+}
+
+// MatchesCertificate checks if any of the RecipientEncryptedKeys are for cert.
+func (kari KeyAgreeRecipientInfo) MatchesCertificate(cert *x509.Certificate) bool {
+	_, err := kari.FindRecipientEncryptedKey(cert)
+	return err == nil
+}
+
+// FindRecipientEncryptedKey finds the RecipientEncryptedKey for cert.
+// ErrNoRecipient is returned if there isn't one.
+func (kari KeyAgreeRecipientInfo) FindRecipientEncryptedKey(cert *x509.Certificate) (RecipientEncryptedKey, error) {
+	for _, rek := range kari.RecipientEncryptedKeys {
+		if matchesKeyAgreeRecipientIdentifier(rek.RID, cert) {
+			return rek, nil
+		}
+	}
+
+	return RecipientEncryptedKey{}, ErrNoRecipient
+}
+
+// DecryptKey derives the key-encryption key using the recipient's private key
+// for cert and unwraps the content-encryption key. The key may be held in an
+// HSM. ErrDecryption is returned if the key can't be unwrapped.
+func (kari KeyAgreeRecipientInfo) DecryptKey(cert *x509.Certificate, key ECDHPrivateKey) ([]byte, error) {
+	rek, err := kari.FindRecipientEncryptedKey(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := ecdhPublicKey(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	opk, err := kari.OriginatorPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := opk.ecdhPublicKey(pub.Curve())
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := kari.KDFHash()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapAlgo, err := kari.KeyWrapAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	kekSize, err := KeyWrapKeySize(wrapAlgo.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	kek, err := x963KDF(hash, z, wrapAlgo, kari.UKM, kekSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesKeyUnwrap(kek, rek.EncryptedKey)
+}
+
+// ecdhPublicKey gets the EC or X25519 public key from cert as an
+// *ecdh.PublicKey.
+func ecdhPublicKey(cert *x509.Certificate) (*ecdh.PublicKey, error) {
+	// crypto/x509 leaves the PublicKey nil for X25519 certificates.
+	pub := cert.PublicKey
+	if pub == nil {
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return pub.ECDH()
+	case *ecdh.PublicKey:
+		return pub, nil
+	default:
+		return nil, errors.New("key agreement requires an EC or X25519 certificate")
+	}
+}
+
+// x963KDF derives a key-encryption key of the given size from the shared
+// secret z using the ANSI X9.63 KDF, with the ECC-CMS-SharedInfo described in
+// RFC5753 section 7.2.
+func x963KDF(hash crypto.Hash, z []byte, wrapAlgo pkix.AlgorithmIdentifier, ukm []byte, size int) ([]byte, error) {
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(size*8))
+
+	sharedInfo, err := asn1.Marshal(eccCMSSharedInfo{
+		KeyInfo:     wrapAlgo,
+		EntityUInfo: ukm,
+		SuppPubInfo: suppPubInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		kek     []byte
+		counter = make([]byte, 4)
+		h       = hash.New()
+	)
+	for i := uint32(1); len(kek) < size; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+
+		h.Reset()
+		h.Write(z)
+		h.Write(counter)
+		h.Write(sharedInfo)
+		kek = h.Sum(kek)
+	}
+
+	return kek[:size], nil
+}
+
+// keyAgreeRecipientIdentifier creates the KeyAgreeRecipientIdentifier for cert.
+func (opts RecipientInfoOptions) keyAgreeRecipientIdentifier(cert *x509.Certificate) (asn1.RawValue, error) {
+	if !opts.SubjectKeyIdentifier {
+		return NewIssuerAndSerialNumber(cert)
+	}
+
+	ski, err := NewSubjectKeyIdentifier(cert)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	der, err := asn1.MarshalWithParams(RecipientKeyIdentifier{SubjectKeyIdentifier: ski.Bytes}, "tag:0")
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	var rid asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &rid); err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return rid, nil
+}
+
+// matchesKeyAgreeRecipientIdentifier checks if a KeyAgreeRecipientIdentifier,
+// which is either an IssuerAndSerialNumber or a [0] RecipientKeyIdentifier,
+// identifies cert.
+func matchesKeyAgreeRecipientIdentifier(rid asn1.RawValue, cert *x509.Certificate) bool {
+	switch {
+	case rid.Class == asn1.ClassUniversal && rid.Tag == asn1.TagSequence:
+		return matchesIssuerAndSerialNumber(rid, cert)
+	case rid.Class == asn1.ClassContextSpecific && rid.Tag == 0 && rid.IsCompound:
+		var rki RecipientKeyIdentifier
+		if rest, err := asn1.UnmarshalWithParams(rid.FullBytes, &rki, "tag:0"); err != nil || len(rest) > 0 {
+			return false
+		}
+
+		return MatchesSubjectKeyIdentifier(cert, rki.SubjectKeyIdentifier)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// KeyTransRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 0 or 2
+//   rid RecipientIdentifier,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   encryptedKey EncryptedKey }
+//
+// RecipientIdentifier ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   subjectKeyIdentifier [0] SubjectKeyIdentifier }
+//
+// KeyEncryptionAlgorithmIdentifier ::= AlgorithmIdentifier
+//
+// EncryptedKey ::= OCTET STRING
+type KeyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// recipientIdentifier creates the RecipientIdentifier for cert.
+func (opts RecipientInfoOptions) recipientIdentifier(cert *x509.Certificate) (asn1.RawValue, int, error) {
+	if opts.SubjectKeyIdentifier {
+		rid, err := NewSubjectKeyIdentifier(cert)
+		return rid, 2, err
+	}
+
+	rid, err := NewIssuerAndSerialNumber(cert)
+	return rid, 0, err
+}
+
+// NewKeyTransRecipientInfo encrypts the content-encryption key for the RSA key
+// in cert.
+func NewKeyTransRecipientInfo(cert *x509.Certificate, key []byte, opts RecipientInfoOptions) (KeyTransRecipientInfo, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return KeyTransRecipientInfo{}, errors.New("key transport requires an RSA certificate")
+	}
+
+	rid, version, err := opts.recipientIdentifier(cert)
+	if err != nil {
+		return KeyTransRecipientInfo{}, err
+	}
+
+	var (
+		keyEncryptionAlgorithm pkix.AlgorithmIdentifier
+		encryptedKey           []byte
+	)
+	if opts.OAEPHash != 0 {
+		if keyEncryptionAlgorithm, err = NewRSAESOAEPAlgorithmIdentifier(opts.OAEPHash); err != nil {
+			return KeyTransRecipientInfo{}, err
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(opts.OAEPHash.New(), rand.Reader, pub, key, nil); err != nil {
+			return KeyTransRecipientInfo{}, err
+		}
+	} else {
+		keyEncryptionAlgorithm = pkix.AlgorithmIdentifier{
+			Algorithm:  oid.KeyEncryptionAlgorithmRSA,
+			Parameters: asn1.NullRawValue,
+		}
+		if encryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, pub, key); err != nil {
+			return KeyTransRecipientInfo{}, err
+		}
+	}
+
+	return KeyTransRecipientInfo{
+		Version:                version,
+		RID:                    rid,
+		KeyEncryptionAlgorithm: keyEncryptionAlgorithm,
+		EncryptedKey:           encryptedKey,
+	}, nil
+}
+
+// MatchesCertificate checks if the RecipientIdentifier identifies cert.
+func (ktri KeyTransRecipientInfo) MatchesCertificate(cert *x509.Certificate) bool {
+	return matchesRecipientIdentifier(ktri.RID, cert)
+}
+
+// DecryptKey decrypts the content-encryption key with the recipient's RSA
+// private key, which may be held in an HSM. keySize is the size of the
+// content-encryption key. With PKCS#1 v1.5, a random key of this size is
+// returned if decryption fails, so that the failure can't be distinguished
+// from a wrong key (RFC3218).
+func (ktri KeyTransRecipientInfo) DecryptKey(decrypter crypto.Decrypter, keySize int) ([]byte, error) {
+	if _, ok := decrypter.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("key transport requires an RSA key")
+	}
+
+	var opts crypto.DecrypterOpts
+	switch {
+	case ktri.KeyEncryptionAlgorithm.Algorithm.Equal(oid.KeyEncryptionAlgorithmRSA):
+		opts = &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keySize}
+	case ktri.KeyEncryptionAlgorithm.Algorithm.Equal(oid.KeyEncryptionAlgorithmRSAOAEP):
+		params, err := ParseRSAESOAEPParams(ktri.KeyEncryptionAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		if opts, err = params.OAEPOptions(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+
+	key, err := decrypter.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	return key, nil
+}
+
+// matchesRecipientIdentifier checks if a RecipientIdentifier, which is either
+// an IssuerAndSerialNumber or a [0] SubjectKeyIdentifier, identifies cert.
+func matchesRecipientIdentifier(rid asn1.RawValue, cert *x509.Certificate) bool {
+	switch {
+	case rid.Class == asn1.ClassUniversal && rid.Tag == asn1.TagSequence:
+		return matchesIssuerAndSerialNumber(rid, cert)
+	case rid.Class == asn1.ClassContextSpecific && rid.Tag == 0:
+		return MatchesSubjectKeyIdentifier(cert, rid.Bytes)
+	default:
+		return false
+	}
+}
+
+// matchesIssuerAndSerialNumber checks if an IssuerAndSerialNumber identifies
+// cert.
+func matchesIssuerAndSerialNumber(rid asn1.RawValue, cert *x509.Certificate) bool {
+	var isn IssuerAndSerialNumber
+	if rest, err := asn1.Unmarshal(rid.FullBytes, &isn); err != nil || len(rest) > 0 {
+		return false
+	}
+
+	return bytes.Equal(cert.RawIssuer, isn.Issuer.FullBytes) && isn.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// keyWrapIV is the default initial value from RFC3394 section 2.2.3.1.
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// keyWrapKeySizes are the key-encryption key sizes of the supported key wrap
+// algorithms.
+var keyWrapKeySizes = map[string]int{
+	oid.KeyWrapAlgorithmAES128.String(): 16,
+	oid.KeyWrapAlgorithmAES192.String(): 24,
+	oid.KeyWrapAlgorithmAES256.String(): 32,
+}
+
+// KeyWrapKeySize gets the key-encryption key size in bytes of a key wrap
+// algorithm. ErrUnsupported is returned for unsupported algorithms.
+func KeyWrapKeySize(algo asn1.ObjectIdentifier) (int, error) {
+	size, ok := keyWrapKeySizes[algo.String()]
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	return size, nil
+}
+
+// aesKeyWrap wraps key with kek using the AES key wrap algorithm from RFC3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("cms/protocol: bad length for key to wrap")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, keyWrapIV)
+	copy(out[8:], key)
+
+	buf := make([]byte, aes.BlockSize)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, out[:8])
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Encrypt(buf, buf)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[8*i:8*i+8], buf[8:])
+		}
+	}
+
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps a key wrapped with kek using the AES key wrap algorithm
+// from RFC3394. ErrDecryption is returned if the integrity check fails.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrDecryption
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := append([]byte{}, wrapped[:8]...)
+	r := append([]byte{}, wrapped[8:]...)
+
+	buf := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[8*(i-1):8*i])
+			block.Decrypt(buf, buf)
+
+			copy(a, buf[:8])
+			copy(r[8*(i-1):8*i], buf[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, keyWrapIV) != 1 {
+		return nil, ErrDecryption
+	}
+
+	return r, nil
+}
@@ -0,0 +1,19 @@
+//go:build go1.24
+
+package protocol
+
+import (
+	"crypto/mlkem"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// newMLKEMEncapsulationKey parses an ML-KEM-768 or ML-KEM-1024 encapsulation
+// key.
+func newMLKEMEncapsulationKey(algo asn1.ObjectIdentifier, key []byte) (kemPublicKey, error) {
+	if algo.Equal(oid.KEMAlgorithmMLKEM1024) {
+		return mlkem.NewEncapsulationKey1024(key)
+	}
+	return mlkem.NewEncapsulationKey768(key)
+}
@@ -0,0 +1,11 @@
+//go:build !go1.24
+
+package protocol
+
+import "encoding/asn1"
+
+// newMLKEMEncapsulationKey returns ErrUnsupported because crypto/mlkem was
+// added in Go 1.24.
+func newMLKEMEncapsulationKey(algo asn1.ObjectIdentifier, key []byte) (kemPublicKey, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// DefaultPBKDF2IterationCount is the PBKDF2 iteration count used for
+	// PasswordRecipientInfos if none is given.
+	DefaultPBKDF2IterationCount = 600000
+
+	// maxPBKDF2IterationCount limits the work an attacker can make us do when
+	// decrypting.
+	maxPBKDF2IterationCount = 10000000
+
+	pbkdf2SaltSize = 16
+)
+
+// PasswordRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,   -- Always set to 0
+//   keyDerivationAlgorithm [0] KeyDerivationAlgorithmIdentifier
+//                                OPTIONAL,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   encryptedKey EncryptedKey }
+type PasswordRecipientInfo struct {
+	Version                int
+	KeyDerivationAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,tag:0"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// PBKDF2-params ::= SEQUENCE {
+//   salt CHOICE {
+//     specified OCTET STRING,
+//     otherSource AlgorithmIdentifier {{PBKDF2-SaltSources}}
+//   },
+//   iterationCount INTEGER (1..MAX),
+//   keyLength INTEGER (1..MAX) OPTIONAL,
+//   prf AlgorithmIdentifier {{PBKDF2-PRFs}} DEFAULT algid-hmacWithSHA1 }
+//
+// Only specified salts are supported.
+type PBKDF2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// NewPasswordRecipientInfo encrypts the content-encryption key with a
+// key-encryption key derived from password using PBKDF2, as described in
+// RFC3211. The key is wrapped with AES-256-CBC.
+func NewPasswordRecipientInfo(password, key []byte, opts RecipientInfoOptions) (PasswordRecipientInfo, error) {
+	prf := opts.PasswordPRF
+	if prf == 0 {
+		prf = crypto.SHA256
+	}
+
+	prfOID, ok := oid.CryptoHashToHMACAlgorithm[prf]
+	if !ok || !prf.Available() {
+		return PasswordRecipientInfo{}, ErrUnsupported
+	}
+
+	iterationCount := opts.PasswordIterationCount
+	if iterationCount == 0 {
+		iterationCount = DefaultPBKDF2IterationCount
+	}
+
+	// The random content-encryption key for the KEK cipher is discarded. We
+	// only need the AlgorithmIdentifier with a random IV.
+	kekAlgo, kek, err := NewContentEncryptionAlgorithm(oid.EncryptionAlgorithmAES256CBC)
+	if err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	salt := make([]byte, pbkdf2SaltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	params := PBKDF2Params{
+		Salt:           salt,
+		IterationCount: iterationCount,
+		KeyLength:      len(kek),
+	}
+
+	// DER omits fields with their DEFAULT value.
+	if prf != crypto.SHA1 {
+		params.PRF = pkix.AlgorithmIdentifier{Algorithm: prfOID, Parameters: asn1.NullRawValue}
+	}
+
+	kek = pbkdf2.Key(password, salt, iterationCount, len(kek), prf.New)
+
+	block, iv, err := newCBC(kekAlgo, kek)
+	if err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	encryptedKey, err := pwriKeyWrap(block, iv, key)
+	if err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	paramsDER, err := asn1.Marshal(params)
+	if err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	kekAlgoDER, err := asn1.Marshal(kekAlgo)
+	if err != nil {
+		return PasswordRecipientInfo{}, err
+	}
+
+	return PasswordRecipientInfo{
+		Version: 0,
+		KeyDerivationAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oid.KeyDerivationAlgorithmPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: paramsDER},
+		},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oid.KeyEncryptionAlgorithmPWRIKEK,
+			Parameters: asn1.RawValue{FullBytes: kekAlgoDER},
+		},
+		EncryptedKey: encryptedKey,
+	}, nil
+}
+
+// PBKDF2Params parses the PBKDF2 parameters from the keyDerivationAlgorithm.
+// ErrUnsupported is returned if another key derivation algorithm is used.
+func (pwri PasswordRecipientInfo) PBKDF2Params() (PBKDF2Params, error) {
+	var params PBKDF2Params
+
+	if !pwri.KeyDerivationAlgorithm.Algorithm.Equal(oid.KeyDerivationAlgorithmPBKDF2) {
+		return params, ErrUnsupported
+	}
+
+	if rest, err := asn1.Unmarshal(pwri.KeyDerivationAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return params, err
+	} else if len(rest) > 0 {
+		return params, ErrTrailingData
+	}
+
+	return params, nil
+}
+
+// PRFHash gets the crypto.Hash used with HMAC as the PRF.
+func (params PBKDF2Params) PRFHash() (crypto.Hash, error) {
+	if len(params.PRF.Algorithm) == 0 {
+		return crypto.SHA1, nil
+	}
+
+	if len(params.PRF.Parameters.FullBytes) > 0 && !bytes.Equal(params.PRF.Parameters.FullBytes, asn1.NullBytes) {
+		return 0, ErrUnsupported
+	}
+
+	hash := oid.HMACAlgorithmToCryptoHash[params.PRF.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// KeyEncryptionKeyAlgorithm gets the algorithm used to wrap the key from the
+// parameters of the id-alg-PWRI-KEK key encryption algorithm.
+func (pwri PasswordRecipientInfo) KeyEncryptionKeyAlgorithm() (pkix.AlgorithmIdentifier, error) {
+	var algo pkix.AlgorithmIdentifier
+
+	if !pwri.KeyEncryptionAlgorithm.Algorithm.Equal(oid.KeyEncryptionAlgorithmPWRIKEK) {
+		return algo, ErrUnsupported
+	}
+
+	if rest, err := asn1.Unmarshal(pwri.KeyEncryptionAlgorithm.Parameters.FullBytes, &algo); err != nil {
+		return algo, err
+	} else if len(rest) > 0 {
+		return algo, ErrTrailingData
+	}
+
+	return algo, nil
+}
+
+// DecryptKey derives the key-encryption key from password and unwraps the
+// content-encryption key. ErrDecryption is returned if the password is wrong.
+// Only AES-CBC is supported for wrapping the key.
+func (pwri PasswordRecipientInfo) DecryptKey(password []byte) ([]byte, error) {
+	params, err := pwri.PBKDF2Params()
+	if err != nil {
+		return nil, err
+	}
+
+	if params.IterationCount < 1 || params.IterationCount > maxPBKDF2IterationCount {
+		return nil, ErrUnsupported
+	}
+
+	prf, err := params.PRFHash()
+	if err != nil {
+		return nil, err
+	}
+
+	kekAlgo, err := pwri.KeyEncryptionKeyAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	if IsAuthenticatedEncryptionAlgorithm(kekAlgo.Algorithm) {
+		return nil, ErrUnsupported
+	}
+
+	kekSize, err := ContentEncryptionKeySize(kekAlgo.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.KeyLength != 0 && params.KeyLength != kekSize {
+		return nil, ASN1Error{"bad PBKDF2 key length"}
+	}
+
+	kek := pbkdf2.Key(password, params.Salt, params.IterationCount, kekSize, prf.New)
+
+	block, iv, err := newCBC(kekAlgo, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return pwriKeyUnwrap(block, iv, pwri.EncryptedKey)
+}
+
+// pwriKeyWrap wraps key with the block cipher in CBC mode, as described in
+// RFC3211 section 2.3.1.
+func pwriKeyWrap(block cipher.Block, iv, key []byte) ([]byte, error) {
+	if len(key) < 3 || len(key) > 255 {
+		return nil, errors.New("cms/protocol: bad length for key to wrap")
+	}
+
+	// The length byte, check bytes and key are padded to at least two blocks.
+	bs := block.BlockSize()
+	size := (4 + len(key) + bs - 1) / bs * bs
+	if size < 2*bs {
+		size = 2 * bs
+	}
+
+	wrapped := make([]byte, size)
+	wrapped[0] = byte(len(key))
+	for i := 0; i < 3; i++ {
+		wrapped[1+i] = ^key[i]
+	}
+	copy(wrapped[4:], key)
+	if _, err := rand.Read(wrapped[4+len(key):]); err != nil {
+		return nil, err
+	}
+
+	// The key is encrypted twice, the second time using the last block of the
+	// first pass as the IV.
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(wrapped, wrapped)
+	lastBlock := append([]byte{}, wrapped[size-bs:]...)
+	cipher.NewCBCEncrypter(block, lastBlock).CryptBlocks(wrapped, wrapped)
+
+	return wrapped, nil
+}
+
+// pwriKeyUnwrap unwraps a key wrapped with the block cipher in CBC mode, as
+// described in RFC3211 section 2.3.2. ErrDecryption is returned if the check
+// bytes don't match.
+func pwriKeyUnwrap(block cipher.Block, iv, wrapped []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(wrapped) < 2*bs || len(wrapped)%bs != 0 {
+		return nil, ErrDecryption
+	}
+
+	// Decrypting the last block using the previous one as the IV gets the IV
+	// used for the second pass.
+	n := len(wrapped)
+	lastBlock := make([]byte, bs)
+	cipher.NewCBCDecrypter(block, wrapped[n-2*bs:n-bs]).CryptBlocks(lastBlock, wrapped[n-bs:])
+
+	unwrapped := make([]byte, n)
+	cipher.NewCBCDecrypter(block, lastBlock).CryptBlocks(unwrapped, wrapped)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(unwrapped, unwrapped)
+
+	keyLen := int(unwrapped[0])
+	if keyLen < 3 || 4+keyLen > n {
+		return nil, ErrDecryption
+	}
+
+	check := (unwrapped[1] ^ unwrapped[4]) & (unwrapped[2] ^ unwrapped[5]) & (unwrapped[3] ^ unwrapped[6])
+	if subtle.ConstantTimeByteEq(check, 0xFF) != 1 {
+		return nil, ErrDecryption
+	}
+
+	return unwrapped[4 : 4+keyLen], nil
+}
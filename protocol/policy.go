@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// ErrAlgorithmNotAllowed is returned when an algorithm or key isn't allowed by
+// an AlgorithmPolicy. It is wrapped in an error describing the algorithm.
+var ErrAlgorithmNotAllowed = errors.New("cms/protocol: algorithm not allowed by policy")
+
+// AlgorithmPolicy restricts the algorithms and keys that SignerInfos may use.
+type AlgorithmPolicy struct {
+	// DigestAlgorithms are the allowed digest algorithms.
+	DigestAlgorithms []crypto.Hash
+
+	// SignatureAlgorithms are the allowed signature algorithms.
+	SignatureAlgorithms []x509.SignatureAlgorithm
+
+	// AllowEd448 allows Ed448 signatures with SHAKE256 digests, which don't
+	// have crypto.Hash or x509.SignatureAlgorithm values.
+	AllowEd448 bool
+
+	// MinRSAKeySize is the minimum RSA modulus size in bits.
+	MinRSAKeySize int
+
+	// MinECKeySize is the minimum ECDSA curve size in bits.
+	MinECKeySize int
+
+	// DigestAlgorithmCutoffs restrict allowed digest algorithms to signatures
+	// made before the given times. For example, SHA-1 could be accepted only
+	// for signatures timestamped before 2017.
+	DigestAlgorithmCutoffs map[crypto.Hash]time.Time
+
+	// SignatureAlgorithmCutoffs restrict allowed signature algorithms to
+	// signatures made before the given times.
+	SignatureAlgorithmCutoffs map[x509.SignatureAlgorithm]time.Time
+}
+
+// DefaultAlgorithmPolicy returns the policy used when none is specified. It
+// allows SHA-2 digests, RSA PKCS#1 v1.5 and RSASSA-PSS with keys of at least
+// 2048 bits, ECDSA with curves of at least 256 bits, Ed25519 and Ed448.
+func DefaultAlgorithmPolicy() *AlgorithmPolicy {
+	return &AlgorithmPolicy{
+		DigestAlgorithms: []crypto.Hash{
+			crypto.SHA256,
+			crypto.SHA384,
+			crypto.SHA512,
+		},
+		SignatureAlgorithms: []x509.SignatureAlgorithm{
+			x509.SHA256WithRSA,
+			x509.SHA384WithRSA,
+			x509.SHA512WithRSA,
+			x509.SHA256WithRSAPSS,
+			x509.SHA384WithRSAPSS,
+			x509.SHA512WithRSAPSS,
+			x509.ECDSAWithSHA256,
+			x509.ECDSAWithSHA384,
+			x509.ECDSAWithSHA512,
+			x509.PureEd25519,
+		},
+		AllowEd448:    true,
+		MinRSAKeySize: 2048,
+		MinECKeySize:  256,
+	}
+}
+
+// LegacyAlgorithmPolicy returns a policy for verifying old signatures. In
+// addition to the algorithms allowed by DefaultAlgorithmPolicy, it allows MD5
+// and SHA-1 digests, DSA, RSA keys of at least 1024 bits and any ECDSA curve.
+// Cut-offs can be added to restrict the weaker algorithms to signatures made
+// before a given time.
+func LegacyAlgorithmPolicy() *AlgorithmPolicy {
+	p := DefaultAlgorithmPolicy()
+
+	p.DigestAlgorithms = append(p.DigestAlgorithms, crypto.SHA1, crypto.MD5)
+	p.SignatureAlgorithms = append(p.SignatureAlgorithms,
+		x509.SHA1WithRSA,
+		x509.MD5WithRSA,
+		x509.ECDSAWithSHA1,
+		x509.DSAWithSHA1,
+		x509.DSAWithSHA256,
+	)
+	p.MinRSAKeySize = 1024
+	p.MinECKeySize = 0
+
+	return p
+}
+
+// CheckSignerInfo checks that the SignerInfo's digest and signature
+// algorithms and cert's public key are allowed for a signature made at time t.
+// An error wrapping ErrAlgorithmNotAllowed is returned if they aren't.
+func (p *AlgorithmPolicy) CheckSignerInfo(si SignerInfo, cert *x509.Certificate, t time.Time) error {
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmEd448) {
+		if !p.AllowEd448 {
+			return fmt.Errorf("%w: Ed448", ErrAlgorithmNotAllowed)
+		}
+
+		// Ed448 is used with SHAKE256, but allow other digests the policy
+		// accepts too.
+		if si.DigestAlgorithm.Algorithm.Equal(oid.DigestAlgorithmSHAKE256) {
+			return nil
+		}
+	} else if err := p.CheckSignatureAlgorithm(si.X509SignatureAlgorithm(), t); err != nil {
+		return err
+	}
+
+	hash, err := si.Hash()
+	if err != nil {
+		return fmt.Errorf("%w: digest algorithm %s", ErrAlgorithmNotAllowed, si.DigestAlgorithm.Algorithm)
+	}
+	if err = p.CheckDigestAlgorithm(hash, t); err != nil {
+		return err
+	}
+
+	return p.CheckPublicKey(cert.PublicKey)
+}
+
+// CheckDigestAlgorithm checks that hash is allowed for a signature made at
+// time t.
+func (p *AlgorithmPolicy) CheckDigestAlgorithm(hash crypto.Hash, t time.Time) error {
+	for _, allowed := range p.DigestAlgorithms {
+		if hash != allowed {
+			continue
+		}
+
+		if cutoff, ok := p.DigestAlgorithmCutoffs[hash]; ok && !t.Before(cutoff) {
+			return fmt.Errorf("%w: digest algorithm %s after %s", ErrAlgorithmNotAllowed, hash, cutoff)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: digest algorithm %s", ErrAlgorithmNotAllowed, hash)
+}
+
+// CheckSignatureAlgorithm checks that sigAlg is allowed for a signature made
+// at time t.
+func (p *AlgorithmPolicy) CheckSignatureAlgorithm(sigAlg x509.SignatureAlgorithm, t time.Time) error {
+	for _, allowed := range p.SignatureAlgorithms {
+		if sigAlg != allowed {
+			continue
+		}
+
+		if cutoff, ok := p.SignatureAlgorithmCutoffs[sigAlg]; ok && !t.Before(cutoff) {
+			return fmt.Errorf("%w: signature algorithm %s after %s", ErrAlgorithmNotAllowed, sigAlg, cutoff)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: signature algorithm %s", ErrAlgorithmNotAllowed, sigAlg)
+}
+
+// CheckPublicKey checks that an RSA or ECDSA public key is large enough. Other
+// key types have fixed sizes and are always allowed.
+func (p *AlgorithmPolicy) CheckPublicKey(pub crypto.PublicKey) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if size := pub.N.BitLen(); size < p.MinRSAKeySize {
+			return fmt.Errorf("%w: %d bit RSA key", ErrAlgorithmNotAllowed, size)
+		}
+	case *ecdsa.PublicKey:
+		if size := pub.Curve.Params().BitSize; size < p.MinECKeySize {
+			return fmt.Errorf("%w: %d bit ECDSA key", ErrAlgorithmNotAllowed, size)
+		}
+	}
+
+	return nil
+}
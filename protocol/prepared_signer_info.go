@@ -0,0 +1,227 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// PreparedSignerInfo is a SignerInfo that is waiting for its signature. It
+// allows the signature to be made by an external service that can't be
+// represented as a crypto.Signer. It can be serialized with Marshal, so that
+// preparing and completing the SignerInfo can happen in different processes.
+//
+// PreparedSignerInfo ::= SEQUENCE {
+//   signerInfo SignerInfo,
+//   certificates SET OF Certificate }
+//
+// The first certificate is the signer's. The signature field of signerInfo is
+// empty.
+type PreparedSignerInfo struct {
+	SignerInfo   SignerInfo
+	Certificates []asn1.RawValue `asn1:"set"`
+}
+
+// PrepareSignerInfo builds a SignerInfo over the SignedData's EContent, without
+// signing it. The first certificate in chain must be the signer's. Any
+// additional intermediates will be added to the SignedData by
+// CompleteSignerInfo.
+func (sd *SignedData) PrepareSignerInfo(chain []*x509.Certificate, opts SignerInfoOptions) (PreparedSignerInfo, error) {
+	content, err := sd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return PreparedSignerInfo{}, err
+	}
+	if content == nil {
+		return PreparedSignerInfo{}, errors.New("already detached")
+	}
+
+	return prepareSignerInfo(bytes.NewReader(content), sd.EncapContentInfo.EContentType, chain, opts)
+}
+
+// PrepareDetachedSignerInfo is like PrepareSignerInfo, but for a SignedData
+// without EContent. The signed content is read from r.
+func (sd *SignedData) PrepareDetachedSignerInfo(r io.Reader, chain []*x509.Certificate, opts SignerInfoOptions) (PreparedSignerInfo, error) {
+	if sd.EncapContentInfo.EContent.Bytes != nil {
+		return PreparedSignerInfo{}, errors.New("not detached")
+	}
+
+	return prepareSignerInfo(r, sd.EncapContentInfo.EContentType, chain, opts)
+}
+
+// ParsePreparedSignerInfo parses a PreparedSignerInfo from DER encoded data.
+func ParsePreparedSignerInfo(der []byte) (PreparedSignerInfo, error) {
+	var psi PreparedSignerInfo
+
+	if rest, err := asn1.Unmarshal(der, &psi); err != nil {
+		return psi, err
+	} else if len(rest) > 0 {
+		return psi, ErrTrailingData
+	}
+
+	if len(psi.Certificates) == 0 {
+		return psi, ErrNoCertificate
+	}
+
+	return psi, nil
+}
+
+// Marshal DER encodes the PreparedSignerInfo.
+func (psi PreparedSignerInfo) Marshal() ([]byte, error) {
+	return asn1.Marshal(psi)
+}
+
+// X509Certificates gets the signer's certificate followed by any
+// intermediates.
+func (psi PreparedSignerInfo) X509Certificates() ([]*x509.Certificate, error) {
+	if len(psi.Certificates) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	certs := make([]*x509.Certificate, 0, len(psi.Certificates))
+	for _, raw := range psi.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// SignedMessage gets the DER encoded SignedAttrs that the signature is
+// calculated over.
+func (psi PreparedSignerInfo) SignedMessage() ([]byte, error) {
+	return psi.SignerInfo.SignedAttrs.MarshaledForSigning()
+}
+
+// SignatureInput gets the data and crypto.SignerOpts to pass to a
+// crypto.Signer. For most algorithms this is the digest of the SignedMessage.
+// EdDSA signs the SignedMessage itself (RFC8419), in which case the
+// SignerOpts' HashFunc is zero.
+func (psi PreparedSignerInfo) SignatureInput() ([]byte, crypto.SignerOpts, error) {
+	sm, err := psi.SignedMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	si := psi.SignerInfo
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmEd25519) {
+		return sm, crypto.Hash(0), nil
+	}
+
+	hash, err := si.Hash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var opts crypto.SignerOpts = hash
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		params, err := ParseRSASSAPSSParams(si.SignatureAlgorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var pssOpts *rsa.PSSOptions
+		if hash, pssOpts, err = params.PSSOptions(); err != nil {
+			return nil, nil, err
+		}
+		opts = pssOpts
+	}
+
+	md := hash.New()
+	if _, err = md.Write(sm); err != nil {
+		return nil, nil, err
+	}
+
+	return md.Sum(nil), opts, nil
+}
+
+// sign signs the SignatureInput with signer.
+func (psi PreparedSignerInfo) sign(signer crypto.Signer) ([]byte, error) {
+	signed, opts, err := psi.SignatureInput()
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Sign(rand.Reader, signed, opts)
+}
+
+// CompleteSignerInfo adds the signature to a PreparedSignerInfo and adds the
+// SignerInfo and certificates to the SignedData. The signature is checked
+// against the signer's certificate first.
+func (sd *SignedData) CompleteSignerInfo(psi PreparedSignerInfo, signature []byte) error {
+	certs, err := psi.X509Certificates()
+	if err != nil {
+		return err
+	}
+
+	si := psi.SignerInfo
+
+	// Make sure the SignerInfo was prepared for this SignedData.
+	ct, err := si.GetContentTypeAttribute()
+	if err != nil {
+		return err
+	}
+	if !ct.Equal(sd.EncapContentInfo.EContentType) {
+		return errors.New("prepared SignerInfo has wrong content type")
+	}
+
+	content, err := sd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return err
+	}
+	if content != nil {
+		md, err := si.NewHash()
+		if err != nil {
+			return err
+		}
+		if _, err = md.Write(content); err != nil {
+			return err
+		}
+
+		expected, err := si.GetMessageDigestAttribute()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(md.Sum(nil), expected) {
+			return errors.New("prepared SignerInfo has wrong message digest")
+		}
+	}
+
+	sm, err := psi.SignedMessage()
+	if err != nil {
+		return err
+	}
+
+	si.Signature = signature
+	if err = si.CheckSignature(certs[0], sm); err != nil {
+		return err
+	}
+
+	for _, c := range certs {
+		if err = sd.AddCertificate(c); err != nil {
+			return err
+		}
+	}
+
+	sd.addDigestAlgorithm(si.DigestAlgorithm)
+
+	// The SignedData version must be 3 if any SignerInfo is version 3 (RFC5652
+	// section 5.1).
+	if si.Version == 3 && sd.Version < 3 {
+		sd.Version = 3
+	}
+
+	sd.SignerInfos = append(sd.SignerInfos, si)
+
+	return nil
+}
@@ -5,19 +5,22 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
-	"crypto/rand"
-	_ "crypto/sha1" // for crypto.SHA1
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"math/big"
 	"sort"
 	"time"
 
 	"github.com/github/ietf-cms/oid"
+	"golang.org/x/crypto/sha3"
 )
 
 // ASN1Error is an error from parsing ASN.1 structures.
@@ -41,6 +44,9 @@ var (
 	// ErrNoCertificate is returned when a requested certificate cannot be found.
 	ErrNoCertificate = errors.New("no certificate found")
 
+	// ErrNoAttribute is returned when a requested attribute cannot be found.
+	ErrNoAttribute = errors.New("no attribute found")
+
 	// ErrUnsupported is returned when an unsupported type or version
 	// is encountered.
 	ErrUnsupported = ASN1Error{"unsupported type or version"}
@@ -94,6 +100,33 @@ func (ci ContentInfo) SignedDataContent() (*SignedData, error) {
 	return sd, nil
 }
 
+// ParseContent gets the content as a *SignedData, *EnvelopedData,
+// *AuthEnvelopedData or *AuthenticatedData, depending on contentType.
+// ErrUnsupported is returned for any other type of content.
+func (ci ContentInfo) ParseContent() (interface{}, error) {
+	var (
+		content interface{}
+		err     error
+	)
+	switch {
+	case ci.ContentType.Equal(oid.ContentTypeSignedData):
+		content, err = ci.SignedDataContent()
+	case ci.ContentType.Equal(oid.ContentTypeEnvelopedData):
+		content, err = ci.EnvelopedDataContent()
+	case ci.ContentType.Equal(oid.ContentTypeAuthEnvelopedData):
+		content, err = ci.AuthEnvelopedDataContent()
+	case ci.ContentType.Equal(oid.ContentTypeAuthenticatedData):
+		content, err = ci.AuthenticatedDataContent()
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
 // EncapsulatedContentInfo ::= SEQUENCE {
 //   eContentType ContentType,
 //   eContent [0] EXPLICIT OCTET STRING OPTIONAL }
@@ -238,6 +271,35 @@ func (a Attribute) Value() (AnySet, error) {
 	return DecodeAnySet(a.RawValue)
 }
 
+// AddValue adds another value to the Attribute's SET OF values.
+func (a *Attribute) AddValue(val interface{}) error {
+	as, err := a.Value()
+	if err != nil {
+		return err
+	}
+
+	der, err := asn1.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	var rv asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &rv); err != nil {
+		return err
+	}
+
+	as.Elements = append(as.Elements, rv)
+
+	var encoded asn1.RawValue
+	if err = as.Encode(&encoded); err != nil {
+		return err
+	}
+
+	a.RawValue = encoded
+
+	return nil
+}
+
 // Attributes is a common Go type for SignedAttributes and UnsignedAttributes.
 //
 // SignedAttributes ::= SET SIZE (1..MAX) OF Attribute
@@ -337,6 +399,20 @@ func (attrs Attributes) GetValues(oid asn1.ObjectIdentifier) ([]AnySet, error) {
 	return vals, nil
 }
 
+// RemoveAttribute returns a copy of the Attributes without any attributes of
+// the given type. Nil is returned if no attributes remain, so that the
+// OPTIONAL SET will be omitted when encoding.
+func (attrs Attributes) RemoveAttribute(oid asn1.ObjectIdentifier) Attributes {
+	var remaining Attributes
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oid) {
+			remaining = append(remaining, attr)
+		}
+	}
+
+	return remaining
+}
+
 // HasAttribute checks if an attribute is present.
 func (attrs Attributes) HasAttribute(oid asn1.ObjectIdentifier) bool {
 	for _, attr := range attrs {
@@ -381,6 +457,105 @@ func NewIssuerAndSerialNumber(cert *x509.Certificate) (rv asn1.RawValue, err err
 	return
 }
 
+// NewSubjectKeyIdentifier creates a [0] SubjectKeyIdentifier SID for the given
+// cert. The cert's SubjectKeyIdentifier extension is used if present.
+// Otherwise, the key identifier is derived using method 1 from RFC5280 section
+// 4.2.1.2.
+func NewSubjectKeyIdentifier(cert *x509.Certificate) (rv asn1.RawValue, err error) {
+	ski := cert.SubjectKeyId
+	if len(ski) == 0 {
+		var spk []byte
+		if spk, err = subjectPublicKey(cert); err != nil {
+			return
+		}
+
+		digest := sha1.Sum(spk)
+		ski = digest[:]
+	}
+
+	var der []byte
+	if der, err = asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: false,
+		Bytes:      ski,
+	}); err != nil {
+		return
+	}
+
+	if _, err = asn1.Unmarshal(der, &rv); err != nil {
+		return
+	}
+
+	return
+}
+
+// MatchesSubjectKeyIdentifier checks if ski identifies cert. If cert has a
+// SubjectKeyIdentifier extension, its value must match. Otherwise, ski is
+// compared to the key identifiers generated by the methods described in
+// RFC5280 section 4.2.1.2 and RFC7093.
+func MatchesSubjectKeyIdentifier(cert *x509.Certificate, ski []byte) bool {
+	if len(ski) == 0 {
+		return false
+	}
+
+	if len(cert.SubjectKeyId) > 0 {
+		return bytes.Equal(ski, cert.SubjectKeyId)
+	}
+
+	spk, err := subjectPublicKey(cert)
+	if err != nil {
+		return false
+	}
+
+	// RFC5280 method 1: SHA-1 of the subjectPublicKey.
+	sha1SPK := sha1.Sum(spk)
+	if bytes.Equal(ski, sha1SPK[:]) {
+		return true
+	}
+
+	// RFC5280 method 2: 0100 followed by the least significant 60 bits of the
+	// SHA-1 of the subjectPublicKey.
+	if len(ski) == 8 && ski[0]&0xF0 == 0x40 && ski[0]&0x0F == sha1SPK[12]&0x0F && bytes.Equal(ski[1:], sha1SPK[13:]) {
+		return true
+	}
+
+	// RFC7093 methods 1-3: leftmost 160 bits of the SHA-256, SHA-384 or
+	// SHA-512 of the subjectPublicKey.
+	// RFC7093 method 4: hash of the DER encoded SubjectPublicKeyInfo.
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		h := hash.New()
+		h.Write(spk)
+		if bytes.Equal(ski, h.Sum(nil)[:20]) {
+			return true
+		}
+
+		h.Reset()
+		h.Write(cert.RawSubjectPublicKeyInfo)
+		if bytes.Equal(ski, h.Sum(nil)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subjectPublicKey gets the value of the subjectPublicKey BIT STRING from a
+// certificate's SubjectPublicKeyInfo.
+func subjectPublicKey(cert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return spki.SubjectPublicKey.Bytes, nil
+}
+
 // SignerInfo ::= SEQUENCE {
 //   version CMSVersion,
 //   sid SignerIdentifier,
@@ -421,7 +596,7 @@ type SignerInfo struct {
 func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
 	switch si.Version {
 	case 1: // SID is issuer and serial number
-		isn, err := si.issuerAndSerialNumberSID()
+		isn, err := si.IssuerAndSerialNumber()
 		if err != nil {
 			return nil, err
 		}
@@ -432,18 +607,14 @@ func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certifica
 			}
 		}
 	case 3: // SID is SubjectKeyIdentifier
-		ski, err := si.subjectKeyIdentifierSID()
+		ski, err := si.SubjectKeyIdentifier()
 		if err != nil {
 			return nil, err
 		}
 
 		for _, cert := range certs {
-			for _, ext := range cert.Extensions {
-				if oid.ExtensionSubjectKeyIdentifier.Equal(ext.Id) {
-					if bytes.Equal(ski, ext.Value) {
-						return cert, nil
-					}
-				}
+			if MatchesSubjectKeyIdentifier(cert, ski) {
+				return cert, nil
 			}
 		}
 	default:
@@ -453,8 +624,9 @@ func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certifica
 	return nil, ErrNoCertificate
 }
 
-// issuerAndSerialNumberSID gets the SID, assuming it is a issuerAndSerialNumber.
-func (si SignerInfo) issuerAndSerialNumberSID() (isn IssuerAndSerialNumber, err error) {
+// IssuerAndSerialNumber gets the SID, assuming it is a issuerAndSerialNumber.
+// ErrWrongType is returned if it isn't.
+func (si SignerInfo) IssuerAndSerialNumber() (isn IssuerAndSerialNumber, err error) {
 	if si.SID.Class != asn1.ClassUniversal || si.SID.Tag != asn1.TagSequence {
 		err = ErrWrongType
 		return
@@ -468,8 +640,9 @@ func (si SignerInfo) issuerAndSerialNumberSID() (isn IssuerAndSerialNumber, err
 	return
 }
 
-// subjectKeyIdentifierSID gets the SID, assuming it is a subjectKeyIdentifier.
-func (si SignerInfo) subjectKeyIdentifierSID() ([]byte, error) {
+// SubjectKeyIdentifier gets the SID, assuming it is a subjectKeyIdentifier.
+// ErrWrongType is returned if it isn't.
+func (si SignerInfo) SubjectKeyIdentifier() ([]byte, error) {
 	if si.SID.Class != asn1.ClassContextSpecific || si.SID.Tag != 0 {
 		return nil, ErrWrongType
 	}
@@ -489,6 +662,43 @@ func (si SignerInfo) Hash() (crypto.Hash, error) {
 	return hash, nil
 }
 
+// NewHash creates a hash.Hash for this SignerInfo's DigestAlgorithm. Unlike
+// Hash, this supports SHAKE256 with 512-bit output, as used with Ed448
+// (RFC8419).
+func (si SignerInfo) NewHash() (hash.Hash, error) {
+	return NewDigest(si.DigestAlgorithm)
+}
+
+// NewDigest creates a hash.Hash for the given DigestAlgorithmIdentifier.
+func NewDigest(algo pkix.AlgorithmIdentifier) (hash.Hash, error) {
+	if algo.Algorithm.Equal(oid.DigestAlgorithmSHAKE256) {
+		return shake256Hash{sha3.NewShake256()}, nil
+	}
+
+	h := oid.DigestAlgorithmToCryptoHash[algo.Algorithm.String()]
+	if h == 0 || !h.Available() {
+		return nil, ErrUnsupported
+	}
+
+	return h.New(), nil
+}
+
+// shake256Hash adapts SHAKE256 to the hash.Hash interface with a fixed 512-bit
+// output.
+type shake256Hash struct {
+	sha3.ShakeHash
+}
+
+func (h shake256Hash) Sum(b []byte) []byte {
+	out := make([]byte, h.Size())
+	h.Clone().Read(out)
+	return append(b, out...)
+}
+
+func (h shake256Hash) Size() int { return 64 }
+
+func (h shake256Hash) BlockSize() int { return 136 }
+
 // X509SignatureAlgorithm gets the x509.SignatureAlgorithm that should be used
 // for verifying this SignerInfo's signature.
 func (si SignerInfo) X509SignatureAlgorithm() x509.SignatureAlgorithm {
@@ -501,9 +711,45 @@ func (si SignerInfo) X509SignatureAlgorithm() x509.SignatureAlgorithm {
 		return sa
 	}
 
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		params, err := ParseRSASSAPSSParams(si.SignatureAlgorithm)
+		if err != nil {
+			return x509.UnknownSignatureAlgorithm
+		}
+
+		return oid.RSAPSSDigestAlgorithmToX509SignatureAlgorithm[params.HashAlgorithm.Algorithm.String()]
+	}
+
 	return oid.PublicKeyAndDigestAlgorithmToX509SignatureAlgorithm[sigOID][digestOID]
 }
 
+// CheckSignature checks that this SignerInfo's signature over signedMessage
+// was made by cert's key.
+func (si SignerInfo) CheckSignature(cert *x509.Certificate, signedMessage []byte) error {
+	// RSASSA-PSS parameters may specify a salt length that x509 doesn't
+	// support, so we do the verification ourselves.
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		return checkRSAPSSSignature(cert, si.SignatureAlgorithm, signedMessage, si.Signature)
+	}
+
+	// Go doesn't support Ed448 keys at all.
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmEd448) {
+		pub, err := ed448PublicKey(cert.RawSubjectPublicKeyInfo)
+		if err != nil {
+			return err
+		}
+
+		return verifyEd448(pub, signedMessage, si.Signature)
+	}
+
+	algo := si.X509SignatureAlgorithm()
+	if algo == x509.UnknownSignatureAlgorithm {
+		return ErrUnsupported
+	}
+
+	return cert.CheckSignature(algo, signedMessage, si.Signature)
+}
+
 // GetContentTypeAttribute gets the signed ContentType attribute from the
 // SignerInfo.
 func (si SignerInfo) GetContentTypeAttribute() (asn1.ObjectIdentifier, error) {
@@ -624,52 +870,169 @@ func NewSignedData(eci EncapsulatedContentInfo) (*SignedData, error) {
 	}, nil
 }
 
+// SignerInfoOptions customizes the SignerInfo created by
+// AddSignerInfoWithOptions. The zero value picks the same algorithms and
+// attributes as AddSignerInfo.
+type SignerInfoOptions struct {
+	// DigestAlgorithm is the hash used for the message digest and the signature.
+	// If zero, it is derived from SignatureAlgorithm or, failing that, from the
+	// signer's public key: SHA-384 for P-384, SHA-512 for P-521 and Ed25519,
+	// and SHA-256 otherwise.
+	DigestAlgorithm crypto.Hash
+
+	// SignatureAlgorithm is the algorithm used for the signature. If zero, it
+	// is derived from the signer's public key and the digest algorithm.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	// OmitSigningTime prevents the signing-time attribute from being added.
+	OmitSigningTime bool
+
+	// OmitSigningCertificate prevents the signing-certificate-v2 attribute
+	// (RFC5035) from being added. This attribute binds the signer's
+	// certificate to the signature, so that it can't be substituted by another
+	// certificate for the same key.
+	OmitSigningCertificate bool
+
+	// SigningCertificateIssuerSerial includes the issuerSerial field in the
+	// signing-certificate-v2 attribute.
+	SigningCertificateIssuerSerial bool
+
+	// SubjectKeyIdentifier identifies the signer by [0] SubjectKeyIdentifier
+	// rather than IssuerAndSerialNumber. This requires version 3 SignerInfo
+	// and SignedData structures.
+	SubjectKeyIdentifier bool
+
+	// SignedAttrs are additional signed attributes. They may not duplicate the
+	// content-type, message-digest, signing-time or signing-certificate-v2
+	// attributes.
+	SignedAttrs Attributes
+
+	// AlgorithmPolicy restricts the algorithms and keys that may be used for
+	// signing. DefaultAlgorithmPolicy is used if it's nil.
+	AlgorithmPolicy *AlgorithmPolicy
+}
+
 // AddSignerInfo adds a SignerInfo to the SignedData.
 func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Signer) error {
-	// figure out which certificate is associated with signer.
-	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	return sd.AddSignerInfoWithOptions(chain, signer, SignerInfoOptions{})
+}
+
+// AddSignerInfoWithOptions adds a SignerInfo to the SignedData, using opts to
+// select algorithms and signed attributes.
+func (sd *SignedData) AddSignerInfoWithOptions(chain []*x509.Certificate, signer crypto.Signer, opts SignerInfoOptions) error {
+	// Get the message
+	content, err := sd.EncapContentInfo.EContentValue()
 	if err != nil {
 		return err
 	}
+	if content == nil {
+		return errors.New("already detached")
+	}
+
+	return sd.addSignerInfo(bytes.NewReader(content), chain, signer, opts)
+}
+
+// AddDetachedSignerInfo adds a SignerInfo to a SignedData without EContent.
+// The signed content is read from r and digested incrementally, so it never
+// needs to be held in memory.
+func (sd *SignedData) AddDetachedSignerInfo(r io.Reader, chain []*x509.Certificate, signer crypto.Signer, opts SignerInfoOptions) error {
+	if sd.EncapContentInfo.EContent.Bytes != nil {
+		return errors.New("not detached")
+	}
+
+	return sd.addSignerInfo(r, chain, signer, opts)
+}
+
+// addSignerInfo adds a SignerInfo over the content read from r.
+func (sd *SignedData) addSignerInfo(content io.Reader, chain []*x509.Certificate, signer crypto.Signer, opts SignerInfoOptions) error {
+	chain, err := signerChain(chain, signer)
+	if err != nil {
+		return err
+	}
+
+	psi, err := prepareSignerInfo(content, sd.EncapContentInfo.EContentType, chain, opts)
+	if err != nil {
+		return err
+	}
+
+	signature, err := psi.sign(signer)
+	if err != nil {
+		return err
+	}
+
+	return sd.CompleteSignerInfo(psi, signature)
+}
+
+// signerChain reorders chain so that the certificate associated with signer
+// comes first.
+func signerChain(chain []*x509.Certificate, signer crypto.Signer) ([]*x509.Certificate, error) {
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
 
 	var (
 		cert    *x509.Certificate
 		certPub []byte
+		others  []*x509.Certificate
 	)
 
 	for _, c := range chain {
-		if err = sd.AddCertificate(c); err != nil {
-			return err
-		}
-
 		if certPub, err = x509.MarshalPKIXPublicKey(c.PublicKey); err != nil {
-			return err
+			return nil, err
 		}
 
-		if bytes.Equal(pub, certPub) {
+		if cert == nil && bytes.Equal(pub, certPub) {
 			cert = c
+		} else {
+			others = append(others, c)
 		}
 	}
 	if cert == nil {
-		return ErrNoCertificate
+		return nil, ErrNoCertificate
 	}
 
-	sid, err := NewIssuerAndSerialNumber(cert)
-	if err != nil {
-		return err
+	return append([]*x509.Certificate{cert}, others...), nil
+}
+
+// prepareSignerInfo builds a SignerInfo over the content read from r, without
+// a signature. The first certificate in chain belongs to the signer. The
+// content-type attribute is omitted if contentType is nil, as is required for
+// countersignatures.
+func prepareSignerInfo(content io.Reader, contentType asn1.ObjectIdentifier, chain []*x509.Certificate, opts SignerInfoOptions) (PreparedSignerInfo, error) {
+	var psi PreparedSignerInfo
+
+	if len(chain) == 0 {
+		return psi, ErrNoCertificate
 	}
+	cert := chain[0]
 
-	digestAlgorithmID := digestAlgorithmForPublicKey(pub)
+	// The SignerInfo version is determined by the SID type (RFC5652 section
+	// 5.3).
+	var (
+		sid     asn1.RawValue
+		version int
+		err     error
+	)
 
-	signatureAlgorithmOID, ok := oid.X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm[cert.PublicKeyAlgorithm][digestAlgorithmID.Algorithm.String()]
-	if !ok {
-		return errors.New("unsupported certificate public key algorithm")
+	if opts.SubjectKeyIdentifier {
+		sid, err = NewSubjectKeyIdentifier(cert)
+		version = 3
+	} else {
+		sid, err = NewIssuerAndSerialNumber(cert)
+		version = 1
+	}
+	if err != nil {
+		return psi, err
 	}
 
-	signatureAlgorithmID := pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithmOID}
+	digestAlgorithmID, signatureAlgorithmID, err := opts.algorithms(cert)
+	if err != nil {
+		return psi, err
+	}
 
 	si := SignerInfo{
-		Version:            1,
+		Version:            version,
 		SID:                sid,
 		DigestAlgorithm:    digestAlgorithmID,
 		SignedAttrs:        nil,
@@ -678,88 +1041,211 @@ func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Sig
 		UnsignedAttrs:      nil,
 	}
 
-	// Get the message
-	content, err := sd.EncapContentInfo.EContentValue()
-	if err != nil {
-		return err
+	policy := opts.AlgorithmPolicy
+	if policy == nil {
+		policy = DefaultAlgorithmPolicy()
 	}
-	if content == nil {
-		return errors.New("already detached")
+	if err = policy.CheckSignerInfo(si, cert, time.Now()); err != nil {
+		return psi, err
 	}
 
 	// Digest the message.
-	hash, err := si.Hash()
+	md, err := si.NewHash()
 	if err != nil {
-		return err
+		return psi, err
 	}
-	md := hash.New()
-	if _, err = md.Write(content); err != nil {
-		return err
+	if _, err = io.Copy(md, content); err != nil {
+		return psi, err
 	}
 
-	// Build our SignedAttributes
-	stAttr, err := NewAttribute(oid.AttributeSigningTime, time.Now().UTC())
+	hash, err := si.Hash()
 	if err != nil {
-		return err
+		return psi, err
 	}
-	mdAttr, err := NewAttribute(oid.AttributeMessageDigest, md.Sum(nil))
-	if err != nil {
-		return err
+
+	// Build our SignedAttributes
+	if si.SignedAttrs, err = opts.signedAttributes(md.Sum(nil), contentType, cert, hash); err != nil {
+		return psi, err
 	}
-	ctAttr, err := NewAttribute(oid.AttributeContentType, sd.EncapContentInfo.EContentType)
-	if err != nil {
-		return err
+
+	psi.SignerInfo = si
+	for _, c := range chain {
+		var rv asn1.RawValue
+		if _, err = asn1.Unmarshal(c.Raw, &rv); err != nil {
+			return psi, err
+		}
+		psi.Certificates = append(psi.Certificates, rv)
 	}
 
-	// sort attributes to match required order in marshaled form
-	si.SignedAttrs, err = sortAttributes(stAttr, mdAttr, ctAttr)
-	if err != nil {
-		return err
+	return psi, nil
+}
+
+// algorithms picks the digest and signature AlgorithmIdentifiers to use for
+// signing with the given certificate's key.
+func (opts SignerInfoOptions) algorithms(cert *x509.Certificate) (digestAlgorithmID, signatureAlgorithmID pkix.AlgorithmIdentifier, err error) {
+	hash := opts.DigestAlgorithm
+
+	if opts.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		sigDigestOID, ok := oid.X509SignatureAlgorithmToDigestAlgorithm[opts.SignatureAlgorithm]
+		if !ok {
+			err = fmt.Errorf("unsupported signature algorithm: %s", opts.SignatureAlgorithm)
+			return
+		}
+
+		sigHash := oid.DigestAlgorithmToCryptoHash[sigDigestOID.String()]
+		if hash == 0 {
+			hash = sigHash
+		} else if hash != sigHash {
+			err = fmt.Errorf("signature algorithm %s doesn't match digest algorithm %s", opts.SignatureAlgorithm, hash)
+			return
+		}
 	}
 
-	// Signature is over the marshaled signed attributes
-	sm, err := si.SignedAttrs.MarshaledForSigning()
+	if hash == 0 {
+		digestAlgorithmID = digestAlgorithmForPublicKey(cert.PublicKey)
+	} else {
+		digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+		if !ok || !hash.Available() {
+			err = fmt.Errorf("unsupported digest algorithm: %s", hash)
+			return
+		}
+		digestAlgorithmID = pkix.AlgorithmIdentifier{Algorithm: digestOID}
+	}
+
+	if isRSAPSS(opts.SignatureAlgorithm) {
+		if cert.PublicKeyAlgorithm != x509.RSA {
+			err = fmt.Errorf("signature algorithm %s doesn't match certificate public key", opts.SignatureAlgorithm)
+			return
+		}
+
+		signatureAlgorithmID, err = NewRSASSAPSSAlgorithmIdentifier(hash)
+		return
+	}
+
+	signatureAlgorithmOID, ok := oid.X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm[cert.PublicKeyAlgorithm][digestAlgorithmID.Algorithm.String()]
+	if !ok {
+		err = errors.New("unsupported certificate public key algorithm")
+		return
+	}
+
+	// Make sure the requested signature algorithm can be made with this key.
+	if opts.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		if oid.SignatureAlgorithmToX509SignatureAlgorithm[signatureAlgorithmOID.String()] != opts.SignatureAlgorithm {
+			err = fmt.Errorf("signature algorithm %s doesn't match certificate public key", opts.SignatureAlgorithm)
+			return
+		}
+	}
+
+	signatureAlgorithmID = pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithmOID}
+
+	return
+}
+
+// isRSAPSS checks if algo is one of the RSASSA-PSS signature algorithms.
+func isRSAPSS(algo x509.SignatureAlgorithm) bool {
+	switch algo {
+	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return true
+	default:
+		return false
+	}
+}
+
+// signedAttributes builds the sorted SignedAttributes for a new SignerInfo.
+// The content-type attribute is omitted if contentType is nil. The
+// signing-certificate-v2 attribute identifies cert using hash.
+func (opts SignerInfoOptions) signedAttributes(messageDigest []byte, contentType asn1.ObjectIdentifier, cert *x509.Certificate, hash crypto.Hash) (Attributes, error) {
+	attrs := make([]Attribute, 0, len(opts.SignedAttrs)+4)
+
+	mdAttr, err := NewAttribute(oid.AttributeMessageDigest, messageDigest)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	smd := hash.New()
-	if _, errr := smd.Write(sm); errr != nil {
-		return errr
+	attrs = append(attrs, mdAttr)
+
+	if contentType != nil {
+		ctAttr, err := NewAttribute(oid.AttributeContentType, contentType)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, ctAttr)
 	}
-	if si.Signature, err = signer.Sign(rand.Reader, smd.Sum(nil), hash); err != nil {
-		return err
+
+	if !opts.OmitSigningTime {
+		stAttr, err := NewAttribute(oid.AttributeSigningTime, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, stAttr)
 	}
 
-	sd.addDigestAlgorithm(si.DigestAlgorithm)
+	if !opts.OmitSigningCertificate {
+		scAttr, err := NewSigningCertificateV2Attribute(cert, hash, opts.SigningCertificateIssuerSerial)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, scAttr)
+	}
 
-	sd.SignerInfos = append(sd.SignerInfos, si)
+	for _, attr := range opts.SignedAttrs {
+		if Attributes(attrs).HasAttribute(attr.Type) {
+			return nil, fmt.Errorf("duplicate signed attribute: %s", attr.Type)
+		}
+		attrs = append(attrs, attr)
+	}
 
-	return nil
+	// sort attributes to match required order in marshaled form
+	return sortAttributes(attrs...)
 }
 
 func sortAttributes(attrs ...Attribute) ([]Attribute, error) {
 	// Sort attrs by their encoded values (including tag and
 	// lengths) as specified in X690 Section 11.6 and implemented
 	// in go >= 1.15's asn1.Marshal().
-	sort.Slice(attrs, func(i, j int) bool {
-		return bytes.Compare(
-			attrs[i].RawValue.FullBytes,
-			attrs[j].RawValue.FullBytes) < 0
-	})
+	encoded := make([][]byte, len(attrs))
+	for i := range attrs {
+		der, err := asn1.Marshal(attrs[i])
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = der
+	}
+
+	sort.Sort(attributesByEncoding{attrs, encoded})
 
 	return attrs, nil
 }
 
-// algorithmsForPublicKey takes an opinionated stance on what algorithms to use
-// for the given public key.
+// attributesByEncoding sorts Attributes by their DER encodings.
+type attributesByEncoding struct {
+	attrs   []Attribute
+	encoded [][]byte
+}
+
+func (a attributesByEncoding) Len() int { return len(a.attrs) }
+
+func (a attributesByEncoding) Less(i, j int) bool {
+	return bytes.Compare(a.encoded[i], a.encoded[j]) < 0
+}
+
+func (a attributesByEncoding) Swap(i, j int) {
+	a.attrs[i], a.attrs[j] = a.attrs[j], a.attrs[i]
+	a.encoded[i], a.encoded[j] = a.encoded[j], a.encoded[i]
+}
+
+// digestAlgorithmForPublicKey takes an opinionated stance on what digest
+// algorithm to use for the given public key.
 func digestAlgorithmForPublicKey(pub crypto.PublicKey) pkix.AlgorithmIdentifier {
-	if ecPub, ok := pub.(*ecdsa.PublicKey); ok {
-		switch ecPub.Curve {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
 		case elliptic.P384():
 			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA384}
 		case elliptic.P521():
 			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512}
 		}
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512}
 	}
 
 	return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256}
@@ -828,6 +1314,127 @@ func (sd *SignedData) X509Certificates() ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
+// AddCRL adds a DER encoded CertificateList to the SignedData's crls. Nothing
+// is added if the same CRL is already present.
+func (sd *SignedData) AddCRL(der []byte) error {
+	var rv asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &rv); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return ErrTrailingData
+	}
+
+	if rv.Class != asn1.ClassUniversal || rv.Tag != asn1.TagSequence {
+		return ASN1Error{"bad CRL class or tag"}
+	}
+
+	sd.addRevocationInfoChoice(rv)
+
+	return nil
+}
+
+func (sd *SignedData) addRevocationInfoChoice(rv asn1.RawValue) {
+	for _, existing := range sd.CRLs {
+		if bytes.Equal(existing.FullBytes, rv.FullBytes) {
+			return
+		}
+	}
+
+	sd.CRLs = append(sd.CRLs, rv)
+}
+
+// CertificateLists gets the DER encoded CertificateLists from the crls field.
+// Other revocation info formats are skipped.
+func (sd *SignedData) CertificateLists() [][]byte {
+	var crls [][]byte
+	for _, rv := range sd.CRLs {
+		if rv.Class == asn1.ClassUniversal && rv.Tag == asn1.TagSequence {
+			crls = append(crls, rv.FullBytes)
+		}
+	}
+
+	return crls
+}
+
+// OtherRevocationInfoFormat ::= SEQUENCE {
+//   otherRevInfoFormat OBJECT IDENTIFIER,
+//   otherRevInfo ANY DEFINED BY otherRevInfoFormat }
+type OtherRevocationInfoFormat struct {
+	OtherRevInfoFormat asn1.ObjectIdentifier
+	OtherRevInfo       asn1.RawValue
+}
+
+// AddOtherRevocationInfo adds an other [1] RevocationInfoChoice with the given
+// format to the SignedData's crls. info is the DER encoded otherRevInfo.
+// Nothing is added if the same revocation info is already present.
+func (sd *SignedData) AddOtherRevocationInfo(format asn1.ObjectIdentifier, info []byte) error {
+	var infoRV asn1.RawValue
+	if rest, err := asn1.Unmarshal(info, &infoRV); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return ErrTrailingData
+	}
+
+	der, err := asn1.Marshal(OtherRevocationInfoFormat{
+		OtherRevInfoFormat: format,
+		OtherRevInfo:       infoRV,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Change the SEQUENCE tag to [1] IMPLICIT.
+	var rv asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &rv); err != nil {
+		return err
+	}
+	if der, err = asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        1,
+		IsCompound: true,
+		Bytes:      rv.Bytes,
+	}); err != nil {
+		return err
+	}
+	if _, err = asn1.Unmarshal(der, &rv); err != nil {
+		return err
+	}
+
+	sd.addRevocationInfoChoice(rv)
+
+	// The SignedData version must be 5 if other revocation info is present
+	// (RFC5652 section 5.1).
+	if sd.Version < 5 {
+		sd.Version = 5
+	}
+
+	return nil
+}
+
+// OtherRevocationInfo gets the DER encoded otherRevInfo values with the given
+// format from the crls field.
+func (sd *SignedData) OtherRevocationInfo(format asn1.ObjectIdentifier) ([][]byte, error) {
+	var infos [][]byte
+	for _, rv := range sd.CRLs {
+		if rv.Class != asn1.ClassContextSpecific || rv.Tag != 1 {
+			continue
+		}
+
+		var other OtherRevocationInfoFormat
+		if rest, err := asn1.UnmarshalWithParams(rv.FullBytes, &other, "tag:1"); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		if other.OtherRevInfoFormat.Equal(format) {
+			infos = append(infos, other.OtherRevInfo.FullBytes)
+		}
+	}
+
+	return infos, nil
+}
+
 // ContentInfo returns the SignedData wrapped in a ContentInfo packet.
 func (sd *SignedData) ContentInfo() (ContentInfo, error) {
 	var nilCI ContentInfo
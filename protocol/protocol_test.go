@@ -2,11 +2,18 @@ package protocol
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/base64"
 	"io"
+	"math/big"
 	"strings"
 	"testing"
 	"time"
@@ -273,6 +280,224 @@ func TestIssuerAndSerialNumber(t *testing.T) {
 	}
 }
 
+func TestRSASSAPSSParams(t *testing.T) {
+	// An empty SEQUENCE means every field takes its default value.
+	params, err := ParseRSASSAPSSParams(pkix.AlgorithmIdentifier{
+		Algorithm:  oid.SignatureAlgorithmRSAPSS,
+		Parameters: asn1.RawValue{FullBytes: []byte{0x30, 0x00}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, opts, err := params.PSSOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != crypto.SHA1 || opts.SaltLength != 20 || params.TrailerField != 1 {
+		t.Fatalf("bad defaults: %v %d %d", hash, opts.SaltLength, params.TrailerField)
+	}
+
+	// Round trip.
+	algo, err := NewRSASSAPSSAlgorithmIdentifier(crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var algo2 pkix.AlgorithmIdentifier
+	if _, err = asn1.Unmarshal(der, &algo2); err != nil {
+		t.Fatal(err)
+	}
+	if params, err = ParseRSASSAPSSParams(algo2); err != nil {
+		t.Fatal(err)
+	}
+	if hash, opts, err = params.PSSOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if hash != crypto.SHA384 || opts.SaltLength != crypto.SHA384.Size() {
+		t.Fatalf("bad params: %v %d", hash, opts.SaltLength)
+	}
+
+	// Go can't create or enforce a zero length salt with rsa.PSSOptions.
+	zeroSalt := params
+	zeroSalt.SaltLength = 0
+	if _, _, err = zeroSalt.PSSOptions(); err != ErrUnsupported {
+		t.Fatalf("expected %v, got %v", ErrUnsupported, err)
+	}
+
+	// Mismatched MGF1 hash isn't supported.
+	mgfParams, _ := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256})
+	params.MaskGenAlgorithm.Parameters = asn1.RawValue{FullBytes: mgfParams}
+	if _, _, err = params.PSSOptions(); err != ErrUnsupported {
+		t.Fatalf("expected %v, got %v", ErrUnsupported, err)
+	}
+
+	// Parameters are required.
+	if _, err = ParseRSASSAPSSParams(pkix.AlgorithmIdentifier{Algorithm: oid.SignatureAlgorithmRSAPSS}); err == nil {
+		t.Fatal("expected error for missing parameters")
+	}
+}
+
+func TestMatchesSubjectKeyIdentifier(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templ := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, templ, templ, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.SubjectKeyId) != 0 {
+		t.Fatal("expected cert without SubjectKeyIdentifier extension")
+	}
+
+	spk := elliptic.Marshal(elliptic.P256(), priv.X, priv.Y)
+	sha1SPK := sha1.Sum(spk)
+	sha256SPK := sha256.Sum256(spk)
+	sha256SPKI := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	method2 := append([]byte{0x40 | sha1SPK[12]&0x0F}, sha1SPK[13:]...)
+
+	for name, ski := range map[string][]byte{
+		"RFC5280 method 1": sha1SPK[:],
+		"RFC5280 method 2": method2,
+		"RFC7093 method 1": sha256SPK[:20],
+		"RFC7093 method 4": sha256SPKI[:],
+	} {
+		if !MatchesSubjectKeyIdentifier(cert, ski) {
+			t.Errorf("%s didn't match", name)
+		}
+	}
+
+	if MatchesSubjectKeyIdentifier(cert, sha256SPK[:]) {
+		t.Error("unexpected match")
+	}
+
+	// When the extension is present, only its value matches.
+	cert.SubjectKeyId = []byte{1, 2, 3}
+	if MatchesSubjectKeyIdentifier(cert, sha1SPK[:]) {
+		t.Error("unexpected match")
+	}
+	if !MatchesSubjectKeyIdentifier(cert, []byte{1, 2, 3}) {
+		t.Error("expected extension value to match")
+	}
+}
+
+func TestESSCertIDv2(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templ := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, templ, templ, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templ.SerialNumber = big.NewInt(2)
+	if der, err = x509.CreateCertificate(rand.Reader, templ, templ, priv.Public(), priv); err != nil {
+		t.Fatal(err)
+	}
+	other, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384} {
+		attr, err := NewSigningCertificateV2Attribute(cert, hash, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		si := SignerInfo{SignedAttrs: Attributes{attr}}
+
+		sc, err := si.GetSigningCertificateV2Attribute()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The default SHA-256 hashAlgorithm is omitted.
+		if hasAlgo := len(sc.Certs[0].HashAlgorithm.Algorithm) > 0; hasAlgo != (hash != crypto.SHA256) {
+			t.Fatalf("unexpected hashAlgorithm for %v: %v", hash, sc.Certs[0].HashAlgorithm.Algorithm)
+		}
+		if h, err := sc.Certs[0].Hash(); err != nil || h != hash {
+			t.Fatalf("expected %v, got %v (%v)", hash, h, err)
+		}
+		if !sc.Certs[0].IssuerSerial.Matches(cert) {
+			t.Fatal("expected IssuerSerial to match")
+		}
+
+		if err = si.CheckSigningCertificate(cert); err != nil {
+			t.Fatal(err)
+		}
+		if err = si.CheckSigningCertificate(other); err != ErrSigningCertificateMismatch {
+			t.Fatalf("expected %v, got %v", ErrSigningCertificateMismatch, err)
+		}
+	}
+
+	// Legacy signing-certificate attribute.
+	digest := sha1.Sum(cert.Raw)
+	attr, err := NewAttribute(oid.AttributeSigningCertificate, SigningCertificate{Certs: []ESSCertID{{CertHash: digest[:]}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := SignerInfo{SignedAttrs: Attributes{attr}}
+	if err = si.CheckSigningCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err = si.CheckSigningCertificate(other); err != ErrSigningCertificateMismatch {
+		t.Fatalf("expected %v, got %v", ErrSigningCertificateMismatch, err)
+	}
+
+	// No attribute.
+	if err = (SignerInfo{}).CheckSigningCertificate(other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseATSHashIndexV3(t *testing.T) {
+	for _, index := range []ATSHashIndexV3{
+		{
+			CertificatesHashIndex:       [][]byte{{1}, {2}},
+			CRLsHashIndex:               [][]byte{},
+			UnsignedAttrValuesHashIndex: [][]byte{{3}},
+		},
+		{
+			HashIndAlgorithm:            pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512},
+			CertificatesHashIndex:       [][]byte{},
+			CRLsHashIndex:               [][]byte{{4}},
+			UnsignedAttrValuesHashIndex: [][]byte{},
+		},
+	} {
+		der, err := asn1.Marshal(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseATSHashIndexV3(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reencoded, err := asn1.Marshal(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(der, reencoded) {
+			t.Fatal("round trip mismatch")
+		}
+	}
+}
+
 func TestParseSignatureOne(t *testing.T) {
 	testParseContentInfo(t, fixtureSignatureOne)
 }
@@ -312,6 +537,12 @@ func testParseContentInfo(t *testing.T, ber []byte) {
 		t.Fatal(err)
 	}
 
+	if content, err := ci.ParseContent(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := content.(*SignedData); !ok {
+		t.Fatalf("expected *SignedData, got %T", content)
+	}
+
 	certs, err := sd.X509Certificates()
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,352 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// ErrNoRecipient is returned when there isn't a RecipientInfo for the given
+// recipient.
+var ErrNoRecipient = errors.New("no recipient found")
+
+// RecipientInfos ::= SET SIZE (1..MAX) OF RecipientInfo
+//
+// RecipientInfo ::= CHOICE {
+//   ktri KeyTransRecipientInfo,
+//   kari [1] KeyAgreeRecipientInfo,
+//   kekri [2] KEKRecipientInfo,
+//   pwri [3] PasswordRecipientInfo,
+//   ori [4] OtherRecipientInfo }
+//
+// The RecipientInfo CHOICEs are kept encoded, since Go's asn1 package can't
+// decode CHOICEs.
+type RecipientInfos []asn1.RawValue
+
+// RecipientInfoOptions customizes the RecipientInfos created for recipients.
+// The zero value identifies recipients by issuer and serial number, uses RSA
+// PKCS#1 v1.5 for RSA keys, uses ECDH with the algorithms recommended for the
+// curve for EC and X25519 keys and uses AES-256 key wrap for ML-KEM keys.
+type RecipientInfoOptions struct {
+	// SubjectKeyIdentifier identifies recipients by SubjectKeyIdentifier rather
+	// than IssuerAndSerialNumber. Recipients' certificates should have a
+	// SubjectKeyIdentifier extension, since other implementations may not
+	// match identifiers derived from the public key.
+	SubjectKeyIdentifier bool
+
+	// OAEPHash, if set, makes the key be encrypted for RSA recipients with
+	// RSAES-OAEP, using OAEPHash for both OAEP and MGF1.
+	OAEPHash crypto.Hash
+
+	// KeyAgreementHash is the hash used by the KDF for EC and X25519
+	// recipients. If it's zero, SHA-256 is used for P-256 and X25519, SHA-384
+	// for P-384 and SHA-512 for P-521.
+	KeyAgreementHash crypto.Hash
+
+	// KeyWrapAlgorithm is the OID of the AES key wrap algorithm used to encrypt
+	// the key for EC, X25519 and ML-KEM recipients, such as
+	// oid.KeyWrapAlgorithmAES256. If it's nil, AES-128 key wrap is used for
+	// P-256 and X25519 and AES-256 key wrap for larger curves and ML-KEM.
+	KeyWrapAlgorithm asn1.ObjectIdentifier
+
+	// PasswordPRF is the hash used with HMAC as the PBKDF2 PRF for password
+	// recipients. SHA-256 is used if it's zero.
+	PasswordPRF crypto.Hash
+
+	// PasswordIterationCount is the PBKDF2 iteration count for password
+	// recipients. DefaultPBKDF2IterationCount is used if it's zero.
+	PasswordIterationCount int
+}
+
+// AddKeyTransRecipientInfo adds a KeyTransRecipientInfo.
+func (ris *RecipientInfos) AddKeyTransRecipientInfo(ktri KeyTransRecipientInfo) error {
+	der, err := asn1.Marshal(ktri)
+	if err != nil {
+		return err
+	}
+
+	return ris.add(der)
+}
+
+// AddKeyAgreeRecipientInfo adds a KeyAgreeRecipientInfo.
+func (ris *RecipientInfos) AddKeyAgreeRecipientInfo(kari KeyAgreeRecipientInfo) error {
+	der, err := asn1.MarshalWithParams(kari, "tag:1")
+	if err != nil {
+		return err
+	}
+
+	return ris.add(der)
+}
+
+// AddKEKRecipientInfo adds a KEKRecipientInfo.
+func (ris *RecipientInfos) AddKEKRecipientInfo(kekri KEKRecipientInfo) error {
+	der, err := asn1.MarshalWithParams(kekri, "tag:2")
+	if err != nil {
+		return err
+	}
+
+	return ris.add(der)
+}
+
+// AddPasswordRecipientInfo adds a PasswordRecipientInfo.
+func (ris *RecipientInfos) AddPasswordRecipientInfo(pwri PasswordRecipientInfo) error {
+	der, err := asn1.MarshalWithParams(pwri, "tag:3")
+	if err != nil {
+		return err
+	}
+
+	return ris.add(der)
+}
+
+// AddKEMRecipientInfo adds a KEMRecipientInfo, wrapped in an
+// OtherRecipientInfo.
+func (ris *RecipientInfos) AddKEMRecipientInfo(kemri KEMRecipientInfo) error {
+	kemriDER, err := asn1.Marshal(kemri)
+	if err != nil {
+		return err
+	}
+
+	der, err := asn1.MarshalWithParams(OtherRecipientInfo{
+		ORIType:  oid.OtherRecipientInfoTypeKEM,
+		ORIValue: asn1.RawValue{FullBytes: kemriDER},
+	}, "tag:4")
+	if err != nil {
+		return err
+	}
+
+	return ris.add(der)
+}
+
+// add adds a DER encoded RecipientInfo CHOICE.
+func (ris *RecipientInfos) add(der []byte) error {
+	var rv asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &rv); err != nil {
+		return err
+	}
+
+	*ris = append(*ris, rv)
+
+	return nil
+}
+
+// version gets the lowest version of the content type containing the
+// RecipientInfos that RFC5652 section 6.1 allows, ignoring the OriginatorInfo
+// and attributes. It is 3 if there is a PasswordRecipientInfo or
+// OtherRecipientInfo, 2 if any other RecipientInfo isn't version 0 and 0
+// otherwise.
+func (ris RecipientInfos) version() (int, error) {
+	version := 0
+	for _, ri := range ris {
+		switch {
+		case ri.Class == asn1.ClassContextSpecific && (ri.Tag == 3 || ri.Tag == 4):
+			// pwri or ori
+			return 3, nil
+		case ri.Class == asn1.ClassUniversal && ri.Tag == asn1.TagSequence:
+			var ktri KeyTransRecipientInfo
+			if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+				return 0, err
+			}
+			if ktri.Version != 0 {
+				version = 2
+			}
+		default:
+			version = 2
+		}
+	}
+
+	return version, nil
+}
+
+// KeyTransRecipientInfos gets the KeyTransRecipientInfos, skipping other types
+// of RecipientInfo.
+func (ris RecipientInfos) KeyTransRecipientInfos() ([]KeyTransRecipientInfo, error) {
+	var ktris []KeyTransRecipientInfo
+	for _, ri := range ris {
+		if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+			continue
+		}
+
+		var ktri KeyTransRecipientInfo
+		if rest, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		ktris = append(ktris, ktri)
+	}
+
+	return ktris, nil
+}
+
+// FindKeyTransRecipientInfo finds the KeyTransRecipientInfo for cert.
+// ErrNoRecipient is returned if there isn't one.
+func (ris RecipientInfos) FindKeyTransRecipientInfo(cert *x509.Certificate) (KeyTransRecipientInfo, error) {
+	ktris, err := ris.KeyTransRecipientInfos()
+	if err != nil {
+		return KeyTransRecipientInfo{}, err
+	}
+
+	for _, ktri := range ktris {
+		if matchesRecipientIdentifier(ktri.RID, cert) {
+			return ktri, nil
+		}
+	}
+
+	return KeyTransRecipientInfo{}, ErrNoRecipient
+}
+
+// KeyAgreeRecipientInfos gets the KeyAgreeRecipientInfos, skipping other types
+// of RecipientInfo.
+func (ris RecipientInfos) KeyAgreeRecipientInfos() ([]KeyAgreeRecipientInfo, error) {
+	var karis []KeyAgreeRecipientInfo
+	for _, ri := range ris {
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 1 {
+			continue
+		}
+
+		var kari KeyAgreeRecipientInfo
+		if rest, err := asn1.UnmarshalWithParams(ri.FullBytes, &kari, "tag:1"); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		karis = append(karis, kari)
+	}
+
+	return karis, nil
+}
+
+// FindKeyAgreeRecipientInfo finds the KeyAgreeRecipientInfo with a
+// RecipientEncryptedKey for cert. ErrNoRecipient is returned if there isn't
+// one.
+func (ris RecipientInfos) FindKeyAgreeRecipientInfo(cert *x509.Certificate) (KeyAgreeRecipientInfo, error) {
+	karis, err := ris.KeyAgreeRecipientInfos()
+	if err != nil {
+		return KeyAgreeRecipientInfo{}, err
+	}
+
+	for _, kari := range karis {
+		if kari.MatchesCertificate(cert) {
+			return kari, nil
+		}
+	}
+
+	return KeyAgreeRecipientInfo{}, ErrNoRecipient
+}
+
+// KEKRecipientInfos gets the KEKRecipientInfos, skipping other types of
+// RecipientInfo.
+func (ris RecipientInfos) KEKRecipientInfos() ([]KEKRecipientInfo, error) {
+	var kekris []KEKRecipientInfo
+	for _, ri := range ris {
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 2 {
+			continue
+		}
+
+		var kekri KEKRecipientInfo
+		if rest, err := asn1.UnmarshalWithParams(ri.FullBytes, &kekri, "tag:2"); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		kekris = append(kekris, kekri)
+	}
+
+	return kekris, nil
+}
+
+// FindKEKRecipientInfo finds the KEKRecipientInfo for the key-encryption key
+// identified by keyID. ErrNoRecipient is returned if there isn't one.
+func (ris RecipientInfos) FindKEKRecipientInfo(keyID []byte) (KEKRecipientInfo, error) {
+	kekris, err := ris.KEKRecipientInfos()
+	if err != nil {
+		return KEKRecipientInfo{}, err
+	}
+
+	for _, kekri := range kekris {
+		if bytes.Equal(kekri.KEKID.KeyIdentifier, keyID) {
+			return kekri, nil
+		}
+	}
+
+	return KEKRecipientInfo{}, ErrNoRecipient
+}
+
+// PasswordRecipientInfos gets the PasswordRecipientInfos, skipping other types
+// of RecipientInfo.
+func (ris RecipientInfos) PasswordRecipientInfos() ([]PasswordRecipientInfo, error) {
+	var pwris []PasswordRecipientInfo
+	for _, ri := range ris {
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 3 {
+			continue
+		}
+
+		var pwri PasswordRecipientInfo
+		if rest, err := asn1.UnmarshalWithParams(ri.FullBytes, &pwri, "tag:3"); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		pwris = append(pwris, pwri)
+	}
+
+	return pwris, nil
+}
+
+// KEMRecipientInfos gets the KEMRecipientInfos, skipping other types of
+// RecipientInfo.
+func (ris RecipientInfos) KEMRecipientInfos() ([]KEMRecipientInfo, error) {
+	var kemris []KEMRecipientInfo
+	for _, ri := range ris {
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 4 {
+			continue
+		}
+
+		var ori OtherRecipientInfo
+		if rest, err := asn1.UnmarshalWithParams(ri.FullBytes, &ori, "tag:4"); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		if !ori.ORIType.Equal(oid.OtherRecipientInfoTypeKEM) {
+			continue
+		}
+
+		var kemri KEMRecipientInfo
+		if rest, err := asn1.Unmarshal(ori.ORIValue.FullBytes, &kemri); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		kemris = append(kemris, kemri)
+	}
+
+	return kemris, nil
+}
+
+// FindKEMRecipientInfo finds the KEMRecipientInfo for cert. ErrNoRecipient is
+// returned if there isn't one.
+func (ris RecipientInfos) FindKEMRecipientInfo(cert *x509.Certificate) (KEMRecipientInfo, error) {
+	kemris, err := ris.KEMRecipientInfos()
+	if err != nil {
+		return KEMRecipientInfo{}, err
+	}
+
+	for _, kemri := range kemris {
+		if kemri.MatchesCertificate(cert) {
+			return kemri, nil
+		}
+	}
+
+	return KEMRecipientInfo{}, ErrNoRecipient
+}
@@ -0,0 +1,163 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// RSAESOAEPParams ::= SEQUENCE {
+//   hashFunc [0] AlgorithmIdentifier DEFAULT sha1Identifier,
+//   maskGenFunc [1] AlgorithmIdentifier DEFAULT mgf1SHA1Identifier,
+//   pSourceFunc [2] AlgorithmIdentifier DEFAULT
+//                      pSpecifiedEmptyIdentifier }
+//
+// pSpecifiedEmptyIdentifier AlgorithmIdentifier ::=
+//   { id-pSpecified, nullOctetString }
+type RSAESOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+// NewRSAESOAEPAlgorithmIdentifier creates an id-RSAES-OAEP
+// AlgorithmIdentifier using hash for both OAEP and MGF1, and an empty label.
+func NewRSAESOAEPAlgorithmIdentifier(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, ErrUnsupported
+	}
+
+	hashFunc := pkix.AlgorithmIdentifier{
+		Algorithm:  digestOID,
+		Parameters: asn1.NullRawValue,
+	}
+
+	mgfParams, err := asn1.Marshal(hashFunc)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	der, err := asn1.Marshal(RSAESOAEPParams{
+		HashFunc: hashFunc,
+		MaskGenFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oid.MaskGenerationFunctionMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfParams},
+		},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oid.KeyEncryptionAlgorithmRSAOAEP,
+		Parameters: asn1.RawValue{FullBytes: der},
+	}, nil
+}
+
+// ParseRSAESOAEPParams parses the RSAES-OAEP parameters from an id-RSAES-OAEP
+// AlgorithmIdentifier. Absent fields are set to their defaults.
+func ParseRSAESOAEPParams(algo pkix.AlgorithmIdentifier) (RSAESOAEPParams, error) {
+	var params RSAESOAEPParams
+
+	if !algo.Algorithm.Equal(oid.KeyEncryptionAlgorithmRSAOAEP) {
+		return params, ErrWrongType
+	}
+
+	// All the parameters have defaults, so they may be omitted entirely.
+	if len(algo.Parameters.FullBytes) > 0 && !bytes.Equal(algo.Parameters.FullBytes, asn1.NullBytes) {
+		if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+			return params, err
+		} else if len(rest) > 0 {
+			return params, ErrTrailingData
+		}
+	}
+
+	if len(params.HashFunc.Algorithm) == 0 {
+		params.HashFunc = pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA1}
+	}
+
+	if len(params.MaskGenFunc.Algorithm) == 0 {
+		mgfParams, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA1})
+		if err != nil {
+			return params, err
+		}
+
+		params.MaskGenFunc = pkix.AlgorithmIdentifier{
+			Algorithm:  oid.MaskGenerationFunctionMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfParams},
+		}
+	}
+
+	if len(params.PSourceFunc.Algorithm) == 0 {
+		label, err := asn1.Marshal([]byte{})
+		if err != nil {
+			return params, err
+		}
+
+		params.PSourceFunc = pkix.AlgorithmIdentifier{
+			Algorithm:  oid.PSourceAlgorithmSpecified,
+			Parameters: asn1.RawValue{FullBytes: label},
+		}
+	}
+
+	return params, nil
+}
+
+// Hash gets the crypto.Hash identified by the hashFunc field.
+func (params RSAESOAEPParams) Hash() (crypto.Hash, error) {
+	hash := oid.DigestAlgorithmToCryptoHash[params.HashFunc.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// MGF1Hash gets the crypto.Hash used with MGF1 by the maskGenFunc field.
+// ErrUnsupported is returned if the mask generation function isn't MGF1.
+func (params RSAESOAEPParams) MGF1Hash() (crypto.Hash, error) {
+	return mgf1Hash(params.MaskGenFunc)
+}
+
+// Label gets the label from the pSourceFunc field. ErrUnsupported is returned
+// if the source isn't id-pSpecified.
+func (params RSAESOAEPParams) Label() ([]byte, error) {
+	if !params.PSourceFunc.Algorithm.Equal(oid.PSourceAlgorithmSpecified) {
+		return nil, ErrUnsupported
+	}
+
+	var label []byte
+	if rest, err := asn1.Unmarshal(params.PSourceFunc.Parameters.FullBytes, &label); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return label, nil
+}
+
+// OAEPOptions validates the parameters and converts them to the
+// rsa.OAEPOptions needed to decrypt a key.
+func (params RSAESOAEPParams) OAEPOptions() (*rsa.OAEPOptions, error) {
+	hash, err := params.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	mgfHash, err := params.MGF1Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	label, err := params.Label()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.OAEPOptions{Hash: hash, MGFHash: mgfHash, Label: label}, nil
+}
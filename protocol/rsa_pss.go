@@ -0,0 +1,215 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/ietf-cms/oid"
+)
+
+// RSASSAPSSParams ::= SEQUENCE {
+//   hashAlgorithm [0] HashAlgorithm DEFAULT sha1,
+//   maskGenAlgorithm [1] MaskGenAlgorithm DEFAULT mgf1SHA1,
+//   saltLength [2] INTEGER DEFAULT 20,
+//   trailerField [3] TrailerField DEFAULT trailerFieldBC }
+//
+// HashAlgorithm ::= AlgorithmIdentifier
+//
+// MaskGenAlgorithm ::= AlgorithmIdentifier
+//
+// TrailerField ::= INTEGER { trailerFieldBC(1) }
+type RSASSAPSSParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SaltLength       int                      `asn1:"optional,explicit,tag:2,default:20"`
+	TrailerField     int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// NewRSASSAPSSParams creates RSASSA-PSS parameters using hash for both the
+// message hash and MGF1, and a salt as long as the hash output.
+func NewRSASSAPSSParams(hash crypto.Hash) (RSASSAPSSParams, error) {
+	digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+	if !ok {
+		return RSASSAPSSParams{}, ErrUnsupported
+	}
+
+	hashAlgorithm := pkix.AlgorithmIdentifier{
+		Algorithm:  digestOID,
+		Parameters: asn1.NullRawValue,
+	}
+
+	mgfParams, err := asn1.Marshal(hashAlgorithm)
+	if err != nil {
+		return RSASSAPSSParams{}, err
+	}
+
+	return RSASSAPSSParams{
+		HashAlgorithm: hashAlgorithm,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oid.MaskGenerationFunctionMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfParams},
+		},
+		SaltLength:   hash.Size(),
+		TrailerField: 1,
+	}, nil
+}
+
+// NewRSASSAPSSAlgorithmIdentifier creates an id-RSASSA-PSS
+// AlgorithmIdentifier with parameters for the given hash.
+func NewRSASSAPSSAlgorithmIdentifier(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	params, err := NewRSASSAPSSParams(hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oid.SignatureAlgorithmRSAPSS,
+		Parameters: asn1.RawValue{FullBytes: der},
+	}, nil
+}
+
+// ParseRSASSAPSSParams parses the RSASSA-PSS parameters from an
+// id-RSASSA-PSS AlgorithmIdentifier. Absent fields are set to their defaults.
+func ParseRSASSAPSSParams(algo pkix.AlgorithmIdentifier) (RSASSAPSSParams, error) {
+	var params RSASSAPSSParams
+
+	if !algo.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		return params, ErrWrongType
+	}
+
+	// The parameters are required for id-RSASSA-PSS in CMS (RFC4056).
+	if len(algo.Parameters.FullBytes) == 0 {
+		return params, ASN1Error{"missing RSASSA-PSS parameters"}
+	}
+
+	if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return params, err
+	} else if len(rest) > 0 {
+		return params, ErrTrailingData
+	}
+
+	if len(params.HashAlgorithm.Algorithm) == 0 {
+		params.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA1}
+	}
+
+	if len(params.MaskGenAlgorithm.Algorithm) == 0 {
+		mgfParams, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA1})
+		if err != nil {
+			return params, err
+		}
+
+		params.MaskGenAlgorithm = pkix.AlgorithmIdentifier{
+			Algorithm:  oid.MaskGenerationFunctionMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfParams},
+		}
+	}
+
+	return params, nil
+}
+
+// Hash gets the crypto.Hash identified by the hashAlgorithm field.
+func (params RSASSAPSSParams) Hash() (crypto.Hash, error) {
+	hash := oid.DigestAlgorithmToCryptoHash[params.HashAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// MGF1Hash gets the crypto.Hash used with MGF1 by the maskGenAlgorithm field.
+// ErrUnsupported is returned if the mask generation function isn't MGF1.
+func (params RSASSAPSSParams) MGF1Hash() (crypto.Hash, error) {
+	return mgf1Hash(params.MaskGenAlgorithm)
+}
+
+// mgf1Hash gets the crypto.Hash used by an MGF1 mask generation function
+// AlgorithmIdentifier. ErrUnsupported is returned if it isn't MGF1.
+func mgf1Hash(maskGenAlgorithm pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	if !maskGenAlgorithm.Algorithm.Equal(oid.MaskGenerationFunctionMGF1) {
+		return 0, ErrUnsupported
+	}
+
+	var mgfHash pkix.AlgorithmIdentifier
+	if rest, err := asn1.Unmarshal(maskGenAlgorithm.Parameters.FullBytes, &mgfHash); err != nil {
+		return 0, err
+	} else if len(rest) > 0 {
+		return 0, ErrTrailingData
+	}
+
+	hash := oid.DigestAlgorithmToCryptoHash[mgfHash.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// PSSOptions validates the parameters and converts them to the hash and
+// rsa.PSSOptions needed to create or verify a signature. Go's rsa package
+// always uses the message hash for MGF1 and the 0xBC trailer, so parameters
+// using anything else are unsupported. A zero salt length is also unsupported,
+// since rsa.PSSOptions uses zero to mean any salt length when verifying and
+// the maximum length when signing.
+func (params RSASSAPSSParams) PSSOptions() (crypto.Hash, *rsa.PSSOptions, error) {
+	hash, err := params.Hash()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mgfHash, err := params.MGF1Hash()
+	if err != nil {
+		return 0, nil, err
+	}
+	if mgfHash != hash {
+		return 0, nil, ErrUnsupported
+	}
+
+	if params.TrailerField != 1 {
+		return 0, nil, ErrUnsupported
+	}
+
+	if params.SaltLength < 0 {
+		return 0, nil, ASN1Error{"negative RSASSA-PSS salt length"}
+	}
+	if params.SaltLength == 0 {
+		return 0, nil, ErrUnsupported
+	}
+
+	return hash, &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: hash}, nil
+}
+
+// checkRSAPSSSignature checks an RSASSA-PSS signature over signed using the
+// parameters from algo.
+func checkRSAPSSSignature(cert *x509.Certificate, algo pkix.AlgorithmIdentifier, signed, signature []byte) error {
+	params, err := ParseRSASSAPSSParams(algo)
+	if err != nil {
+		return err
+	}
+
+	hash, opts, err := params.PSSOptions()
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("RSASSA-PSS signature with non-RSA certificate")
+	}
+
+	h := hash.New()
+	if _, err = h.Write(signed); err != nil {
+		return err
+	}
+
+	return rsa.VerifyPSS(pub, hash, h.Sum(nil), signature, opts)
+}
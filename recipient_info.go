@@ -0,0 +1,180 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// KeyEncryptionKey is a previously distributed AES key used to encrypt the
+// content-encryption key for KEKRecipientInfos.
+type KeyEncryptionKey struct {
+	// ID identifies the key to recipients.
+	ID []byte
+
+	// Key is a 16, 24 or 32 byte AES key.
+	Key []byte
+}
+
+// addRecipientInfos gives the content-encryption or MAC key to each of the
+// recipients and to the passwords and key-encryption keys.
+func addRecipientInfos(ris *protocol.RecipientInfos, recipients []*x509.Certificate, passwords [][]byte, keks []KeyEncryptionKey, key []byte, opts protocol.RecipientInfoOptions) error {
+	if len(recipients) == 0 && len(passwords) == 0 && len(keks) == 0 {
+		return errors.New("no recipients")
+	}
+
+	for _, cert := range recipients {
+		if err := addRecipient(ris, cert, key, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, password := range passwords {
+		pwri, err := protocol.NewPasswordRecipientInfo(password, key, opts)
+		if err != nil {
+			return err
+		}
+
+		if err = ris.AddPasswordRecipientInfo(pwri); err != nil {
+			return err
+		}
+	}
+
+	for _, kek := range keks {
+		kekri, err := protocol.NewKEKRecipientInfo(kek.ID, kek.Key, key)
+		if err != nil {
+			return err
+		}
+
+		if err = ris.AddKEKRecipientInfo(kekri); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addRecipient adds a RecipientInfo for cert, using key transport for RSA keys,
+// key encapsulation for ML-KEM keys and key agreement for EC and X25519 keys.
+func addRecipient(ris *protocol.RecipientInfos, cert *x509.Certificate, key []byte, opts protocol.RecipientInfoOptions) error {
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		ktri, err := protocol.NewKeyTransRecipientInfo(cert, key, opts)
+		if err != nil {
+			return err
+		}
+
+		return ris.AddKeyTransRecipientInfo(ktri)
+	}
+
+	if protocol.IsKEMCertificate(cert) {
+		kemri, err := protocol.NewKEMRecipientInfo(cert, key, opts)
+		if err != nil {
+			return err
+		}
+
+		return ris.AddKEMRecipientInfo(kemri)
+	}
+
+	kari, err := protocol.NewKeyAgreeRecipientInfo(cert, key, opts)
+	if err != nil {
+		return err
+	}
+
+	return ris.AddKeyAgreeRecipientInfo(kari)
+}
+
+// decryptKey decrypts the content-encryption or MAC key from the RecipientInfo
+// for cert. keySize is the expected size of the key.
+func decryptKey(ris protocol.RecipientInfos, keySize int, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	ktri, err := ris.FindKeyTransRecipientInfo(cert)
+	if err == nil {
+		decrypter, ok := key.(crypto.Decrypter)
+		if !ok {
+			return nil, errors.New("key transport requires a crypto.Decrypter")
+		}
+
+		return ktri.DecryptKey(decrypter, keySize)
+	} else if !errors.Is(err, protocol.ErrNoRecipient) {
+		return nil, err
+	}
+
+	kari, err := ris.FindKeyAgreeRecipientInfo(cert)
+	if err == nil {
+		var ecdhKey protocol.ECDHPrivateKey
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			if ecdhKey, err = k.ECDH(); err != nil {
+				return nil, err
+			}
+		case protocol.ECDHPrivateKey:
+			ecdhKey = k
+		default:
+			return nil, errors.New("key agreement requires an EC or X25519 key")
+		}
+
+		return kari.DecryptKey(cert, ecdhKey)
+	} else if !errors.Is(err, protocol.ErrNoRecipient) {
+		return nil, err
+	}
+
+	kemri, err := ris.FindKEMRecipientInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	kemKey, ok := key.(protocol.KEMPrivateKey)
+	if !ok {
+		return nil, errors.New("key encapsulation requires an ML-KEM key")
+	}
+
+	return kemri.DecryptKey(kemKey)
+}
+
+// decryptWithPassword decrypts the content-encryption or MAC key from each
+// PasswordRecipientInfo and tries it with use until one works.
+func decryptWithPassword(ris protocol.RecipientInfos, password []byte, use func(key []byte) ([]byte, error)) ([]byte, error) {
+	pwris, err := ris.PasswordRecipientInfos()
+	if err != nil {
+		return nil, err
+	}
+	if len(pwris) == 0 {
+		return nil, protocol.ErrNoRecipient
+	}
+
+	// PasswordRecipientInfos don't identify the password, so we try each.
+	err = protocol.ErrDecryption
+	for _, pwri := range pwris {
+		key, keyErr := pwri.DecryptKey(password)
+		if errors.Is(keyErr, protocol.ErrDecryption) {
+			continue
+		} else if keyErr != nil {
+			return nil, keyErr
+		}
+
+		// The check bytes match for one in 2^24 wrong passwords, so we keep
+		// trying if the key doesn't work.
+		var content []byte
+		if content, err = use(key); err == nil {
+			return content, nil
+		} else if !errors.Is(err, protocol.ErrDecryption) && !errors.Is(err, protocol.ErrMACVerification) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// decryptWithKeyEncryptionKey unwraps the content-encryption or MAC key from
+// the KEKRecipientInfo for kek.
+func decryptWithKeyEncryptionKey(ris protocol.RecipientInfos, kek KeyEncryptionKey) ([]byte, error) {
+	kekri, err := ris.FindKEKRecipientInfo(kek.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return kekri.DecryptKey(kek.Key)
+}
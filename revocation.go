@@ -0,0 +1,212 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/github/ietf-cms/timestamp"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	contentTypeOCSPRequest = "application/ocsp-request"
+
+	// maxRevocationResponseSize is the largest CRL or OCSP response that will be
+	// read from a server.
+	maxRevocationResponseSize = 16 << 20
+)
+
+// RevocationChecker checks the revocation status of certificates.
+type RevocationChecker interface {
+	// IsRevoked checks if cert, which was issued by issuer, had been revoked at
+	// time t. An error is returned if the status couldn't be determined.
+	IsRevoked(cert, issuer *x509.Certificate, t time.Time) (bool, error)
+}
+
+// RevocationCheckers is a RevocationChecker that tries each of its checkers in
+// turn, returning the first status that one of them can determine.
+type RevocationCheckers []RevocationChecker
+
+// IsRevoked implements the RevocationChecker interface.
+func (rcs RevocationCheckers) IsRevoked(cert, issuer *x509.Certificate, t time.Time) (bool, error) {
+	err := errors.New("no revocation checkers")
+	for _, rc := range rcs {
+		var revoked bool
+		if revoked, err = rc.IsRevoked(cert, issuer, t); err == nil {
+			return revoked, nil
+		}
+	}
+
+	return false, err
+}
+
+// CRLChecker is a RevocationChecker that fetches CRLs from the HTTP URLs in
+// certificates' CRL distribution points.
+type CRLChecker struct {
+	// HTTPClient is used to fetch CRLs. http.DefaultClient is used if it's nil.
+	HTTPClient timestamp.HTTPClient
+}
+
+// IsRevoked implements the RevocationChecker interface. The first CRL that
+// can be fetched, is signed by issuer and hadn't expired at t is used.
+func (c CRLChecker) IsRevoked(cert, issuer *x509.Certificate, t time.Time) (bool, error) {
+	err := fmt.Errorf("no CRL distribution points for certificate %s", cert.Subject)
+
+	for _, url := range cert.CRLDistributionPoints {
+		if !isHTTPURL(url) {
+			continue
+		}
+
+		var crl *x509.RevocationList
+		if crl, err = c.fetch(url, issuer, t); err != nil {
+			continue
+		}
+
+		for _, rc := range crl.RevokedCertificateEntries {
+			if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return !rc.RevocationTime.After(t), nil
+			}
+		}
+
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (c CRLChecker) fetch(url string, issuer *x509.Certificate, t time.Time) (*x509.RevocationList, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := doHTTPRequest(c.HTTPClient, httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+		return nil, errors.New("CRL issuer doesn't match certificate issuer")
+	}
+	if err = crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, err
+	}
+	if !crl.NextUpdate.IsZero() && t.After(crl.NextUpdate) {
+		return nil, errors.New("CRL has expired")
+	}
+
+	return crl, nil
+}
+
+// OCSPChecker is a RevocationChecker that queries the OCSP responders listed
+// in certificates' authority information access extension.
+type OCSPChecker struct {
+	// HTTPClient is used to query OCSP responders. http.DefaultClient is used if
+	// it's nil.
+	HTTPClient timestamp.HTTPClient
+}
+
+// IsRevoked implements the RevocationChecker interface. The first response
+// that hadn't expired at t, is signed by issuer, or a responder it delegated
+// to, and has a known status is used.
+func (c OCSPChecker) IsRevoked(cert, issuer *x509.Certificate, t time.Time) (bool, error) {
+	err := fmt.Errorf("no OCSP servers for certificate %s", cert.Subject)
+
+	for _, url := range cert.OCSPServer {
+		if !isHTTPURL(url) {
+			continue
+		}
+
+		var resp *ocsp.Response
+		if resp, err = c.query(url, cert, issuer, t); err != nil {
+			continue
+		}
+
+		switch resp.Status {
+		case ocsp.Good:
+			return false, nil
+		case ocsp.Revoked:
+			return !resp.RevokedAt.After(t), nil
+		default:
+			err = fmt.Errorf("unknown OCSP status for certificate %s", cert.Subject)
+		}
+	}
+
+	return false, err
+}
+
+func (c OCSPChecker) query(url string, cert, issuer *x509.Certificate, t time.Time) (*ocsp.Response, error) {
+	reqDER, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Add("Content-Type", contentTypeOCSPRequest)
+
+	der, err := doHTTPRequest(c.HTTPClient, httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Certificate != nil && !resp.Certificate.Equal(issuer) && !isOCSPResponder(resp.Certificate) {
+		return nil, errors.New("OCSP responder isn't authorized")
+	}
+	if !resp.NextUpdate.IsZero() && t.After(resp.NextUpdate) {
+		return nil, errors.New("OCSP response has expired")
+	}
+
+	return resp, nil
+}
+
+// doHTTPRequest makes the request using client, or http.DefaultClient if it's
+// nil, returning the response body. An error is returned if the body is larger
+// than maxRevocationResponseSize.
+func doHTTPRequest(client timestamp.HTTPClient, httpReq *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad HTTP status from %s: %d", httpReq.URL, httpResp.StatusCode)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, io.LimitReader(httpResp.Body, maxRevocationResponseSize+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxRevocationResponseSize {
+		return nil, fmt.Errorf("response from %s is larger than %d bytes", httpReq.URL, maxRevocationResponseSize)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
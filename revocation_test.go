@@ -0,0 +1,274 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/fakeca"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	testCRLURL  = "http://crl.example.com/intermediate.crl"
+	testOCSPURL = "http://ocsp.example.com"
+)
+
+func TestVerifyWithRevocation(t *testing.T) {
+	revocable := issueRevocableLeaf(t)
+	client := &testRevocationHTTPClient{t: t, issuer: crlIntermediate, serial: revocable.Certificate.SerialNumber}
+	crlChecker := CRLChecker{HTTPClient: client}
+	ocspChecker := OCSPChecker{HTTPClient: client}
+
+	ber, err := Sign([]byte("hi"), revocable.Chain(), revocable.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := ParseSignedData(ber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// good
+	for _, rc := range []RevocationChecker{crlChecker, ocspChecker} {
+		if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the intermediate has no CRL distribution points or OCSP servers
+	if _, err = sd.VerifyWithRevocation(crlRootOpts, crlChecker); err == nil {
+		t.Fatal("expected error for intermediate without revocation info")
+	}
+	if _, err = sd.VerifyWithOptions(VerifyOptions{
+		VerifyOptions:      crlRootOpts,
+		RevocationChecker:  crlChecker,
+		RevocationSoftFail: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked
+	client.revokedAt = time.Now().Add(-20 * time.Minute)
+	for _, rc := range []RevocationChecker{crlChecker, ocspChecker} {
+		if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, rc); err == nil {
+			t.Fatal("expected error for revoked certificate")
+		}
+
+		// revoked after the signing time
+		opts := VerifyOptions{VerifyOptions: crlIntermediateOpts, RevocationChecker: rc}
+		opts.CurrentTime = time.Now().Add(-30 * time.Minute)
+		if _, err = sd.VerifyWithOptions(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		opts.RevocationCheckNow = true
+		if _, err = sd.VerifyWithOptions(opts); err == nil {
+			t.Fatal("expected error for certificate revoked now")
+		}
+	}
+	client.revokedAt = time.Time{}
+
+	// expired since the signing time
+	client.nextUpdate = time.Now().Add(-10 * time.Minute)
+	for _, rc := range []RevocationChecker{crlChecker, ocspChecker} {
+		if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, rc); err == nil {
+			t.Fatal("expected error for expired revocation data")
+		}
+
+		opts := VerifyOptions{VerifyOptions: crlIntermediateOpts, RevocationChecker: rc}
+		opts.CurrentTime = time.Now().Add(-30 * time.Minute)
+		if _, err = sd.VerifyWithOptions(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.nextUpdate = time.Time{}
+
+	// unavailable
+	client.down = true
+	if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, ocspChecker); err == nil {
+		t.Fatal("expected error for unavailable OCSP responder")
+	}
+	if _, err = sd.VerifyWithOptions(VerifyOptions{
+		VerifyOptions:      crlIntermediateOpts,
+		RevocationChecker:  ocspChecker,
+		RevocationSoftFail: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client.down = false
+
+	// falling back from OCSP to CRLs
+	client.ocspDown = true
+	if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, RevocationCheckers{ocspChecker, crlChecker}); err != nil {
+		t.Fatal(err)
+	}
+	client.revokedAt = time.Now().Add(-20 * time.Minute)
+	if _, err = sd.VerifyWithRevocation(crlIntermediateOpts, RevocationCheckers{ocspChecker, crlChecker}); err == nil {
+		t.Fatal("expected error for revoked certificate")
+	}
+	client.revokedAt = time.Time{}
+	client.ocspDown = false
+
+	// embedded revocation data takes precedence
+	if err = sd.AddOCSPResponse(createOCSPResponseFrom(t, crlIntermediate, crlIntermediate, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: revocable.Certificate.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-20 * time.Minute),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyWithOptions(VerifyOptions{
+		VerifyOptions:     crlIntermediateOpts,
+		CheckEmbeddedOCSP: true,
+		RevocationChecker: ocspChecker,
+	}); err == nil {
+		t.Fatal("expected error for embedded revoked status")
+	}
+}
+
+func TestDoHTTPRequestSizeLimit(t *testing.T) {
+	httpReq, err := http.NewRequest(http.MethodGet, testCRLURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := doHTTPRequest(staticHTTPClient(make([]byte, maxRevocationResponseSize)), httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != maxRevocationResponseSize {
+		t.Fatalf("expected %d bytes, got %d", maxRevocationResponseSize, len(body))
+	}
+
+	if _, err = doHTTPRequest(staticHTTPClient(make([]byte, maxRevocationResponseSize+1)), httpReq); err == nil {
+		t.Fatal("expected error for oversized response")
+	}
+}
+
+// staticHTTPClient responds to every request with its contents.
+type staticHTTPClient []byte
+
+func (c staticHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(c))}, nil
+}
+
+// issueRevocableLeaf issues a leaf certificate from crlIntermediate with a CRL
+// distribution point and OCSP server.
+func issueRevocableLeaf(t *testing.T) *fakeca.Identity {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "revocable leaf"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		CRLDistributionPoints: []string{"ldap://crl.example.com", testCRLURL},
+		OCSPServer:            []string{testOCSPURL},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, crlIntermediate.Certificate, key.Public(), crlIntermediate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeca.Identity{Issuer: crlIntermediate, PrivateKey: key, Certificate: cert}
+}
+
+// testRevocationHTTPClient serves a CRL and OCSP responses from issuer. The
+// certificate with the given serial number is revoked at revokedAt if it's set.
+// The responses expire at nextUpdate if it's set, or in an hour otherwise.
+type testRevocationHTTPClient struct {
+	t          *testing.T
+	issuer     *fakeca.Identity
+	serial     *big.Int
+	revokedAt  time.Time
+	nextUpdate time.Time
+	down       bool
+	ocspDown   bool
+}
+
+func (c *testRevocationHTTPClient) Do(httpReq *http.Request) (*http.Response, error) {
+	if c.down || (c.ocspDown && httpReq.URL.String() == testOCSPURL) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	nextUpdate := c.nextUpdate
+	if nextUpdate.IsZero() {
+		nextUpdate = time.Now().Add(time.Hour)
+	}
+
+	var der []byte
+
+	switch httpReq.URL.String() {
+	case testCRLURL:
+		var revoked []x509.RevocationListEntry
+		if !c.revokedAt.IsZero() {
+			revoked = append(revoked, x509.RevocationListEntry{
+				SerialNumber:   c.serial,
+				RevocationTime: c.revokedAt,
+			})
+		}
+
+		var err error
+		if der, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(time.Now().UnixNano()),
+			ThisUpdate:                time.Now().Add(-time.Hour),
+			NextUpdate:                nextUpdate,
+			RevokedCertificateEntries: revoked,
+		}, c.issuer.Certificate, c.issuer.PrivateKey); err != nil {
+			return nil, err
+		}
+	case testOCSPURL:
+		buf := new(bytes.Buffer)
+		if _, err := io.Copy(buf, httpReq.Body); err != nil {
+			return nil, err
+		}
+
+		req, err := ocsp.ParseRequest(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   nextUpdate,
+		}
+		if !c.revokedAt.IsZero() && req.SerialNumber.Cmp(c.serial) == 0 {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = c.revokedAt
+		}
+
+		der = createOCSPResponseFrom(c.t, c.issuer, c.issuer, template)
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(der)),
+	}, nil
+}
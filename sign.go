@@ -3,19 +3,34 @@ package cms
 import (
 	"crypto"
 	"crypto/x509"
+	"io"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
 )
 
+// SignOptions customizes how signatures are made. The zero value uses the
+// default algorithms for the signer's key and includes a signing-time
+// attribute. See protocol.SignerInfoOptions for details on each field.
+type SignOptions protocol.SignerInfoOptions
+
 // Sign creates a CMS SignedData from the content and signs it with signer. At
 // minimum, chain must contain the leaf certificate associated with the signer.
 // Any additional intermediates will also be added to the SignedData. The DER
 // encoded CMS message is returned.
 func Sign(data []byte, chain []*x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	return SignWithOptions(data, chain, signer, SignOptions{})
+}
+
+// SignWithOptions is like Sign, but allows the caller to customize the
+// signature with opts.
+func SignWithOptions(data []byte, chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) ([]byte, error) {
 	sd, err := NewSignedData(data)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = sd.Sign(chain, signer); err != nil {
+	if err = sd.SignWithOptions(chain, signer, opts); err != nil {
 		return nil, err
 	}
 
@@ -27,12 +42,18 @@ func Sign(data []byte, chain []*x509.Certificate, signer crypto.Signer) ([]byte,
 // with the signer. Any additional intermediates will also be added to the
 // SignedData. The DER encoded CMS message is returned.
 func SignDetached(data []byte, chain []*x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	return SignDetachedWithOptions(data, chain, signer, SignOptions{})
+}
+
+// SignDetachedWithOptions is like SignDetached, but allows the caller to
+// customize the signature with opts.
+func SignDetachedWithOptions(data []byte, chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) ([]byte, error) {
 	sd, err := NewSignedData(data)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = sd.Sign(chain, signer); err != nil {
+	if err = sd.SignWithOptions(chain, signer, opts); err != nil {
 		return nil, err
 	}
 
@@ -41,9 +62,109 @@ func SignDetached(data []byte, chain []*x509.Certificate, signer crypto.Signer)
 	return sd.ToDER()
 }
 
+// SignDetachedReader creates a detached CMS SignedData over the content read
+// from r and signs it with signer. The content is digested as it is read and
+// is never held in memory. At minimum, chain must contain the leaf certificate
+// associated with the signer. Any additional intermediates will also be added
+// to the SignedData. The DER encoded CMS message is returned.
+func SignDetachedReader(r io.Reader, chain []*x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	return SignDetachedReaderWithOptions(r, chain, signer, SignOptions{})
+}
+
+// SignDetachedReaderWithOptions is like SignDetachedReader, but allows the
+// caller to customize the signature with opts.
+func SignDetachedReaderWithOptions(r io.Reader, chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) ([]byte, error) {
+	psd, err := protocol.NewSignedData(protocol.EncapsulatedContentInfo{EContentType: oid.ContentTypeData})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = psd.AddDetachedSignerInfo(r, chain, signer, protocol.SignerInfoOptions(opts)); err != nil {
+		return nil, err
+	}
+
+	return psd.ContentInfoDER()
+}
+
 // Sign adds a signature to the SignedData.At minimum, chain must contain the
 // leaf certificate associated with the signer. Any additional intermediates
 // will also be added to the SignedData.
 func (sd *SignedData) Sign(chain []*x509.Certificate, signer crypto.Signer) error {
-	return sd.psd.AddSignerInfo(chain, signer)
+	return sd.SignWithOptions(chain, signer, SignOptions{})
+}
+
+// SignWithOptions adds a signature to the SignedData, using opts to select the
+// digest and signature algorithms and the signed attributes. At minimum, chain
+// must contain the leaf certificate associated with the signer. Any additional
+// intermediates will also be added to the SignedData.
+func (sd *SignedData) SignWithOptions(chain []*x509.Certificate, signer crypto.Signer, opts SignOptions) error {
+	return sd.psd.AddSignerInfoWithOptions(chain, signer, protocol.SignerInfoOptions(opts))
+}
+
+// PreparedSignerInfo is a signature that has been prepared by
+// PrepareSignerInfo and is waiting to be made by an external signer.
+type PreparedSignerInfo struct {
+	psi protocol.PreparedSignerInfo
+}
+
+// PrepareSignerInfo prepares a signature over the SignedData without making
+// it, for use with signers that can't be represented as a crypto.Signer (eg.
+// remote signing services). The first certificate in chain must be the
+// signer's. Any additional intermediates will be added to the SignedData by
+// CompleteSignerInfo.
+func (sd *SignedData) PrepareSignerInfo(chain []*x509.Certificate, opts SignOptions) (*PreparedSignerInfo, error) {
+	psi, err := sd.psd.PrepareSignerInfo(chain, protocol.SignerInfoOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedSignerInfo{psi}, nil
+}
+
+// CompleteSignerInfo adds a signature made over a PreparedSignerInfo's Digest
+// to the SignedData. The signature is checked against the signer's
+// certificate before being added.
+func (sd *SignedData) CompleteSignerInfo(p *PreparedSignerInfo, signature []byte) error {
+	return sd.psd.CompleteSignerInfo(p.psi, signature)
+}
+
+// ParsePreparedSignerInfo parses a PreparedSignerInfo that was serialized with
+// MarshalBinary.
+func ParsePreparedSignerInfo(der []byte) (*PreparedSignerInfo, error) {
+	psi, err := protocol.ParsePreparedSignerInfo(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedSignerInfo{psi}, nil
+}
+
+// MarshalBinary serializes the PreparedSignerInfo so it can be completed by
+// another process.
+func (p *PreparedSignerInfo) MarshalBinary() ([]byte, error) {
+	return p.psi.Marshal()
+}
+
+// Certificate gets the signer's certificate.
+func (p *PreparedSignerInfo) Certificate() (*x509.Certificate, error) {
+	certs, err := p.psi.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+// SignedAttributes gets the DER encoded signed attributes that the signature
+// covers.
+func (p *PreparedSignerInfo) SignedAttributes() ([]byte, error) {
+	return p.psi.SignedMessage()
+}
+
+// Digest gets the digest of the signed attributes and the options that should
+// be used when signing it, as they would be passed to crypto.Signer's Sign
+// method. For Ed25519, which doesn't sign a digest, the signed attributes are
+// returned instead, and opts.HashFunc() is zero.
+func (p *PreparedSignerInfo) Digest() (digest []byte, opts crypto.SignerOpts, err error) {
+	return p.psi.SignatureInput()
 }
@@ -1,13 +1,28 @@
 package cms
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/github/fakeca"
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
 )
 
 var (
@@ -219,3 +234,681 @@ func TestSignRemoveHeaders(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSignWithOptions(t *testing.T) {
+	data := []byte("hello, world!")
+
+	customAttr, err := protocol.NewAttribute(asn1.ObjectIdentifier{1, 2, 3, 4}, "custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		DigestAlgorithm: crypto.SHA512,
+		OmitSigningTime: true,
+		SignedAttrs:     protocol.Attributes{customAttr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.psd.SignerInfos[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(oid.DigestAlgorithmSHA512) {
+		t.Fatalf("expected SHA512 digest, got %s", si.DigestAlgorithm.Algorithm)
+	}
+	if algo := si.X509SignatureAlgorithm(); algo != x509.SHA512WithRSA {
+		t.Fatalf("expected SHA512WithRSA, got %s", algo)
+	}
+	if si.SignedAttrs.HasAttribute(oid.AttributeSigningTime) {
+		t.Fatal("expected no signing-time attribute")
+	}
+	if !si.SignedAttrs.HasAttribute(customAttr.Type) {
+		t.Fatal("expected custom attribute")
+	}
+
+	// Signature algorithm determines the digest.
+	der, err = SignDetachedWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		SignatureAlgorithm: x509.SHA384WithRSA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !sd.psd.SignerInfos[0].DigestAlgorithm.Algorithm.Equal(oid.DigestAlgorithmSHA384) {
+		t.Fatalf("expected SHA384 digest, got %s", sd.psd.SignerInfos[0].DigestAlgorithm.Algorithm)
+	}
+
+	// Signature algorithm doesn't match the key.
+	if _, err = SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+	}); err == nil {
+		t.Fatal("expected error for mismatched signature algorithm")
+	}
+
+	// Signature algorithm doesn't match the digest.
+	if _, err = SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		DigestAlgorithm:    crypto.SHA256,
+		SignatureAlgorithm: x509.SHA512WithRSA,
+	}); err == nil {
+		t.Fatal("expected error for mismatched digest algorithm")
+	}
+
+	// Duplicate of a mandatory attribute.
+	mdAttr, err := protocol.NewAttribute(oid.AttributeMessageDigest, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		SignedAttrs: protocol.Attributes{mdAttr},
+	}); err == nil {
+		t.Fatal("expected error for duplicate attribute")
+	}
+}
+
+func TestSignECDSADefaultDigest(t *testing.T) {
+	// The digest matches the strength of the curve.
+	tests := []struct {
+		curve    elliptic.Curve
+		expected x509.SignatureAlgorithm
+	}{
+		{elliptic.P256(), x509.ECDSAWithSHA256},
+		{elliptic.P384(), x509.ECDSAWithSHA384},
+		{elliptic.P521(), x509.ECDSAWithSHA512},
+	}
+
+	for _, test := range tests {
+		priv, err := ecdsa.GenerateKey(test.curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ident := intermediate.Issue(fakeca.PrivateKey(priv))
+
+		der, err := Sign([]byte("hello, world!"), ident.Chain(), ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := ParseSignedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = sd.Verify(rootOpts); err != nil {
+			t.Fatal(err)
+		}
+		if algo := sd.psd.SignerInfos[0].X509SignatureAlgorithm(); algo != test.expected {
+			t.Fatalf("expected %s for %s, got %s", test.expected, test.curve.Params().Name, algo)
+		}
+	}
+}
+
+func TestSignRSAPSS(t *testing.T) {
+	data := []byte("hello, world!")
+
+	for _, algo := range []x509.SignatureAlgorithm{x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS} {
+		der, err := SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{SignatureAlgorithm: algo})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd, err := ParseSignedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = sd.Verify(rootOpts); err != nil {
+			t.Fatal(err)
+		}
+
+		si := sd.psd.SignerInfos[0]
+		if !si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+			t.Fatalf("expected id-RSASSA-PSS, got %s", si.SignatureAlgorithm.Algorithm)
+		}
+		if actual := si.X509SignatureAlgorithm(); actual != algo {
+			t.Fatalf("expected %s, got %s", algo, actual)
+		}
+
+		params, err := protocol.ParseRSASSAPSSParams(si.SignatureAlgorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, _, err := params.PSSOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params.SaltLength != hash.Size() {
+			t.Fatalf("expected salt length %d, got %d", hash.Size(), params.SaltLength)
+		}
+	}
+
+	// PSS with a non-RSA key.
+	ecIdent := intermediate.Issue(fakeca.PrivateKey(intermediateKey))
+	if _, err := SignWithOptions(data, ecIdent.Chain(), ecIdent.PrivateKey, SignOptions{SignatureAlgorithm: x509.SHA256WithRSAPSS}); err == nil {
+		t.Fatal("expected error for PSS with ECDSA key")
+	}
+}
+
+func TestVerifyRSAPSSCustomSaltLength(t *testing.T) {
+	sd, err := NewSignedData([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{SignatureAlgorithm: x509.SHA256WithRSAPSS}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-sign with a 20 byte salt rather than one the size of the hash.
+	si := &sd.psd.SignerInfos[0]
+	params, err := protocol.ParseRSASSAPSSParams(si.SignatureAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSaltLength := func(sd *SignedData, saltLength int) {
+		params.SaltLength = saltLength
+		paramsDER, err := asn1.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd.psd.SignerInfos[0].SignatureAlgorithm.Parameters = asn1.RawValue{FullBytes: paramsDER}
+	}
+	setSaltLength(sd, 20)
+
+	sm, err := si.SignedAttrs.MarshaledForSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(sm)
+	opts := &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}
+	if si.Signature, err = rsa.SignPSS(rand.Reader, leaf.PrivateKey.(*rsa.PrivateKey), crypto.SHA256, digest[:], opts); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Salt length in parameters doesn't match signature.
+	setSaltLength(sd, 32)
+	var sigErr *SignatureError
+	if _, err = sd.Verify(rootOpts); !errors.As(err, &sigErr) || !errors.Is(err, rsa.ErrVerification) {
+		t.Fatalf("expected %v, got %v", rsa.ErrVerification, err)
+	}
+
+	// Go can't enforce a zero length salt, so the signature would be accepted
+	// whatever its salt length.
+	setSaltLength(sd, 0)
+	if _, err = sd.Verify(rootOpts); !errors.As(err, &sigErr) || !errors.Is(err, protocol.ErrUnsupported) {
+		t.Fatalf("expected %v, got %v", protocol.ErrUnsupported, err)
+	}
+}
+
+func TestSignRSAPSSWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	content := []byte("hello, world!")
+
+	signatureDER, err := SignDetachedWithOptions(content, leaf.Chain(), leaf.PrivateKey, SignOptions{SignatureAlgorithm: x509.SHA256WithRSAPSS})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "TestSignRSAPSSWithOpenSSL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signatureFile := filepath.Join(dir, "signature.der")
+	if err = ioutil.WriteFile(signatureFile, signatureDER, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	contentFile := filepath.Join(dir, "content")
+	if err = ioutil.WriteFile(contentFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var certsPEM []byte
+	for _, cert := range leaf.Chain() {
+		certsPEM = append(certsPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	certsFile := filepath.Join(dir, "certs.pem")
+	if err = ioutil.WriteFile(certsFile, certsPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(opensslPath, "cms", "-verify",
+		"-content", contentFile, "-binary",
+		"-in", signatureFile, "-inform", "DER",
+		"-CAfile", certsFile)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident := intermediate.Issue(fakeca.PrivateKey(priv))
+	data := []byte("hello, world!")
+
+	der, err := SignDetached(data, ident.Chain(), ident.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.psd.SignerInfos[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(oid.DigestAlgorithmSHA512) {
+		t.Fatalf("expected SHA512 digest, got %s", si.DigestAlgorithm.Algorithm)
+	}
+	if !si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmEd25519) {
+		t.Fatalf("expected id-Ed25519, got %s", si.SignatureAlgorithm.Algorithm)
+	}
+	if len(si.SignatureAlgorithm.Parameters.FullBytes) != 0 {
+		t.Fatal("expected absent signature algorithm parameters")
+	}
+
+	// RFC8419 requires SHA-512 with Ed25519.
+	if _, err = SignWithOptions(data, ident.Chain(), ident.PrivateKey, SignOptions{DigestAlgorithm: crypto.SHA256}); err == nil {
+		t.Fatal("expected error for Ed25519 with SHA256")
+	}
+
+	// Tampered message.
+	if _, err = sd.VerifyDetached([]byte("hello, world?"), rootOpts); err == nil {
+		t.Fatal("expected error for tampered message")
+	}
+
+	// Tampered signature.
+	sd.psd.SignerInfos[0].Signature[0] ^= 0xFF
+	if _, err = sd.VerifyDetached(data, rootOpts); err == nil {
+		t.Fatal("expected error for tampered signature")
+	}
+}
+
+func TestSignSubjectKeyIdentifier(t *testing.T) {
+	data := []byte("hello, world!")
+
+	// The leaf has no SubjectKeyIdentifier extension, so one is derived from
+	// its public key. The intermediate has one.
+	if len(leaf.Certificate.SubjectKeyId) != 0 {
+		t.Fatal("expected leaf without SubjectKeyIdentifier extension")
+	}
+	ski := intermediate.Issue(fakeca.PrivateKey(intermediateKey), fakeca.IsCA)
+	if len(ski.Certificate.SubjectKeyId) == 0 {
+		t.Fatal("expected cert with SubjectKeyIdentifier extension")
+	}
+
+	for _, ident := range []*fakeca.Identity{leaf, ski} {
+		der, err := SignWithOptions(data, ident.Chain(), ident.PrivateKey, SignOptions{SubjectKeyIdentifier: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd, err := ParseSignedData(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = sd.Verify(rootOpts); err != nil {
+			t.Fatal(err)
+		}
+
+		si := sd.psd.SignerInfos[0]
+		if sd.psd.Version != 3 || si.Version != 3 {
+			t.Fatalf("expected version 3, got SignedData=%d SignerInfo=%d", sd.psd.Version, si.Version)
+		}
+		if si.SID.Class != asn1.ClassContextSpecific || si.SID.Tag != 0 {
+			t.Fatal("expected [0] SubjectKeyIdentifier SID")
+		}
+
+		certs, err := sd.GetCertificates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert, err := si.FindCertificate(certs); err != nil {
+			t.Fatal(err)
+		} else if !cert.Equal(ident.Certificate) {
+			t.Fatal("found wrong certificate")
+		}
+	}
+}
+
+func TestVerifySubjectKeyIdentifierWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	ident := intermediate.Issue(fakeca.IsCA)
+
+	dir, err := ioutil.TempDir("", "TestVerifySubjectKeyIdentifierWithOpenSSL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(ident.PrivateKey.(*rsa.PrivateKey))})
+	if err = ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ident.Certificate.Raw})
+	if err = ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	contentFile := filepath.Join(dir, "content")
+	if err = ioutil.WriteFile(contentFile, []byte("hello, world!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(opensslPath, "cms", "-sign", "-keyid", "-nodetach", "-binary",
+		"-in", contentFile, "-signer", certFile, "-inkey", keyFile, "-outform", "DER")
+
+	der, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.psd.SignerInfos[0].Version != 3 {
+		t.Fatalf("expected version 3 SignerInfo, got %d", sd.psd.SignerInfos[0].Version)
+	}
+	if err = sd.SetCertificates(ident.Chain()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignDetachedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("hello, world!\n"), 100000)
+
+	der, err := SignDetachedReader(bytes.NewReader(data), leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sd.IsDetached() {
+		t.Fatal("expected detached signature")
+	}
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// With options.
+	if der, err = SignDetachedReaderWithOptions(bytes.NewReader(data), leaf.Chain(), leaf.PrivateKey, SignOptions{DigestAlgorithm: crypto.SHA384}); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached(data[1:], rootOpts); err == nil {
+		t.Fatal("expected error for wrong message")
+	}
+
+	// Read errors are returned.
+	readErr := errors.New("read error")
+	if _, err = SignDetachedReader(errReader{readErr}, leaf.Chain(), leaf.PrivateKey); err != readErr {
+		t.Fatalf("expected %v, got %v", readErr, err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestPrepareAndCompleteSignerInfo(t *testing.T) {
+	data := []byte("hello, world!")
+
+	for _, opts := range []SignOptions{{}, {SignatureAlgorithm: x509.SHA384WithRSAPSS}, {SubjectKeyIdentifier: true}} {
+		sd, err := NewSignedData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prepared, err := sd.PrepareSignerInfo(leaf.Chain(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Serialize the prepared state, as if it were sent to another process.
+		der, err := prepared.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prepared, err = ParsePreparedSignerInfo(der); err != nil {
+			t.Fatal(err)
+		}
+		if cert, err := prepared.Certificate(); err != nil {
+			t.Fatal(err)
+		} else if !cert.Equal(leaf.Certificate) {
+			t.Fatal("wrong certificate")
+		}
+
+		// The digest covers the signed attributes.
+		attrs, err := prepared.SignedAttributes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, signerOpts, err := prepared.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := signerOpts.HashFunc().New()
+		h.Write(attrs)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			t.Fatal("digest doesn't match signed attributes")
+		}
+
+		// A bad signature is rejected.
+		if err = sd.CompleteSignerInfo(prepared, []byte("bad signature")); err == nil {
+			t.Fatal("expected error for bad signature")
+		}
+
+		signature, err := leaf.PrivateKey.Sign(rand.Reader, digest, signerOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.CompleteSignerInfo(prepared, signature); err != nil {
+			t.Fatal(err)
+		}
+
+		if der, err = sd.ToDER(); err != nil {
+			t.Fatal(err)
+		}
+		if sd, err = ParseSignedData(der); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = sd.Verify(rootOpts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A prepared SignerInfo can't be completed for different content.
+	sd, _ := NewSignedData(data)
+	prepared, err := sd.PrepareSignerInfo(leaf.Chain(), SignOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, signerOpts, _ := prepared.Digest()
+	signature, _ := leaf.PrivateKey.Sign(rand.Reader, digest, signerOpts)
+	other, _ := NewSignedData([]byte("goodbye, world!"))
+	if err = other.CompleteSignerInfo(prepared, signature); err == nil {
+		t.Fatal("expected error for different content")
+	}
+}
+
+func TestPrepareAndCompleteSignerInfoEd25519(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident := intermediate.Issue(fakeca.PrivateKey(priv))
+
+	sd, err := NewSignedData([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := sd.PrepareSignerInfo(ident.Chain(), SignOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// EdDSA signs the signed attributes themselves.
+	attrs, _ := prepared.SignedAttributes()
+	message, signerOpts, err := prepared.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signerOpts.HashFunc() != 0 || !bytes.Equal(message, attrs) {
+		t.Fatal("expected signed attributes to be signed directly")
+	}
+
+	if err = sd.CompleteSignerInfo(prepared, ed25519.Sign(priv, message)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignSigningCertificate(t *testing.T) {
+	data := []byte("hello, world!")
+
+	// A second certificate for the leaf's key.
+	substitute := intermediate.Issue(fakeca.PrivateKey(leaf.PrivateKey))
+
+	der, err := SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{SubjectKeyIdentifier: true, SigningCertificateIssuerSerial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := sd.psd.SignerInfos[0].GetSigningCertificateV2Attribute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest := sha256.Sum256(leaf.Certificate.Raw); !bytes.Equal(sc.Certs[0].CertHash, digest[:]) {
+		t.Fatal("wrong certificate hash")
+	}
+	if !sc.Certs[0].IssuerSerial.Matches(leaf.Certificate) {
+		t.Fatal("wrong issuerSerial")
+	}
+
+	// Swapping in another certificate for the same key is detected.
+	if err = sd.SetCertificates(substitute.Chain()); err != nil {
+		t.Fatal(err)
+	}
+	var certErr *CertificateNotFoundError
+	if _, err = sd.Verify(rootOpts); !errors.As(err, &certErr) || !errors.Is(err, protocol.ErrSigningCertificateMismatch) {
+		t.Fatalf("expected %v, got %v", protocol.ErrSigningCertificateMismatch, err)
+	}
+
+	// Without the attribute, the substitution goes unnoticed.
+	if der, err = SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{SubjectKeyIdentifier: true, OmitSigningCertificate: true}); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if sd.psd.SignerInfos[0].SignedAttrs.HasAttribute(oid.AttributeSigningCertificateV2) {
+		t.Fatal("unexpected signing-certificate-v2 attribute")
+	}
+	if err = sd.SetCertificates(substitute.Chain()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignAlgorithmPolicy(t *testing.T) {
+	data := []byte("hello, world!")
+
+	if _, err := SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{DigestAlgorithm: crypto.SHA1}); !errors.Is(err, protocol.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected algorithm policy error, got %v", err)
+	}
+
+	der, err := SignWithOptions(data, leaf.Chain(), leaf.PrivateKey, SignOptions{
+		DigestAlgorithm: crypto.SHA1,
+		AlgorithmPolicy: protocol.LegacyAlgorithmPolicy(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := VerifyOptions{
+		VerifyOptions:   x509.VerifyOptions{Roots: root.ChainPool()},
+		AlgorithmPolicy: protocol.LegacyAlgorithmPolicy(),
+	}
+	if _, err = sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak := intermediate.Issue(fakeca.PrivateKey(priv))
+	if _, err = SignWithOptions(data, weak.Chain(), weak.PrivateKey, SignOptions{}); !errors.Is(err, protocol.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected algorithm policy error, got %v", err)
+	}
+	if _, err = SignWithOptions(data, weak.Chain(), weak.PrivateKey, SignOptions{AlgorithmPolicy: protocol.LegacyAlgorithmPolicy()}); err != nil {
+		t.Fatal(err)
+	}
+}
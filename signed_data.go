@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 
+	"github.com/github/ietf-cms/oid"
 	"github.com/github/ietf-cms/protocol"
 )
 
@@ -66,6 +67,42 @@ func (sd *SignedData) SetCertificates(certs []*x509.Certificate) error {
 	return nil
 }
 
+// AddCRL embeds a CRL in the SignedData. Nothing is added if the same CRL is
+// already present.
+func (sd *SignedData) AddCRL(crl *x509.RevocationList) error {
+	return sd.psd.AddCRL(crl.Raw)
+}
+
+// GetCRLs gets the CRLs embedded in the SignedData. Other revocation info, such
+// as OCSP responses, isn't included.
+func (sd *SignedData) GetCRLs() ([]*x509.RevocationList, error) {
+	ders := sd.psd.CertificateLists()
+
+	crls := make([]*x509.RevocationList, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+
+	return crls, nil
+}
+
+// AddOCSPResponse embeds a DER encoded OCSP response in the SignedData's crls,
+// using the id-ri-ocsp-response format (RFC5940). This changes the SignedData
+// version to 5. Nothing is added if the same response is already present.
+func (sd *SignedData) AddOCSPResponse(der []byte) error {
+	return sd.psd.AddOtherRevocationInfo(oid.RevocationInfoFormatOCSPResponse, der)
+}
+
+// GetOCSPResponses gets the DER encoded OCSP responses embedded in the
+// SignedData.
+func (sd *SignedData) GetOCSPResponses() ([][]byte, error) {
+	return sd.psd.OtherRevocationInfo(oid.RevocationInfoFormatOCSPResponse)
+}
+
 // Detached removes the data content from this SignedData. No more signatures
 // can be added after this method has been called.
 func (sd *SignedData) Detached() {
@@ -0,0 +1,157 @@
+package cms
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+
+	"github.com/github/ietf-cms/protocol"
+)
+
+// Signer provides access to one of the SignerInfos in a SignedData. Changes
+// made through a Signer are reflected in the SignedData it came from.
+type Signer struct {
+	sd    *SignedData
+	index int
+}
+
+// Signers gets the signers of the SignedData, in the order their SignerInfos
+// appear in the message.
+func (sd *SignedData) Signers() []Signer {
+	signers := make([]Signer, len(sd.psd.SignerInfos))
+	for i := range signers {
+		signers[i] = Signer{sd: sd, index: i}
+	}
+
+	return signers
+}
+
+// Index gets the position of this signer's SignerInfo in the SignedData.
+func (s Signer) Index() int {
+	return s.index
+}
+
+// signerInfo gets a pointer to the underlying protocol.SignerInfo.
+func (s Signer) signerInfo() *protocol.SignerInfo {
+	return &s.sd.psd.SignerInfos[s.index]
+}
+
+// SID gets the raw SignerIdentifier, which is either an IssuerAndSerialNumber
+// or a [0] SubjectKeyIdentifier.
+func (s Signer) SID() asn1.RawValue {
+	return s.signerInfo().SID
+}
+
+// Certificate finds the signer's certificate among the certificates stored in
+// the SignedData. A protocol.ErrNoCertificate is returned if it isn't found.
+func (s Signer) Certificate() (*x509.Certificate, error) {
+	certs, err := s.sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signerInfo().FindCertificate(certs)
+}
+
+// SignatureAlgorithm gets the algorithm the signature was made with.
+// x509.UnknownSignatureAlgorithm is returned for unrecognized algorithms.
+func (s Signer) SignatureAlgorithm() x509.SignatureAlgorithm {
+	return s.signerInfo().X509SignatureAlgorithm()
+}
+
+// SignedAttributes gets a copy of the signer's signed attributes. Nil is
+// returned if the SignerInfo has no signed attributes.
+func (s Signer) SignedAttributes() protocol.Attributes {
+	return copyAttributes(s.signerInfo().SignedAttrs)
+}
+
+// UnsignedAttributes gets a copy of the signer's unsigned attributes. Nil is
+// returned if the SignerInfo has no unsigned attributes.
+func (s Signer) UnsignedAttributes() protocol.Attributes {
+	return copyAttributes(s.signerInfo().UnsignedAttrs)
+}
+
+// GetSignedAttribute decodes the value of the signed attribute with the given
+// type into val, which must be a pointer as with asn1.Unmarshal. An error is
+// returned if the attribute occurs multiple times or has multiple values. A
+// protocol.ErrNoAttribute is returned if the attribute is missing.
+func (s Signer) GetSignedAttribute(typ asn1.ObjectIdentifier, val interface{}) error {
+	return getAttribute(s.signerInfo().SignedAttrs, typ, val)
+}
+
+// GetUnsignedAttribute decodes the value of the unsigned attribute with the
+// given type into val, which must be a pointer as with asn1.Unmarshal. An error
+// is returned if the attribute occurs multiple times or has multiple values. A
+// protocol.ErrNoAttribute is returned if the attribute is missing.
+func (s Signer) GetUnsignedAttribute(typ asn1.ObjectIdentifier, val interface{}) error {
+	return getAttribute(s.signerInfo().UnsignedAttrs, typ, val)
+}
+
+// SigningTime gets the value of the signing-time attribute. The zero time is
+// returned if the attribute is missing.
+func (s Signer) SigningTime() (time.Time, error) {
+	return s.signerInfo().GetSigningTimeAttribute()
+}
+
+// SetUnsignedAttribute adds an unsigned attribute with a single value, replacing
+// any existing attributes of the same type. Unsigned attributes aren't
+// covered by the signature, so this doesn't invalidate it.
+func (s Signer) SetUnsignedAttribute(typ asn1.ObjectIdentifier, val interface{}) error {
+	attr, err := protocol.NewAttribute(typ, val)
+	if err != nil {
+		return err
+	}
+
+	si := s.signerInfo()
+	si.UnsignedAttrs = append(si.UnsignedAttrs.RemoveAttribute(typ), attr)
+
+	return nil
+}
+
+// AddUnsignedAttributeValue adds a value to the unsigned attribute with the
+// given type, creating the attribute if it doesn't exist yet.
+func (s Signer) AddUnsignedAttributeValue(typ asn1.ObjectIdentifier, val interface{}) error {
+	si := s.signerInfo()
+
+	for i := range si.UnsignedAttrs {
+		if si.UnsignedAttrs[i].Type.Equal(typ) {
+			return si.UnsignedAttrs[i].AddValue(val)
+		}
+	}
+
+	return s.SetUnsignedAttribute(typ, val)
+}
+
+// RemoveUnsignedAttribute removes any unsigned attributes with the given type.
+func (s Signer) RemoveUnsignedAttribute(typ asn1.ObjectIdentifier) {
+	si := s.signerInfo()
+	si.UnsignedAttrs = si.UnsignedAttrs.RemoveAttribute(typ)
+}
+
+// getAttribute decodes the only value of the attribute with the given type.
+func getAttribute(attrs protocol.Attributes, typ asn1.ObjectIdentifier, val interface{}) error {
+	if !attrs.HasAttribute(typ) {
+		return protocol.ErrNoAttribute
+	}
+
+	rv, err := attrs.GetOnlyAttributeValueBytes(typ)
+	if err != nil {
+		return err
+	}
+
+	if rest, err := asn1.Unmarshal(rv.FullBytes, val); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return protocol.ErrTrailingData
+	}
+
+	return nil
+}
+
+func copyAttributes(attrs protocol.Attributes) protocol.Attributes {
+	if attrs == nil {
+		return nil
+	}
+
+	return append(protocol.Attributes{}, attrs...)
+}
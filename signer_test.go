@@ -0,0 +1,124 @@
+package cms
+
+import (
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/github/ietf-cms/oid"
+	"github.com/github/ietf-cms/protocol"
+)
+
+func TestSignerAttributes(t *testing.T) {
+	var (
+		signedType   = asn1.ObjectIdentifier{1, 2, 3, 4, 1}
+		unsignedType = asn1.ObjectIdentifier{1, 2, 3, 4, 2}
+	)
+
+	signedAttr, err := protocol.NewAttribute(signedType, "signed value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := NewSignedData([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{SignedAttrs: protocol.Attributes{signedAttr}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(intermediate.Chain(), intermediate.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	signers := sd.Signers()
+	if len(signers) != 2 {
+		t.Fatalf("expected 2 signers, got %d", len(signers))
+	}
+	if cert, err := signers[1].Certificate(); err != nil {
+		t.Fatal(err)
+	} else if !cert.Equal(intermediate.Certificate) {
+		t.Fatal("wrong certificate for second signer")
+	}
+
+	// Typed access to signed attributes.
+	var signedVal string
+	if err = signers[0].GetSignedAttribute(signedType, &signedVal); err != nil {
+		t.Fatal(err)
+	}
+	if signedVal != "signed value" {
+		t.Fatalf("unexpected signed attribute value: %q", signedVal)
+	}
+	var ct asn1.ObjectIdentifier
+	if err = signers[0].GetSignedAttribute(oid.AttributeContentType, &ct); err != nil {
+		t.Fatal(err)
+	}
+	if !ct.Equal(oid.ContentTypeData) {
+		t.Fatalf("unexpected content-type: %s", ct)
+	}
+	if st, err := signers[0].SigningTime(); err != nil {
+		t.Fatal(err)
+	} else if time.Since(st) > time.Minute {
+		t.Fatalf("unexpected signing-time: %s", st)
+	}
+	if err = signers[1].GetSignedAttribute(signedType, &signedVal); err != protocol.ErrNoAttribute {
+		t.Fatalf("expected %v, got %v", protocol.ErrNoAttribute, err)
+	}
+
+	// Set, add and replace unsigned attributes on a parsed message.
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	signer := sd.Signers()[0]
+
+	if err = signer.SetUnsignedAttribute(unsignedType, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = signer.AddUnsignedAttributeValue(unsignedType, 2); err != nil {
+		t.Fatal(err)
+	}
+	if vals, err := signer.UnsignedAttributes().GetValues(unsignedType); err != nil {
+		t.Fatal(err)
+	} else if len(vals) != 1 || len(vals[0].Elements) != 2 {
+		t.Fatalf("expected one attribute with two values, got %v", vals)
+	}
+	if err = signer.SetUnsignedAttribute(unsignedType, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if der, err = sd.ToDER(); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+	var unsignedVal int
+	if err = sd.Signers()[0].GetUnsignedAttribute(unsignedType, &unsignedVal); err != nil {
+		t.Fatal(err)
+	}
+	if unsignedVal != 3 {
+		t.Fatalf("expected 3, got %d", unsignedVal)
+	}
+
+	// Remove the unsigned attribute. The empty UnsignedAttrs should be omitted.
+	sd.Signers()[0].RemoveUnsignedAttribute(unsignedType)
+	if der, err = sd.ToDER(); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := sd.Signers()[0].UnsignedAttributes(); attrs != nil {
+		t.Fatalf("expected no unsigned attributes, got %v", attrs)
+	}
+}
@@ -3,7 +3,6 @@ package cms
 import (
 	"bytes"
 	"crypto/x509"
-	"errors"
 
 	"github.com/github/ietf-cms/oid"
 	"github.com/github/ietf-cms/protocol"
@@ -43,18 +42,29 @@ func fetchTS(url string, si protocol.SignerInfo) (protocol.Attribute, error) {
 		return nilAttr, err
 	}
 
-	resp, err := req.Do(url)
+	tst, err := doTSRequest(url, req)
 	if err != nil {
 		return nilAttr, err
 	}
 
+	return protocol.NewAttribute(oid.AttributeTimeStampToken, tst)
+}
+
+// doTSRequest sends req to the timestamping service at url and checks that the
+// response matches it.
+func doTSRequest(url string, req timestamp.Request) (protocol.ContentInfo, error) {
+	resp, err := req.Do(url)
+	if err != nil {
+		return protocol.ContentInfo{}, err
+	}
+
 	if tsti, err := resp.Info(); err != nil {
-		return nilAttr, err
+		return protocol.ContentInfo{}, err
 	} else if !req.Matches(tsti) {
-		return nilAttr, errors.New("invalid message imprint")
+		return protocol.ContentInfo{}, ErrInvalidMessageImprint
 	}
 
-	return protocol.NewAttribute(oid.AttributeTimeStampToken, resp.TimeStampToken)
+	return resp.TimeStampToken, nil
 }
 
 func tsRequest(si protocol.SignerInfo) (timestamp.Request, error) {
@@ -68,54 +78,98 @@ func tsRequest(si protocol.SignerInfo) (timestamp.Request, error) {
 		return timestamp.Request{}, err
 	}
 
+	return newTSRequest(mi), nil
+}
+
+func newTSRequest(mi timestamp.MessageImprint) timestamp.Request {
 	return timestamp.Request{
 		Version:        1,
 		CertReq:        true,
 		Nonce:          timestamp.GenerateNonce(),
 		MessageImprint: mi,
-	}, nil
+	}
 }
 
 // getTimestamp verifies and returns the timestamp.Info from the SignerInfo.
 func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions) (timestamp.Info, error) {
+	tsti, _, err := verifyTimestamp(si, VerifyOptions{VerifyOptions: opts})
+	return tsti, err
+}
+
+// verifyTimestamp verifies the timestamp from the SignerInfo, returning the
+// timestamp.Info and the TSA's chains.
+func verifyTimestamp(si protocol.SignerInfo, opts VerifyOptions) (timestamp.Info, [][]*x509.Certificate, error) {
 	rawValue, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
 	if err != nil {
-		return timestamp.Info{}, err
+		return timestamp.Info{}, nil, err
 	}
 
-	tst, err := ParseSignedData(rawValue.FullBytes)
+	tsti, _, chains, err := verifyTimestampToken(rawValue.FullBytes, opts)
 	if err != nil {
-		return timestamp.Info{}, err
+		return timestamp.Info{}, nil, err
+	}
+
+	// verify timestamp token matches SignerInfo.
+	hash, err := tsti.MessageImprint.Hash()
+	if err != nil {
+		return timestamp.Info{}, nil, err
+	}
+	if err = opts.algorithmPolicy().CheckDigestAlgorithm(hash, opts.currentTime()); err != nil {
+		return timestamp.Info{}, nil, err
+	}
+	mi, err := timestamp.NewMessageImprint(hash, bytes.NewReader(si.Signature))
+	if err != nil {
+		return timestamp.Info{}, nil, err
+	}
+	if !mi.Equal(tsti.MessageImprint) {
+		return timestamp.Info{}, nil, ErrInvalidMessageImprint
+	}
+
+	return tsti, chains, nil
+}
+
+// verifyTimestampToken parses a TimeStampToken and verifies its signature and
+// certificate chain. The token and the TSA's chains are returned along with
+// the timestamp.Info. The caller is responsible for checking the message
+// imprint.
+func verifyTimestampToken(der []byte, opts VerifyOptions) (timestamp.Info, *SignedData, [][]*x509.Certificate, error) {
+	tst, err := ParseSignedData(der)
+	if err != nil {
+		return timestamp.Info{}, nil, nil, err
 	}
 
 	tsti, err := timestamp.ParseInfo(tst.psd.EncapContentInfo)
 	if err != nil {
-		return timestamp.Info{}, err
+		return timestamp.Info{}, nil, nil, err
 	}
 
 	if tsti.Version != 1 {
-		return timestamp.Info{}, protocol.ErrUnsupported
+		return timestamp.Info{}, nil, nil, protocol.ErrUnsupported
 	}
 
 	// verify timestamp signature and certificate chain..
-	if _, err = tst.Verify(opts); err != nil {
-		return timestamp.Info{}, err
+	chains, err := tst.VerifyWithOptions(opts)
+	if err != nil {
+		return timestamp.Info{}, nil, nil, err
 	}
 
-	// verify timestamp token matches SignerInfo.
-	hash, err := tsti.MessageImprint.Hash()
+	return tsti, tst, chains[0], nil
+}
+
+// parseTimestamp gets the timestamp.Info from the SignerInfo's timestamp
+// without verifying it.
+func parseTimestamp(si protocol.SignerInfo) (timestamp.Info, error) {
+	rawValue, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
 	if err != nil {
 		return timestamp.Info{}, err
 	}
-	mi, err := timestamp.NewMessageImprint(hash, bytes.NewReader(si.Signature))
+
+	tst, err := ParseSignedData(rawValue.FullBytes)
 	if err != nil {
 		return timestamp.Info{}, err
 	}
-	if !mi.Equal(tsti.MessageImprint) {
-		return timestamp.Info{}, errors.New("invalid message imprint")
-	}
 
-	return tsti, nil
+	return timestamp.ParseInfo(tst.psd.EncapContentInfo)
 }
 
 // hasTimestamp checks if si has a timestamp.
@@ -1,9 +1,10 @@
 package cms
 
 import (
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
-	"strings"
+	"errors"
 	"testing"
 	"time"
 
@@ -48,7 +49,7 @@ func TestAddTimestamps(t *testing.T) {
 	})
 	sd, _ = NewSignedData([]byte("hi"))
 	sd.Sign(leaf.Chain(), leaf.PrivateKey)
-	if err := sd.AddTimestamps("https://google.com"); err == nil || err.Error() != "invalid message imprint" {
+	if err := sd.AddTimestamps("https://google.com"); !errors.Is(err, ErrInvalidMessageImprint) {
 		t.Fatalf("expected 'invalid message imprint', got %v", err)
 	}
 
@@ -59,7 +60,7 @@ func TestAddTimestamps(t *testing.T) {
 	})
 	sd, _ = NewSignedData([]byte("hi"))
 	sd.Sign(leaf.Chain(), leaf.PrivateKey)
-	if err := sd.AddTimestamps("https://google.com"); err == nil || err.Error() != "invalid message imprint" {
+	if err := sd.AddTimestamps("https://google.com"); !errors.Is(err, ErrInvalidMessageImprint) {
 		t.Fatalf("expected 'invalid message imprint', got %v", err)
 	}
 }
@@ -100,7 +101,7 @@ func TestTimestampsVerifications(t *testing.T) {
 	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := sd.Verify(intermediateOpts); err == nil || !strings.HasPrefix(err.Error(), "x509: certificate has expired") {
+	if _, err := sd.Verify(intermediateOpts); !isExpiredChainError(err) {
 		t.Fatalf("expected expired error, got %v", err)
 	}
 
@@ -138,7 +139,7 @@ func TestTimestampsVerifications(t *testing.T) {
 	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := sd.Verify(intermediateOpts); err == nil || !strings.HasPrefix(err.Error(), "x509: certificate has expired") {
+	if _, err := sd.Verify(intermediateOpts); !isExpiredChainError(err) {
 		t.Fatalf("expected expired error, got %v", err)
 	}
 
@@ -167,7 +168,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err == nil || err.Error() != "invalid message imprint" {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); !errors.Is(err, ErrInvalidMessageImprint) {
 		t.Fatalf("expected 'invalid message imprint', got %v", err)
 	}
 
@@ -181,17 +182,37 @@ func TestTimestampsVerifications(t *testing.T) {
 	sd = getTimestampedSignedData()
 	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err == nil {
 		t.Fatal("expected error")
-	} else if _, ok := err.(x509.UnknownAuthorityError); !ok {
+	} else if x509Err := (x509.UnknownAuthorityError{}); !errors.As(err, &x509Err) {
 		t.Fatalf("expected x509.UnknownAuthorityError, got %v", err)
 	}
 
+	// The TSA's chain error is wrapped in a TimestampError for the signer.
+	var (
+		tsErr    *TimestampError
+		chainErr *ChainError
+	)
+	if _, err := sd.Verify(intermediateOpts); !errors.As(err, &tsErr) || !errors.As(tsErr.Err, &chainErr) {
+		t.Fatalf("expected TimestampError wrapping ChainError, got %v", err)
+	} else if tsErr.SignerIndex != 0 || !bytes.Equal(tsErr.SID.FullBytes, sd.psd.SignerInfos[0].SID.FullBytes) {
+		t.Fatal("bad signer in TimestampError")
+	}
+
 	// Bad signature
 	tsa.HookToken(func(tst *protocol.SignedData) *protocol.SignedData {
 		tst.SignerInfos[0].Signature[0] ^= 0xFF
 		return tst
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != rsa.ErrVerification {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); !errors.Is(err, rsa.ErrVerification) {
 		t.Fatalf("expected %v, got %v", rsa.ErrVerification, err)
 	}
 }
+
+func isExpiredChainError(err error) bool {
+	var (
+		chainErr *ChainError
+		certErr  x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &chainErr) && errors.As(err, &certErr) && certErr.Reason == x509.Expired
+}
@@ -2,20 +2,134 @@ package cms
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
 
 	"github.com/github/ietf-cms/protocol"
+	"github.com/github/ietf-cms/timestamp"
+	"golang.org/x/crypto/ocsp"
 )
 
+// VerifyOptions customizes verification by VerifyWithOptions and related
+// methods. Its zero value verifies the same way as Verify does with zero
+// x509.VerifyOptions.
+type VerifyOptions struct {
+	// VerifyOptions are used to verify the signers' certificate chains, as
+	// described for Verify.
+	x509.VerifyOptions
+
+	// Certificates are searched for the signers' certificates, in addition to
+	// the certificates included in the SignedData, and are added to the
+	// intermediates. This allows verifying messages whose certificates were
+	// omitted.
+	Certificates []*x509.Certificate
+
+	// CertificateLookup, if set, is used to find signers' certificates that
+	// aren't in the SignedData or Certificates. The issuers of a certificate
+	// found this way are looked up by its authority key identifier and added
+	// to the intermediates.
+	CertificateLookup CertificateLookup
+
+	// PinnedKeys, if set, are the only keys that signers are trusted to use.
+	// A signer's certificate is accepted if its key matches one of them,
+	// without building a chain or checking the certificate's validity period,
+	// key usages or revocation status. This allows verifying signatures from
+	// self-signed certificates or keys distributed out of band. The message
+	// digest, content type and timestamp checks are done as usual, with the
+	// timestamp authority's certificate still being verified using
+	// VerifyOptions. The matched key is reported in SignerResult.PinnedKey, and
+	// each signer's chains contain just its certificate. A signer identified
+	// by a subject key identifier doesn't need to include its certificate if
+	// the identifier matches one derived from a pinned PublicKey. Its
+	// signing-certificate attribute can't be checked then, and its
+	// SignerResult has no Certificate or Chains.
+	PinnedKeys []PinnedKey
+
+	// CheckEmbeddedCRLs checks each signer's chains against the CRLs embedded
+	// in the SignedData. Every certificate in a chain, other than the root,
+	// must be covered by an embedded CRL from its issuer that hadn't expired at
+	// the signing time, and must not have been revoked before then. The signing
+	// time is the signature timestamp's time if there is one, or else
+	// CurrentTime or the current time. Chains that fail the check are dropped,
+	// and an error is returned if none remain.
+	CheckEmbeddedCRLs bool
+
+	// CheckEmbeddedOCSP checks each signer's chains against the OCSP responses
+	// embedded in the SignedData (RFC5940), in the same way as
+	// CheckEmbeddedCRLs. A response must have been valid at the signing time,
+	// and be signed by the certificate's issuer or a responder it delegated to.
+	// If both are set, a certificate may be covered by either an OCSP response
+	// or a CRL, with OCSP responses being checked first.
+	CheckEmbeddedOCSP bool
+
+	// RevocationChecker, if set, is used to check the status of every
+	// certificate in each signer's chains, other than the root. The embedded
+	// revocation data is checked first if CheckEmbeddedCRLs or
+	// CheckEmbeddedOCSP is set, and RevocationChecker is only used for
+	// certificates it doesn't cover. Chains with revoked certificates are
+	// dropped, and an error is returned if none remain.
+	RevocationChecker RevocationChecker
+
+	// RevocationSoftFail accepts certificates whose status RevocationChecker
+	// couldn't determine, for example because a CRL or OCSP responder was
+	// unreachable. By default such certificates are treated as revoked.
+	RevocationSoftFail bool
+
+	// RevocationCheckNow makes RevocationChecker check certificates' status at
+	// the current time, rather than at the signing time. This rejects
+	// signatures made before their certificates were revoked.
+	RevocationCheckNow bool
+
+	// AlgorithmPolicy restricts the algorithms and keys that signers, including
+	// countersigners and timestamp authorities, may use.
+	// protocol.DefaultAlgorithmPolicy is used if it's nil, and
+	// protocol.LegacyAlgorithmPolicy can be used for verifying old messages.
+	// Algorithm cut-offs are compared with the signing time, which is the
+	// latest time allowed by the signature's timestamp if there is one, or
+	// else CurrentTime or the current time. The certificates in the chains are
+	// checked by x509, rather than by the policy.
+	AlgorithmPolicy *protocol.AlgorithmPolicy
+}
+
+func (opts VerifyOptions) algorithmPolicy() *protocol.AlgorithmPolicy {
+	if opts.AlgorithmPolicy == nil {
+		return protocol.DefaultAlgorithmPolicy()
+	}
+
+	return opts.AlgorithmPolicy
+}
+
+// currentTime gets CurrentTime or, if it isn't set, the current time.
+func (opts VerifyOptions) currentTime() time.Time {
+	if opts.CurrentTime.IsZero() {
+		return time.Now()
+	}
+
+	return opts.CurrentTime
+}
+
 // Verify verifies the SingerInfos' signatures. Each signature's associated
 // certificate is verified using the provided roots. UnsafeNoVerify may be
 // specified to skip this verification. Nil may be provided to use system roots.
 // The full chains for the certificates whose keys made the signatures are
-// returned.
+// returned. Any countersignatures are verified too.
 //
-// WARNING: this function doesn't do any revocation checking.
+// WARNING: this function doesn't do any revocation checking. See
+// VerifyWithRevocation.
 func (sd *SignedData) Verify(opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	return sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts})
+}
+
+// VerifyWithOptions is like Verify, but allows additional checks to be enabled
+// with opts.
+//
+// WARNING: this function only does revocation checking if opts asks for it.
+func (sd *SignedData) VerifyWithOptions(opts VerifyOptions) ([][][]*x509.Certificate, error) {
 	econtent, err := sd.psd.EncapContentInfo.EContentValue()
 	if err != nil {
 		return nil, err
@@ -24,28 +138,478 @@ func (sd *SignedData) Verify(opts x509.VerifyOptions) ([][][]*x509.Certificate,
 		return nil, errors.New("detached signature")
 	}
 
-	return sd.verify(econtent, opts)
+	digests, err := sd.messageDigests(bytes.NewReader(econtent))
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verify(econtent, digests, opts)
+}
+
+// VerifyWithRevocation is like Verify, but also checks the status of the
+// certificates in each signer's chains with rc. The status is checked at the
+// signing time, and certificates whose status can't be determined are treated
+// as revoked. VerifyWithOptions allows this to be changed.
+func (sd *SignedData) VerifyWithRevocation(opts x509.VerifyOptions, rc RevocationChecker) ([][][]*x509.Certificate, error) {
+	return sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts, RevocationChecker: rc})
 }
 
 // VerifyDetached verifies the SingerInfos' detached signatures over the
 // provided data message. Each signature's associated certificate is verified
 // using the provided roots. UnsafeNoVerify may be specified to skip this
 // verification. Nil may be provided to use system roots. The full chains for
-// the certificates whose keys made the signatures are returned.
+// the certificates whose keys made the signatures are returned. Any
+// countersignatures are verified too.
 //
-// WARNING: this function doesn't do any revocation checking.
+// WARNING: this function doesn't do any revocation checking. See
+// VerifyDetachedWithRevocation.
 func (sd *SignedData) VerifyDetached(message []byte, opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	return sd.VerifyDetachedWithOptions(message, VerifyOptions{VerifyOptions: opts})
+}
+
+// VerifyDetachedWithOptions is like VerifyDetached, but allows additional
+// checks to be enabled with opts.
+//
+// WARNING: this function only does revocation checking if opts asks for it.
+func (sd *SignedData) VerifyDetachedWithOptions(message []byte, opts VerifyOptions) ([][][]*x509.Certificate, error) {
 	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
 		return nil, errors.New("signature not detached")
 	}
-	return sd.verify(message, opts)
+
+	// verify treats a nil econtent as streamed content, so make sure an empty
+	// message isn't mistaken for it.
+	if message == nil {
+		message = []byte{}
+	}
+
+	digests, err := sd.messageDigests(bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verify(message, digests, opts)
+}
+
+// VerifyDetachedWithRevocation is like VerifyDetached, but checks the status of
+// the certificates in each signer's chains with rc, as described for
+// VerifyWithRevocation.
+func (sd *SignedData) VerifyDetachedWithRevocation(message []byte, opts x509.VerifyOptions, rc RevocationChecker) ([][][]*x509.Certificate, error) {
+	return sd.VerifyDetachedWithOptions(message, VerifyOptions{VerifyOptions: opts, RevocationChecker: rc})
+}
+
+// VerifyDetachedReader is like VerifyDetached, but reads the message from r.
+// The message is read once, computing the digests for all SignerInfos at the
+// same time, and is never held in memory. Because of this, every SignerInfo
+// must have SignedAttrs, since the signature would otherwise be over the
+// message itself.
+//
+// WARNING: this function doesn't do any revocation checking.
+func (sd *SignedData) VerifyDetachedReader(r io.Reader, opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	return sd.VerifyDetachedReaderWithOptions(r, VerifyOptions{VerifyOptions: opts})
+}
+
+// VerifyDetachedReaderWithOptions is like VerifyDetachedReader, but allows
+// additional checks to be enabled with opts.
+//
+// WARNING: this function only does revocation checking if opts asks for it.
+func (sd *SignedData) VerifyDetachedReaderWithOptions(r io.Reader, opts VerifyOptions) ([][][]*x509.Certificate, error) {
+	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return nil, errors.New("signature not detached")
+	}
+
+	digests, err := sd.messageDigests(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verify(nil, digests, opts)
+}
+
+// SignerResult is the result of verifying one of the SignerInfos.
+type SignerResult struct {
+	// Signer is the SignerInfo that was verified.
+	Signer Signer
+
+	// Certificate is the signer's certificate. It is nil if the certificate
+	// couldn't be found, the signature is invalid or the signer's key was
+	// pinned without a certificate.
+	Certificate *x509.Certificate
+
+	// Chains are the verified chains for Certificate.
+	Chains [][]*x509.Certificate
+
+	// PinnedKey is the entry in VerifyOptions.PinnedKeys that matched
+	// Certificate's key. It is nil if PinnedKeys wasn't set or the
+	// certificate's key isn't pinned.
+	PinnedKey *PinnedKey
+
+	// DigestAlgorithm is the algorithm used to digest the content. It is zero
+	// if the algorithm isn't supported.
+	DigestAlgorithm crypto.Hash
+
+	// SignatureAlgorithm is the algorithm the signature was made with.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	// SigningTime is the value of the signing-time attribute. It is the zero
+	// time if the attribute is missing or invalid. Unlike Timestamp, this is
+	// claimed by the signer and isn't verified.
+	SigningTime time.Time
+
+	// Timestamp is the verified signature timestamp, including its GenTime,
+	// Accuracy and TSA name. It is nil if the SignerInfo doesn't have a
+	// timestamp.
+	Timestamp *timestamp.Info
+
+	// TimestampChains are the verified chains for the TSA's certificate.
+	TimestampChains [][]*x509.Certificate
+
+	// CounterSignatures are the verified countersignatures of the SignerInfo,
+	// including their chains and any nested countersignatures.
+	CounterSignatures []CounterSignature
+
+	// Err is the reason the SignerInfo is invalid, or nil if it's valid.
+	Err error
+}
+
+// SignerPolicy is the number of SignerInfos that must be valid for
+// VerifyDetailed to succeed. AtLeastSigners(n) requires at least n.
+type SignerPolicy int
+
+const (
+	// AllSigners requires every SignerInfo to be valid.
+	AllSigners SignerPolicy = 0
+
+	// AnySigner requires at least one SignerInfo to be valid.
+	AnySigner SignerPolicy = 1
+)
+
+// AtLeastSigners requires at least n SignerInfos to be valid. An n of less
+// than one is treated as one.
+func AtLeastSigners(n int) SignerPolicy {
+	if n < 1 {
+		n = 1
+	}
+
+	return SignerPolicy(n)
 }
 
-func (sd *SignedData) verify(econtent []byte, opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+// check checks that enough of the results are valid. The first invalid
+// result's error is wrapped in the returned error.
+func (p SignerPolicy) check(results []SignerResult) error {
+	var (
+		valid    int
+		firstErr error
+	)
+
+	for _, res := range results {
+		if res.Err == nil {
+			valid++
+		} else if firstErr == nil {
+			firstErr = res.Err
+		}
+	}
+
+	required := int(p)
+	if p == AllSigners || required > len(results) {
+		required = len(results)
+	}
+
+	if valid < required {
+		return fmt.Errorf("%d of %d signers valid, %d required: %w", valid, len(results), required, firstErr)
+	}
+
+	return nil
+}
+
+// VerifyDetailed is like VerifyWithOptions, but verifies every SignerInfo
+// rather than stopping at the first invalid one. A result is returned for each
+// SignerInfo, in order, and policy decides whether enough of them are valid.
+// If not, the results are returned along with an error.
+//
+// WARNING: this function only does revocation checking if opts asks for it.
+func (sd *SignedData) VerifyDetailed(opts VerifyOptions, policy SignerPolicy) ([]SignerResult, error) {
+	econtent, err := sd.psd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return nil, err
+	}
+	if econtent == nil {
+		return nil, errors.New("detached signature")
+	}
+
+	digests, err := sd.messageDigests(bytes.NewReader(econtent))
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verifyWithPolicy(econtent, digests, opts, policy)
+}
+
+// VerifyDetachedDetailed is like VerifyDetailed, but for detached signatures
+// over the provided data message.
+//
+// WARNING: this function only does revocation checking if opts asks for it.
+func (sd *SignedData) VerifyDetachedDetailed(message []byte, opts VerifyOptions, policy SignerPolicy) ([]SignerResult, error) {
+	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return nil, errors.New("signature not detached")
+	}
+
+	if message == nil {
+		message = []byte{}
+	}
+
+	digests, err := sd.messageDigests(bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verifyWithPolicy(message, digests, opts, policy)
+}
+
+func (sd *SignedData) verifyWithPolicy(econtent []byte, digests map[string][]byte, opts VerifyOptions, policy SignerPolicy) ([]SignerResult, error) {
+	results, err := sd.verifyDetailed(econtent, digests, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, policy.check(results)
+}
+
+// messageDigests reads the content from r once, calculating its digest with
+// each of the digest algorithms used by the SignerInfos. In a well formed
+// message, these are the same as the SignedData's DigestAlgorithms. The
+// digests are keyed by algorithm OID.
+func (sd *SignedData) messageDigests(r io.Reader) (map[string][]byte, error) {
+	hashes := map[string]hash.Hash{}
+
+	for _, si := range sd.psd.SignerInfos {
+		algo := si.DigestAlgorithm.Algorithm.String()
+		if _, ok := hashes[algo]; ok {
+			continue
+		}
+
+		h, err := si.NewHash()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[algo] = h
+	}
+
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string][]byte, len(hashes))
+	for algo, h := range hashes {
+		digests[algo] = h.Sum(nil)
+	}
+
+	return digests, nil
+}
+
+// verify verifies the SignerInfos against the message digests calculated by
+// messageDigests, failing if any of them are invalid. The econtent is only
+// needed for SignerInfos without SignedAttrs and may be nil if the content was
+// streamed.
+func (sd *SignedData) verify(econtent []byte, digests map[string][]byte, opts VerifyOptions) ([][][]*x509.Certificate, error) {
+	results, err := sd.verifyDetailed(econtent, digests, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make([][][]*x509.Certificate, 0, len(results))
+	for _, res := range results {
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		chains = append(chains, res.Chains)
+	}
+
+	// OK
+	return chains, nil
+}
+
+// verifyDetailed verifies each of the SignerInfos, as described for verify.
+// Errors with individual SignerInfos are recorded in their results rather than
+// being returned.
+func (sd *SignedData) verifyDetailed(econtent []byte, digests map[string][]byte, opts VerifyOptions) ([]SignerResult, error) {
 	if len(sd.psd.SignerInfos) == 0 {
 		return nil, protocol.ASN1Error{Message: "no signatures found"}
 	}
 
+	v, err := sd.newVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SignerResult, len(sd.psd.SignerInfos))
+	for i, si := range sd.psd.SignerInfos {
+		res := &results[i]
+		res.Signer = Signer{sd: sd, index: i}
+		res.SignatureAlgorithm = si.X509SignatureAlgorithm()
+		res.DigestAlgorithm, _ = si.Hash()
+		res.SigningTime, _ = si.GetSigningTimeAttribute()
+		res.Err = sd.verifySignerInfo(v, si, econtent, digests, res)
+	}
+
+	return results, nil
+}
+
+// verifySignerInfo verifies a SignerInfo, filling in the certificate, chains,
+// timestamp and countersignatures of res as they are verified. The returned
+// error identifies the SignerInfo and the check that failed.
+func (sd *SignedData) verifySignerInfo(v *verifier, si protocol.SignerInfo, econtent []byte, digests map[string][]byte, res *SignerResult) error {
+	i := res.Signer.Index()
+
+	signedMessage, err := sd.signedMessage(i, si, econtent, digests)
+	if err != nil {
+		return err
+	}
+
+	// A signer whose key is pinned may identify it by its subject key
+	// identifier without including a certificate. keyOnly is the pinned key
+	// in that case, and cert holds just the key.
+	var keyOnly *PinnedKey
+	cert, err := v.findCertificate(si)
+	if errors.Is(err, protocol.ErrNoCertificate) && len(v.opts.PinnedKeys) > 0 {
+		if keyOnly, cert = v.pinnedKeyForSID(si); keyOnly != nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return &CertificateNotFoundError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	// Make sure the certificate is the one the signer meant to use if it's
+	// identified by a signing-certificate attribute.
+	if keyOnly == nil {
+		if err = si.CheckSigningCertificate(cert); err != nil {
+			return &CertificateNotFoundError{SignerIndex: i, SID: si.SID, Err: err}
+		}
+	}
+
+	if err := si.CheckSignature(cert, signedMessage); err != nil {
+		return &SignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+	if keyOnly == nil {
+		res.Certificate = cert
+	}
+
+	if res.Timestamp, res.TimestampChains, err = v.verifyTimestamp(si); err != nil {
+		return &TimestampError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	if err = v.checkAlgorithms(si, cert, res.Timestamp); err != nil {
+		return &SignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	if keyOnly != nil {
+		res.PinnedKey = keyOnly
+	} else {
+		if res.Chains, err = v.verifyCertificate(cert, res.Timestamp); err != nil {
+			return &ChainError{SignerIndex: i, SID: si.SID, Err: err}
+		}
+		res.PinnedKey = v.pinnedKey(cert)
+	}
+
+	if res.CounterSignatures, err = v.verifyCounterSignatures(si); err != nil {
+		return &CounterSignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	return nil
+}
+
+// signedMessage checks the signed attributes of the SignerInfo at index i and
+// returns the message its signature is over.
+func (sd *SignedData) signedMessage(i int, si protocol.SignerInfo, econtent []byte, digests map[string][]byte) ([]byte, error) {
+	// SignedAttrs is optional if EncapContentInfo eContentType isn't id-data.
+	if si.SignedAttrs == nil {
+		// SignedAttrs may only be absent if EncapContentInfo eContentType is
+		// id-data.
+		if !sd.psd.EncapContentInfo.IsTypeData() {
+			return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: protocol.ASN1Error{Message: "missing SignedAttrs"}}
+		}
+
+		// If SignedAttrs is absent, the signature is over the original
+		// encapsulated content itself.
+		if econtent == nil {
+			return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: errors.New("missing SignedAttrs for streamed content")}
+		}
+
+		return econtent, nil
+	}
+
+	// If SignedAttrs is present, we validate the mandatory ContentType and
+	// MessageDigest attributes.
+	siContentType, err := si.GetContentTypeAttribute()
+	if err != nil {
+		return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+	if !siContentType.Equal(sd.psd.EncapContentInfo.EContentType) {
+		return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: protocol.ASN1Error{Message: "invalid SignerInfo ContentType attribute"}}
+	}
+
+	// Get the digest over the actual message.
+	actualMessageDigest, ok := digests[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: protocol.ErrUnsupported}
+	}
+
+	// Get the digest from the SignerInfo.
+	messageDigestAttr, err := si.GetMessageDigestAttribute()
+	if err != nil {
+		return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	// Make sure message digests match.
+	if !bytes.Equal(messageDigestAttr, actualMessageDigest) {
+		return nil, &DigestMismatchError{
+			SignerIndex: i,
+			SID:         si.SID,
+			Expected:    messageDigestAttr,
+			Actual:      actualMessageDigest,
+		}
+	}
+
+	// The signature is over the DER encoded signed attributes, minus the
+	// leading class/tag/length bytes. This includes the digest of the
+	// original message, so it is implicitly signed too.
+	signedMessage, err := si.SignedAttrs.MarshaledForVerification()
+	if err != nil {
+		return nil, &SignatureError{SignerIndex: i, SID: si.SID, Err: err}
+	}
+
+	return signedMessage, nil
+}
+
+// verifier holds the state shared by the verification of each SignerInfo in
+// a SignedData.
+type verifier struct {
+	// certs are the certificates from the SignedData and opts.Certificates.
+	certs []*x509.Certificate
+
+	// opts has certs added to its intermediates.
+	opts VerifyOptions
+
+	// tsOpts are the options for verifying timestamp tokens.
+	tsOpts VerifyOptions
+
+	// crls are the embedded CRLs, if opts.CheckEmbeddedCRLs is set.
+	crls []*x509.RevocationList
+
+	// ocsps are the embedded OCSP responses, if opts.CheckEmbeddedOCSP is set.
+	ocsps [][]byte
+}
+
+// newVerifier gets the SignedData's certificates and adds them, along with
+// opts.Certificates, to the intermediates in opts.
+func (sd *SignedData) newVerifier(opts VerifyOptions) (*verifier, error) {
 	certs, err := sd.psd.X509Certificates()
 	if err != nil {
 		return nil, err
@@ -55,120 +619,254 @@ func (sd *SignedData) verify(econtent []byte, opts x509.VerifyOptions) ([][][]*x
 		opts.Intermediates = x509.NewCertPool()
 	}
 
+	certs = append(certs, opts.Certificates...)
 	for _, cert := range certs {
 		opts.Intermediates.AddCert(cert)
 	}
 
 	// Use provided verification options for timestamp verification also, but
 	// explicitly ask for key-usage=timestamping.
-	tsOpts := opts
+	tsOpts := VerifyOptions{
+		VerifyOptions:     opts.VerifyOptions,
+		Certificates:      opts.Certificates,
+		CertificateLookup: opts.CertificateLookup,
+		AlgorithmPolicy:   opts.AlgorithmPolicy,
+	}
 	tsOpts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
 
-	chains := make([][][]*x509.Certificate, 0, len(sd.psd.SignerInfos))
+	v := &verifier{certs: certs, opts: opts, tsOpts: tsOpts}
 
-	for _, si := range sd.psd.SignerInfos {
-		var signedMessage []byte
-
-		// SignedAttrs is optional if EncapContentInfo eContentType isn't id-data.
-		if si.SignedAttrs == nil {
-			// SignedAttrs may only be absent if EncapContentInfo eContentType is
-			// id-data.
-			if !sd.psd.EncapContentInfo.IsTypeData() {
-				return nil, protocol.ASN1Error{Message: "missing SignedAttrs"}
-			}
+	if opts.CheckEmbeddedCRLs {
+		if v.crls, err = sd.GetCRLs(); err != nil {
+			return nil, err
+		}
+	}
 
-			// If SignedAttrs is absent, the signature is over the original
-			// encapsulated content itself.
-			signedMessage = econtent
-		} else {
-			// If SignedAttrs is present, we validate the mandatory ContentType and
-			// MessageDigest attributes.
-			siContentType, err := si.GetContentTypeAttribute()
-			if err != nil {
-				return nil, err
-			}
-			if !siContentType.Equal(sd.psd.EncapContentInfo.EContentType) {
-				return nil, protocol.ASN1Error{Message: "invalid SignerInfo ContentType attribute"}
-			}
+	if opts.CheckEmbeddedOCSP {
+		if v.ocsps, err = sd.GetOCSPResponses(); err != nil {
+			return nil, err
+		}
+	}
 
-			// Calculate the digest over the actual message.
-			hash, err := si.Hash()
-			if err != nil {
-				return nil, err
-			}
-			actualMessageDigest := hash.New()
-			if _, err = actualMessageDigest.Write(econtent); err != nil {
-				return nil, err
-			}
+	return v, nil
+}
 
-			// Get the digest from the SignerInfo.
-			messageDigestAttr, err := si.GetMessageDigestAttribute()
-			if err != nil {
-				return nil, err
-			}
+// verifyTimestamp verifies the signature timestamp in si, returning the
+// timestamp.Info and the TSA's chains. Nil is returned if si doesn't have a
+// timestamp.
+func (v *verifier) verifyTimestamp(si protocol.SignerInfo) (*timestamp.Info, [][]*x509.Certificate, error) {
+	if hasTS, err := hasTimestamp(si); err != nil || !hasTS {
+		return nil, nil, err
+	}
 
-			// Make sure message digests match.
-			if !bytes.Equal(messageDigestAttr, actualMessageDigest.Sum(nil)) {
-				return nil, errors.New("invalid message digest")
-			}
+	tsti, chains, err := verifyTimestamp(si, v.tsOpts)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			// The signature is over the DER encoded signed attributes, minus the
-			// leading class/tag/length bytes. This includes the digest of the
-			// original message, so it is implicitly signed too.
-			if signedMessage, err = si.SignedAttrs.MarshaledForVerification(); err != nil {
-				return nil, err
-			}
+	return &tsti, chains, nil
+}
+
+// checkAlgorithms checks the algorithms used by si and cert's key against the
+// algorithm policy. tsti is the signature's verified timestamp, or nil if it
+// doesn't have one.
+func (v *verifier) checkAlgorithms(si protocol.SignerInfo, cert *x509.Certificate, tsti *timestamp.Info) error {
+	signingTime := v.opts.currentTime()
+	if tsti != nil {
+		signingTime = tsti.GenTime.Add(tsti.Accuracy.Duration())
+	}
+
+	return v.opts.algorithmPolicy().CheckSignerInfo(si, cert, signingTime)
+}
+
+// verifyCertificate verifies the chain for cert, which made a signature with
+// the verified timestamp tsti. tsti may be nil if the signature doesn't have a
+// timestamp. If keys are pinned, cert's key is checked instead.
+func (v *verifier) verifyCertificate(cert *x509.Certificate, tsti *timestamp.Info) ([][]*x509.Certificate, error) {
+	if len(v.opts.PinnedKeys) > 0 {
+		if v.pinnedKey(cert) == nil {
+			return nil, ErrKeyNotPinned
 		}
 
-		cert, err := si.FindCertificate(certs)
-		if err != nil {
-			return nil, err
+		return [][]*x509.Certificate{{cert}}, nil
+	}
+
+	// If the caller didn't specify the signature time, we'll use the verified
+	// timestamp. If there's no timestamp we use the current time when checking
+	// the cert validity window. This isn't perfect because the signature may
+	// have been created before the cert's not-before date, but this is the best
+	// we can do. We update a copy of opts because we are verifying multiple
+	// signatures and only want the timestamp to affect this one.
+	opts := v.opts.VerifyOptions
+
+	if tsti != nil {
+		// This check is slightly redundant, given that the cert validity times
+		// are checked by cert.Verify. We take the timestamp accuracy into account
+		// here though, whereas cert.Verify will not.
+		if !tsti.Before(cert.NotAfter) || !tsti.After(cert.NotBefore) {
+			return nil, x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired, Detail: ""}
+		}
+
+		if opts.CurrentTime.IsZero() {
+			opts.CurrentTime = tsti.GenTime
 		}
+	}
+
+	chains, err := cert.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
 
-		algo := si.X509SignatureAlgorithm()
-		if algo == x509.UnknownSignatureAlgorithm {
-			return nil, protocol.ErrUnsupported
+	if v.opts.CheckEmbeddedCRLs || v.opts.CheckEmbeddedOCSP || v.opts.RevocationChecker != nil {
+		signingTime := opts.CurrentTime
+		if signingTime.IsZero() {
+			signingTime = time.Now()
 		}
 
-		if err := cert.CheckSignature(algo, signedMessage, si.Signature); err != nil {
+		if chains, err = v.checkRevocation(chains, signingTime); err != nil {
 			return nil, err
 		}
+	}
 
-		// If the caller didn't specify the signature time, we'll use the verified
-		// timestamp. If there's no timestamp we use the current time when checking
-		// the cert validity window. This isn't perfect because the signature may
-		// have been created before the cert's not-before date, but this is the best
-		// we can do. We update a copy of opts because we are verifying multiple
-		// signatures in a loop and only want the timestamp to affect this one.
-		optsCopy := opts
+	return chains, nil
+}
 
-		if hasTS, err := hasTimestamp(si); err != nil {
-			return nil, err
-		} else if hasTS {
-			tsti, err := getTimestamp(si, tsOpts)
-			if err != nil {
-				return nil, err
+// checkRevocation returns the chains in which every certificate, other than
+// the root, wasn't revoked at signing time t. An error is returned if no chains
+// pass.
+func (v *verifier) checkRevocation(chains [][]*x509.Certificate, t time.Time) ([][]*x509.Certificate, error) {
+	var (
+		passed  [][]*x509.Certificate
+		lastErr error
+	)
+
+	for _, chain := range chains {
+		lastErr = nil
+		for i := 0; i < len(chain)-1 && lastErr == nil; i++ {
+			lastErr = v.checkStatus(chain[i], chain[i+1], t)
+		}
+
+		if lastErr == nil {
+			passed = append(passed, chain)
+		}
+	}
+
+	if len(passed) == 0 {
+		return nil, lastErr
+	}
+
+	return passed, nil
+}
+
+// checkStatus checks cert's status at signing time t using the embedded OCSP
+// responses and CRLs and then the RevocationChecker.
+func (v *verifier) checkStatus(cert, issuer *x509.Certificate, t time.Time) error {
+	if v.opts.CheckEmbeddedOCSP {
+		if found, err := checkOCSP(cert, issuer, v.ocsps, t); found {
+			return err
+		}
+	}
+
+	if v.opts.CheckEmbeddedCRLs {
+		if found, err := checkCRL(cert, issuer, v.crls, t); found {
+			return err
+		}
+	}
+
+	if v.opts.RevocationChecker != nil {
+		if v.opts.RevocationCheckNow {
+			t = time.Now()
+		}
+
+		revoked, err := v.opts.RevocationChecker.IsRevoked(cert, issuer, t)
+		if err != nil {
+			if v.opts.RevocationSoftFail {
+				return nil
 			}
+			return err
+		}
+
+		if revoked {
+			return fmt.Errorf("certificate %s was revoked", cert.Subject)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no valid revocation data for certificate %s", cert.Subject)
+}
+
+// checkOCSP checks cert's status at time t using the first OCSP response for
+// it that was valid at t or produced after it. Stapled responses are usually
+// fetched after signing, so later responses are accepted and a revoked status
+// only counts if the certificate was revoked by t. Responses with an unknown
+// status are skipped. found is false if there is no such response.
+func checkOCSP(cert, issuer *x509.Certificate, ocsps [][]byte, t time.Time) (found bool, err error) {
+	for _, der := range ocsps {
+		resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+		if err != nil {
+			continue
+		}
 
-			// This check is slightly redundant, given that the cert validity times
-			// are checked by cert.Verify. We take the timestamp accuracy into account
-			// here though, whereas cert.Verify will not.
-			if !tsti.Before(cert.NotAfter) || !tsti.After(cert.NotBefore) {
-				return nil, x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired, Detail: ""}
+		// ParseResponseForCert checks that a delegated responder's certificate
+		// was issued by issuer, but not that it's authorized to sign OCSP
+		// responses (RFC6960 section 4.2.2.2).
+		if resp.Certificate != nil && !resp.Certificate.Equal(issuer) && !isOCSPResponder(resp.Certificate) {
+			continue
+		}
+
+		// Skip responses that had already expired at t.
+		if !resp.NextUpdate.IsZero() && t.After(resp.NextUpdate) {
+			continue
+		}
+
+		switch resp.Status {
+		case ocsp.Good:
+			return true, nil
+		case ocsp.Revoked:
+			if resp.RevokedAt.After(t) {
+				return true, nil
 			}
+			return true, fmt.Errorf("certificate %s was revoked at %s", cert.Subject, resp.RevokedAt)
+		}
+	}
+
+	return false, nil
+}
+
+// checkCRL checks cert's status at time t using the first CRL from issuer that
+// was valid at t or issued after it. Revocation data is usually collected
+// after signing, so later CRLs are accepted and only entries revoked by t
+// count. found is false if there is no such CRL.
+func checkCRL(cert, issuer *x509.Certificate, crls []*x509.RevocationList, t time.Time) (found bool, err error) {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
 
-			if optsCopy.CurrentTime.IsZero() {
-				optsCopy.CurrentTime = tsti.GenTime
+		// Skip CRLs that had already expired at t.
+		if !crl.NextUpdate.IsZero() && t.After(crl.NextUpdate) {
+			continue
+		}
+
+		for _, rc := range crl.RevokedCertificateEntries {
+			if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 && !rc.RevocationTime.After(t) {
+				return true, fmt.Errorf("certificate %s was revoked at %s", cert.Subject, rc.RevocationTime)
 			}
 		}
 
-		if chain, err := cert.Verify(optsCopy); err != nil {
-			return nil, err
-		} else {
-			chains = append(chains, chain)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func isOCSPResponder(cert *x509.Certificate) bool {
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			return true
 		}
 	}
 
-	// OK
-	return chains, nil
+	return false
 }
@@ -2,14 +2,23 @@ package cms
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"io"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/github/fakeca"
 	"github.com/github/ietf-cms/protocol"
+	"github.com/github/ietf-cms/timestamp"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/xerrors"
 )
 
@@ -64,9 +73,61 @@ func TestVerify(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := sd.Verify(verifyOptionsForSignedData(sd)); err != nil {
+	// The fixture uses SHA1-RSA, which the default policy doesn't allow.
+	if _, err := sd.Verify(verifyOptionsForSignedData(sd)); !errors.Is(err, protocol.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected algorithm policy error, got %v", err)
+	}
+
+	opts := VerifyOptions{
+		VerifyOptions:   verifyOptionsForSignedData(sd),
+		AlgorithmPolicy: protocol.LegacyAlgorithmPolicy(),
+	}
+	if _, err := sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAlgorithmPolicy(t *testing.T) {
+	sd, err := ParseSignedData(fixtureSignatureOne)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fixture was signed in 2015.
+	policy := protocol.LegacyAlgorithmPolicy()
+	policy.DigestAlgorithmCutoffs = map[crypto.Hash]time.Time{
+		crypto.SHA1: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	opts := VerifyOptions{VerifyOptions: verifyOptionsForSignedData(sd), AlgorithmPolicy: policy}
+	if _, err = sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	policy.DigestAlgorithmCutoffs[crypto.SHA1] = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = sd.VerifyWithOptions(opts)
+	if sigErr := new(SignatureError); !errors.As(err, &sigErr) || !errors.Is(err, protocol.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected algorithm policy error, got %v", err)
+	}
+
+	// Timestamps are checked against the policy too.
+	tsa.Clear()
+	sd, err = NewSignedData([]byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.AddTimestamps("https://google.com"); err != nil {
 		t.Fatal(err)
 	}
+	policy = protocol.DefaultAlgorithmPolicy()
+	policy.DigestAlgorithmCutoffs = map[crypto.Hash]time.Time{crypto.SHA256: time.Now().Add(-time.Hour)}
+	opts = VerifyOptions{VerifyOptions: x509.VerifyOptions{Roots: root.ChainPool()}, AlgorithmPolicy: policy}
+	_, err = sd.VerifyWithOptions(opts)
+	if tsErr := new(TimestampError); !errors.As(err, &tsErr) || !errors.Is(err, protocol.ErrAlgorithmNotAllowed) {
+		t.Fatalf("expected algorithm policy error, got %v", err)
+	}
 }
 
 func TestVerifyGPGSMAttached(t *testing.T) {
@@ -105,11 +166,60 @@ func TestVerifyGPGSMNoCerts(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := sd.VerifyDetached([]byte("hello, world!\n"), x509.VerifyOptions{}); err != protocol.ErrNoCertificate {
+	var certErr *CertificateNotFoundError
+	if _, err := sd.VerifyDetached([]byte("hello, world!\n"), x509.VerifyOptions{}); !errors.As(err, &certErr) || !errors.Is(err, protocol.ErrNoCertificate) {
 		t.Fatalf("expected %v, got %v", protocol.ErrNoCertificate, err)
 	}
 }
 
+func TestVerifyDetachedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("hello, world!\n"), 100000)
+
+	// Two signers using different digest algorithms and one sharing an
+	// algorithm.
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{DigestAlgorithm: crypto.SHA512}); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(intermediate.Chain(), intermediate.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detached()
+
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	chains, err := sd.VerifyDetachedReader(bytes.NewReader(data), rootOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 2 {
+		t.Fatalf("expected 2 chains, got %d", len(chains))
+	}
+
+	var digestErr *DigestMismatchError
+	if _, err = sd.VerifyDetachedReader(bytes.NewReader(data[1:]), rootOpts); !errors.As(err, &digestErr) {
+		t.Fatalf("expected DigestMismatchError, got %v", err)
+	}
+
+	// Not detached.
+	attached, err := ParseSignedData(fixtureSignatureOpenSSLAttached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = attached.VerifyDetachedReader(bytes.NewReader(data), rootOpts); err == nil {
+		t.Fatal("expected error for attached signature")
+	}
+}
+
 func TestVerifyOpenSSLAttached(t *testing.T) {
 	sd, err := ParseSignedData(fixtureSignatureOpenSSLAttached)
 	if err != nil {
@@ -183,26 +293,707 @@ func TestVerifyChain(t *testing.T) {
 
 	// bad root
 	if _, err = sd.Verify(otherRootOpts); err != nil {
-		if _, isX509Err := err.(x509.UnknownAuthorityError); !isX509Err {
+		var (
+			chainErr *ChainError
+			x509Err  x509.UnknownAuthorityError
+		)
+		if !errors.As(err, &chainErr) || !errors.As(err, &x509Err) {
 			t.Fatalf("expected x509.UnknownAuthorityError, got %v", err)
 		}
 	}
 
 	// system root
 	if _, err = sd.Verify(x509.VerifyOptions{}); err != nil {
-		if _, isX509Err := err.(x509.UnknownAuthorityError); !isX509Err {
+		var (
+			chainErr *ChainError
+			x509Err  x509.UnknownAuthorityError
+		)
+		if !errors.As(err, &chainErr) || !errors.As(err, &x509Err) {
 			t.Fatalf("expected x509.UnknownAuthorityError, got %v", err)
 		}
 	}
 
 	// no root
 	if _, err = sd.Verify(x509.VerifyOptions{Roots: x509.NewCertPool()}); err != nil {
-		if _, isX509Err := err.(x509.UnknownAuthorityError); !isX509Err {
+		var (
+			chainErr *ChainError
+			x509Err  x509.UnknownAuthorityError
+		)
+		if !errors.As(err, &chainErr) || !errors.As(err, &x509Err) {
 			t.Fatalf("expected x509.UnknownAuthorityError, got %v", err)
 		}
 	}
 }
 
+func TestVerifyEmbeddedCRLs(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hi")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := VerifyOptions{VerifyOptions: crlRootOpts, CheckEmbeddedCRLs: true}
+
+	// no CRLs
+	if _, err = sd.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error without CRLs")
+	}
+
+	// the intermediate isn't covered
+	intermediateCRL := createRevocationList(t, crlIntermediate, time.Now().Add(-time.Hour))
+	if err = sd.AddCRL(intermediateCRL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error without root CRL")
+	}
+
+	rootCRL := createRevocationList(t, crlRoot, time.Now().Add(-time.Hour))
+	if err = sd.AddCRL(rootCRL); err != nil {
+		t.Fatal(err)
+	}
+
+	// adding the same CRL twice is a no-op
+	if err = sd.AddCRL(intermediateCRL); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	crls, err := sd.GetCRLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CRLs are a DER SET OF, so their order depends on their encoding
+	if len(crls) != 2 || !bytes.Equal(crls[0].Raw, intermediateCRL.Raw) && !bytes.Equal(crls[1].Raw, intermediateCRL.Raw) {
+		t.Fatal("bad CRLs")
+	}
+
+	if _, err = sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetachedReaderWithOptions(bytes.NewReader(data), opts); err == nil {
+		t.Fatal("expected error verifying attached signature as detached")
+	}
+
+	// CRLs aren't checked by default
+	revoked := createRevocationList(t, crlIntermediate, time.Now().Add(-time.Hour), x509.RevocationListEntry{
+		SerialNumber:   crlLeaf.Certificate.SerialNumber,
+		RevocationTime: time.Now().Add(-20 * time.Minute),
+	})
+	sdRevoked, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.AddCRL(revoked); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.AddCRL(rootCRL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sdRevoked.Verify(crlRootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked leaf
+	if _, err = sdRevoked.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for revoked certificate")
+	}
+
+	// revoked after the signing time
+	pastOpts := opts
+	pastOpts.CurrentTime = time.Now().Add(-30 * time.Minute)
+	if _, err = sdRevoked.VerifyWithOptions(pastOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked after the timestamp time
+	tsa.HookInfo(func(info timestamp.Info) timestamp.Info {
+		info.GenTime = time.Now().Add(-30 * time.Minute)
+		return info
+	})
+	defer tsa.Clear()
+
+	if err = sdRevoked.AddTimestamps("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sdRevoked.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyEmbeddedCRLsIssuedAfterTimestamp(t *testing.T) {
+	data := []byte("hi")
+
+	tsa.HookInfo(func(info timestamp.Info) timestamp.Info {
+		info.GenTime = time.Now().Add(-30 * time.Minute)
+		return info
+	})
+	defer tsa.Clear()
+
+	rootCRL := createRevocationList(t, crlRoot, time.Now())
+	opts := VerifyOptions{VerifyOptions: crlRootOpts, CheckEmbeddedCRLs: true}
+
+	sign := func(crls ...*x509.RevocationList) *SignedData {
+		sd, err := NewSignedData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.AddTimestamps("https://google.com"); err != nil {
+			t.Fatal(err)
+		}
+		for _, crl := range append(crls, rootCRL) {
+			if err = sd.AddCRL(crl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return sd
+	}
+
+	// CRLs collected after the timestamp, as for B-LT signatures
+	if _, err := sign(createRevocationList(t, crlIntermediate, time.Now())).VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked after the timestamp time
+	revokedLater := createRevocationList(t, crlIntermediate, time.Now(), x509.RevocationListEntry{
+		SerialNumber:   crlLeaf.Certificate.SerialNumber,
+		RevocationTime: time.Now().Add(-20 * time.Minute),
+	})
+	if _, err := sign(revokedLater).VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked before the timestamp time
+	revokedEarlier := createRevocationList(t, crlIntermediate, time.Now(), x509.RevocationListEntry{
+		SerialNumber:   crlLeaf.Certificate.SerialNumber,
+		RevocationTime: time.Now().Add(-40 * time.Minute),
+	})
+	if _, err := sign(revokedEarlier).VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for certificate revoked before the timestamp")
+	}
+
+	// a CRL that expired before the timestamp time doesn't count
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: time.Now().Add(-time.Hour),
+	}, crlIntermediate.Certificate, crlIntermediate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sign(expired).VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for expired CRL")
+	}
+}
+
+func TestVerifyEmbeddedOCSP(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hi")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := VerifyOptions{VerifyOptions: crlRootOpts, CheckEmbeddedOCSP: true}
+
+	// no responses
+	if _, err = sd.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error without OCSP responses")
+	}
+
+	leafOCSP := createOCSPResponse(t, crlIntermediate, crlLeaf)
+	if err = sd.AddOCSPResponse(leafOCSP); err != nil {
+		t.Fatal(err)
+	}
+	if sd.psd.Version != 5 {
+		t.Fatalf("expected version 5, got %d", sd.psd.Version)
+	}
+
+	// the intermediate isn't covered, unless CRLs are checked too
+	if _, err = sd.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error without intermediate OCSP response")
+	}
+	if err = sd.AddCRL(createRevocationList(t, crlRoot, time.Now().Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	bothOpts := opts
+	bothOpts.CheckEmbeddedCRLs = true
+	if _, err = sd.VerifyWithOptions(bothOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sd.AddOCSPResponse(createOCSPResponse(t, crlRoot, crlIntermediate)); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	ocsps, err := sd.GetOCSPResponses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocsps) != 2 || !bytes.Equal(ocsps[0], leafOCSP) && !bytes.Equal(ocsps[1], leafOCSP) {
+		t.Fatal("bad OCSP responses")
+	}
+	if crls, err := sd.GetCRLs(); err != nil {
+		t.Fatal(err)
+	} else if len(crls) != 1 {
+		t.Fatal("bad CRLs", len(crls))
+	}
+
+	if _, err = sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked leaf
+	sdRevoked, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.AddOCSPResponse(createOCSPResponseFrom(t, crlRoot, crlRoot, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: crlIntermediate.Certificate.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(time.Hour),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdRevoked.AddOCSPResponse(createOCSPResponseFrom(t, crlIntermediate, crlIntermediate, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: crlLeaf.Certificate.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-20 * time.Minute),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sdRevoked.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for revoked certificate")
+	}
+
+	// revoked after the signing time
+	pastOpts := opts
+	pastOpts.CurrentTime = time.Now().Add(-30 * time.Minute)
+	if _, err = sdRevoked.VerifyWithOptions(pastOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// a delegated responder must be authorized for OCSP signing
+	sdDelegated, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sdDelegated.Sign(crlLeaf.Chain(), crlLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdDelegated.AddOCSPResponse(ocsps[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = sdDelegated.AddOCSPResponse(createOCSPResponseFrom(t, crlIntermediate, crlIntermediate.Issue(), ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: crlLeaf.Certificate.SerialNumber,
+		ThisUpdate:   time.Now(),
+		NextUpdate:   time.Now().Add(time.Hour),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sdDelegated.VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for unauthorized OCSP responder")
+	}
+}
+
+func TestVerifyEmbeddedOCSPProducedAfterTimestamp(t *testing.T) {
+	data := []byte("hi")
+
+	tsa.HookInfo(func(info timestamp.Info) timestamp.Info {
+		info.GenTime = time.Now().Add(-30 * time.Minute)
+		return info
+	})
+	defer tsa.Clear()
+
+	intermediateOCSP := createOCSPResponse(t, root, intermediate)
+	opts := VerifyOptions{VerifyOptions: rootOpts, CheckEmbeddedOCSP: true}
+
+	sign := func(responses ...[]byte) *SignedData {
+		sd, err := NewSignedData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.Sign(leaf.Chain(), leaf.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.AddTimestamps("https://google.com"); err != nil {
+			t.Fatal(err)
+		}
+		for _, resp := range append(responses, intermediateOCSP) {
+			if err = sd.AddOCSPResponse(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return sd
+	}
+
+	// responses fetched after the timestamp, as for B-LT signatures
+	if _, err := sign(createOCSPResponse(t, intermediate, leaf)).VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := func(revokedAt time.Time) []byte {
+		return createOCSPResponseFrom(t, intermediate, intermediate, ocsp.Response{
+			Status:       ocsp.Revoked,
+			SerialNumber: leaf.Certificate.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    revokedAt,
+		})
+	}
+
+	// revoked after the timestamp time
+	if _, err := sign(revoked(time.Now().Add(-20 * time.Minute))).VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked before the timestamp time
+	if _, err := sign(revoked(time.Now().Add(-40 * time.Minute))).VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for certificate revoked before the timestamp")
+	}
+
+	// a response that expired before the timestamp time doesn't count
+	expired := createOCSPResponseFrom(t, intermediate, intermediate, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.Certificate.SerialNumber,
+		ThisUpdate:   time.Now().Add(-2 * time.Hour),
+		NextUpdate:   time.Now().Add(-time.Hour),
+	})
+	if _, err := sign(expired).VerifyWithOptions(opts); err == nil {
+		t.Fatal("expected error for expired OCSP response")
+	}
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	tsa.Clear()
+	data := []byte("hi")
+
+	sd, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted := otherRoot.Issue()
+	for _, ident := range []*fakeca.Identity{leaf, untrusted, intermediate} {
+		if err = sd.Sign(ident.Chain(), ident.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sd.AddTimestamps("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sd.Verify(rootOpts); err == nil {
+		t.Fatal("expected error from untrusted signer")
+	}
+
+	opts := VerifyOptions{VerifyOptions: rootOpts}
+
+	results, err := sd.VerifyDetailed(opts, AllSigners)
+	if err == nil {
+		t.Fatal("expected error from untrusted signer")
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	for i, ident := range []*fakeca.Identity{leaf, intermediate} {
+		res := results[i*2]
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res.Signer.Index() != i*2 {
+			t.Fatalf("bad signer index: %d", res.Signer.Index())
+		}
+		if !res.Certificate.Equal(ident.Certificate) {
+			t.Fatal("bad certificate")
+		}
+		if len(res.Chains) != 1 || !res.Chains[0][0].Equal(ident.Certificate) {
+			t.Fatal("bad chains")
+		}
+		if res.DigestAlgorithm != crypto.SHA256 {
+			t.Fatalf("bad digest algorithm: %v", res.DigestAlgorithm)
+		}
+		if res.SignatureAlgorithm == x509.UnknownSignatureAlgorithm {
+			t.Fatal("bad signature algorithm")
+		}
+		if time.Since(res.SigningTime) > time.Minute {
+			t.Fatalf("bad signing time: %v", res.SigningTime)
+		}
+		if res.Timestamp == nil || time.Since(res.Timestamp.GenTime) > time.Minute {
+			t.Fatal("bad timestamp")
+		}
+		if len(res.TimestampChains) != 1 || !res.TimestampChains[0][0].Equal(tsa.ident.Certificate) {
+			t.Fatal("bad timestamp chains")
+		}
+	}
+
+	var chainErr *ChainError
+	if !errors.As(results[1].Err, &chainErr) || chainErr.SignerIndex != 1 || results[1].Chains != nil {
+		t.Fatalf("expected ChainError for signer 1, got %v", results[1].Err)
+	}
+	if !results[1].Certificate.Equal(untrusted.Certificate) {
+		t.Fatal("bad certificate")
+	}
+
+	for _, policy := range []SignerPolicy{AnySigner, AtLeastSigners(2), AtLeastSigners(-1)} {
+		if _, err = sd.VerifyDetailed(opts, policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = sd.VerifyDetailed(opts, AtLeastSigners(3)); err == nil {
+		t.Fatal("expected error requiring 3 signers")
+	}
+
+	sd.Detached()
+	if _, err = sd.VerifyDetailed(opts, AnySigner); err == nil {
+		t.Fatal("expected error verifying detached signature")
+	}
+	if _, err = sd.VerifyDetachedDetailed(data, opts, AnySigner); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = sd.VerifyDetachedDetailed([]byte("bye"), opts, AnySigner); err == nil {
+		t.Fatal("expected error for wrong message")
+	}
+	for _, res := range results {
+		if res.Err == nil || res.Certificate != nil {
+			t.Fatal("expected error for wrong message")
+		}
+	}
+}
+
+func TestVerifyExternalCertificates(t *testing.T) {
+	data := []byte("hello, world!")
+	opts := x509.VerifyOptions{Roots: root.ChainPool()}
+
+	for _, ski := range []bool{false, true} {
+		sd, err := NewSignedData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.SignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{SubjectKeyIdentifier: ski}); err != nil {
+			t.Fatal(err)
+		}
+		if err = sd.SetCertificates(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		notFound := new(CertificateNotFoundError)
+		if _, err = sd.Verify(opts); !errors.As(err, &notFound) {
+			t.Fatalf("expected CertificateNotFoundError, got %v", err)
+		}
+
+		if _, err = sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts, Certificates: leaf.Chain()}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The intermediate is found by the leaf's authority key identifier.
+		lookup := testCertificateLookup{leaf.Certificate, intermediate.Certificate}
+		if _, err = sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts, CertificateLookup: lookup}); err != nil {
+			t.Fatal(err)
+		}
+
+		chainErr := new(ChainError)
+		lookup = testCertificateLookup{leaf.Certificate}
+		if _, err = sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts, CertificateLookup: lookup}); !errors.As(err, &chainErr) {
+			t.Fatalf("expected ChainError, got %v", err)
+		}
+
+		lookup = testCertificateLookup{intermediate.Certificate}
+		if _, err = sd.VerifyWithOptions(VerifyOptions{VerifyOptions: opts, CertificateLookup: lookup}); !errors.As(err, &notFound) {
+			t.Fatalf("expected CertificateNotFoundError, got %v", err)
+		}
+	}
+}
+
+type testCertificateLookup []*x509.Certificate
+
+func (l testCertificateLookup) LookupIssuerAndSerialNumber(isn protocol.IssuerAndSerialNumber) (*x509.Certificate, error) {
+	for _, cert := range l {
+		if bytes.Equal(cert.RawIssuer, isn.Issuer.FullBytes) && cert.SerialNumber.Cmp(isn.SerialNumber) == 0 {
+			return cert, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (l testCertificateLookup) LookupSubjectKeyIdentifier(ski []byte) (*x509.Certificate, error) {
+	for _, cert := range l {
+		if protocol.MatchesSubjectKeyIdentifier(cert, ski) {
+			return cert, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func TestVerifyPinnedKeys(t *testing.T) {
+	data := []byte("hello, world!")
+	selfSigned := fakeca.New()
+
+	der, err := SignDetached(data, selfSigned.Chain(), selfSigned.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spkiHash := sha256.Sum256(selfSigned.Certificate.RawSubjectPublicKeyInfo)
+	for _, pk := range []PinnedKey{{PublicKey: selfSigned.PrivateKey.Public()}, {SPKIHash: spkiHash[:]}} {
+		opts := VerifyOptions{PinnedKeys: []PinnedKey{{PublicKey: leaf.PrivateKey.Public()}, pk}}
+
+		results, err := sd.VerifyDetachedDetailed(data, opts, AllSigners)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].PinnedKey != &opts.PinnedKeys[1] {
+			t.Fatal("expected second pinned key to match")
+		}
+		if len(results[0].Chains) != 1 || !results[0].Chains[0][0].Equal(selfSigned.Certificate) {
+			t.Fatal("expected chain with just the signer's certificate")
+		}
+
+		// The content is still checked.
+		digestErr := new(DigestMismatchError)
+		if _, err = sd.VerifyDetachedWithOptions([]byte("wrong"), opts); !errors.As(err, &digestErr) {
+			t.Fatalf("expected DigestMismatchError, got %v", err)
+		}
+	}
+
+	opts := VerifyOptions{PinnedKeys: []PinnedKey{{PublicKey: leaf.PrivateKey.Public()}}}
+	chainErr := new(ChainError)
+	if _, err = sd.VerifyDetachedWithOptions(data, opts); !errors.As(err, &chainErr) || !errors.Is(err, ErrKeyNotPinned) {
+		t.Fatalf("expected ErrKeyNotPinned, got %v", err)
+	}
+
+	// A signer identified by its subject key identifier doesn't need to
+	// include its certificate if its key is pinned.
+	if sd, err = NewSignedData(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SignWithOptions(leaf.Chain(), leaf.PrivateKey, SignOptions{SubjectKeyIdentifier: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.SetCertificates(nil); err != nil {
+		t.Fatal(err)
+	}
+	opts = VerifyOptions{PinnedKeys: []PinnedKey{{PublicKey: selfSigned.PrivateKey.Public()}, {PublicKey: leaf.PrivateKey.Public()}}}
+	results, err := sd.VerifyDetailed(opts, AllSigners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].PinnedKey != &opts.PinnedKeys[1] {
+		t.Fatal("expected second pinned key to match")
+	}
+	if results[0].Certificate != nil || results[0].Chains != nil {
+		t.Fatal("expected no certificate or chains")
+	}
+
+	// The SKI must match a pinned key, which the signature must be valid for.
+	notFound := new(CertificateNotFoundError)
+	leafSPKIHash := sha256.Sum256(leaf.Certificate.RawSubjectPublicKeyInfo)
+	for _, pk := range []PinnedKey{{PublicKey: selfSigned.PrivateKey.Public()}, {SPKIHash: leafSPKIHash[:]}} {
+		if _, err = sd.VerifyWithOptions(VerifyOptions{PinnedKeys: []PinnedKey{pk}}); !errors.As(err, &notFound) {
+			t.Fatalf("expected CertificateNotFoundError, got %v", err)
+		}
+	}
+	sd.psd.SignerInfos[0].Signature[0] ^= 0xFF
+	sigErr := new(SignatureError)
+	if _, err = sd.VerifyWithOptions(opts); !errors.As(err, &sigErr) {
+		t.Fatalf("expected SignatureError, got %v", err)
+	}
+
+	// Ed448 keys can be pinned by their SPKI hash.
+	if sd, err = ParseSignedData(fixtureSignatureEd448); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = VerifyOptions{}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			spkiHash = sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			opts.PinnedKeys = append(opts.PinnedKeys, PinnedKey{SPKIHash: spkiHash[:]})
+		}
+	}
+	if _, err = sd.VerifyWithOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyEd448(t *testing.T) {
+	sd, err := ParseSignedData(fixtureSignatureEd448)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := x509.VerifyOptions{Roots: x509.NewCertPool()}
+	for _, cert := range certs {
+		if cert.IsCA {
+			opts.Roots.AddCert(cert)
+		}
+	}
+
+	if _, err = sd.Verify(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	sd.psd.SignerInfos[0].Signature[0] ^= 0xFF
+	if _, err = sd.Verify(opts); !errors.Is(err, protocol.ErrEd448Verification) {
+		t.Fatalf("expected %v, got %v", protocol.ErrEd448Verification, err)
+	}
+}
+
 func TestVerifyDSAWithSHA1(t *testing.T) {
 	// Created with the following openssl commands:
 	// openssl dsaparam -out dsakey.pem -genkey 1024
@@ -250,7 +1041,7 @@ F1Al5pA+giJh15T7Uu+p5O0J
 	}
 	pkcs7Cert, err := x509.ParseCertificate(pkcs7CertPEM.Bytes)
 	if err != nil {
-		t.Fatalf("failed to parse certificate: " + err.Error())
+		t.Fatalf("failed to parse certificate: %v", err)
 	}
 
 	pkcs7Certs := []*x509.Certificate{pkcs7Cert}
@@ -800,6 +1591,50 @@ var fixtureSmimesignAttachedWithTimestamp = mustBase64Decode("" +
 	"PWw6BOUQ5LQYHqlrGwfbnlk=",
 )
 
+// fixtureSignatureEd448 was created by signing "hello, world!\n" with an Ed448
+// key using `openssl pkeyutl -sign -rawin`, since OpenSSL can't create Ed448
+// CMS signatures itself. The leaf certificate is issued by an RSA root, which
+// is also included.
+var fixtureSignatureEd448 = mustBase64Decode("" +
+	"MIIGVwYJKoZIhvcNAQcCoIIGSDCCBkQCAQExDTALBglghkgBZQMEAgwwHQYJ" +
+	"KoZIhvcNAQcBoBAEDmhlbGxvLCB3b3JsZCEKoIIEyTCCAcQwga0CFCvU1rRt" +
+	"6BHDltU05mv8CMayXvoCMA0GCSqGSIb3DQEBCwUAMA0xCzAJBgNVBAMMAmNh" +
+	"MCAXDTI2MTAxNjA2MTQ1OVoYDzIxMjYwOTIyMDYxNDU5WjAQMQ4wDAYDVQQD" +
+	"DAVlZDQ0ODBDMAUGAytlcQM6AOsizkcRzJ14UCYBSXh5/pGwg7VDcRKUm2tV" +
+	"OipRiLQuR92yQkX0ag5M798czMn0ZXzsfFzAzRIogDANBgkqhkiG9w0BAQsF" +
+	"AAOCAQEADM7pN7XNDl/0t2T1BSNbQ1sGYw6gzPJ2nVUMieIAeJgIgjRdZUzV" +
+	"WNKMtVJaSeiBvDZRua6t6EILX2RlGnzPESauYqXocoV//mTvRJ8rsZcYr+a8" +
+	"ly7Xdh6cqN1bJ2xgX+qPic0SA2nE0fYls3K5LlBe0IQBsD4/b9y2Sl78lJdW" +
+	"TkMDmUTqTkANLexwymdozGCQZ/+icU4sC1a4zvR+azgvNQOfALHyFVT6qnNO" +
+	"wN0TosJE8vYDxhMp3Xv6byFYLy+xC2Dfoe9oVj0m1N2Ddrkw9iKFAfM/LVMU" +
+	"l9a4CW2sDVaDbKcAalRXo/0yl/gFdOIzvDDVzpdBEsqnL/cPfDCCAv0wggHl" +
+	"oAMCAQICFHeF6EhLmscf17K25Q0mZvYVYDlZMA0GCSqGSIb3DQEBCwUAMA0x" +
+	"CzAJBgNVBAMMAmNhMCAXDTI2MTAxNjA2MTQ1OVoYDzIxMjYwOTIyMDYxNDU5" +
+	"WjANMQswCQYDVQQDDAJjYTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoC" +
+	"ggEBAMxjSncHRDR3zML6q2p3ywnSF/DHE4HIElC+mCN074sO6a0MrFbZ0mcI" +
+	"wfj43ok+T03iraUWdYTmVIn8t5of2N3hJF2TYd0+T7pbDE61lsXod94xvXEJ" +
+	"oYmrqdIsD4C2BGon8UMJ2P8eDta8Gy+ETPZblenUD1lBuGmNb366m0waArNq" +
+	"Z+VI4BRfvfoo3cHdNNc8nzEw4vCLhf1RZbA3hPY0lzTStFppRjHpSMCm0YiY" +
+	"ubDal/pShkT9iEFFgW4cB3oUGPuV8hN29Dwr0DnkW2Pl9NB9yeKGcq/swbmJ" +
+	"NQqJjXyWfAIJr8kTntJiiusf98QyndH6WrDDtXutf4513yMCAwEAAaNTMFEw" +
+	"HQYDVR0OBBYEFBr/bnrd4r6yWBHEXwAJYNJNnSwZMB8GA1UdIwQYMBaAFBr/" +
+	"bnrd4r6yWBHEXwAJYNJNnSwZMA8GA1UdEwEB/wQFMAMBAf8wDQYJKoZIhvcN" +
+	"AQELBQADggEBAHNrnBRl8GkTnTK4I5rnXaFsenlmGyb6TLSxWVCNZ7PW85N0" +
+	"ydAJglEYg5sAjQdH+S3naTp9ZmsUDvyerjpwqUFnAefuN4HxpJx61D0Ryx8z" +
+	"RFOSNYJSK7VR3RWOWKvUBpmUiDjAmQd+jbiecrWs7C/VCPUFHe3AsXtZoQiL" +
+	"kjHXalGB03pB+1FQ3QRr1r4mVX2Bgt1Sqne6bbduCUw1ZEon5nOtHAk8ZTiA" +
+	"nGABTR6boZgN/0/8r5vkmPOA7RXDG268YyiriDujHhrDU3PUdK0JMhMWj/jr" +
+	"hhAcCKHjkDYoBIDhpvXfhMRktDkLhNFdQKifyl0xahBHBLxV14GAzlgxggFC" +
+	"MIIBPgIBATAlMA0xCzAJBgNVBAMMAmNhAhQr1Na0begRw5bVNOZr/AjGsl76" +
+	"AjALBglghkgBZQMEAgyggYkwGAYJKoZIhvcNAQkDMQsGCSqGSIb3DQEHATAc" +
+	"BgkqhkiG9w0BCQUxDxcNMjYxMDE2MDAwMDAwWjBPBgkqhkiG9w0BCQQxQgRA" +
+	"i4YVEvNGduCACqDasHfdwRxaSQxm2DyO8exTfboE7TYZ46/W6h7nQGt9BvuP" +
+	"mSVU5LUEjYnB6k4URdRITcFFCTAFBgMrZXEEchmgVoWhdcQXiWpkeTPI59nb" +
+	"aWmguvvc723WUysFdzDu1bIUUtmgItHphy2661HFjVWXx917R/TyACmXhi5p" +
+	"Q9RDG54AbHvc/dO2rZAWuBVezkf6Qx1mgpzLn5ywDvKiHhRpRyA+ofJEiNEK" +
+	"0OLnMWQ2AA==",
+)
+
 func mustBase64Decode(b64 string) []byte {
 	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64))
 	buf := new(bytes.Buffer)
@@ -810,3 +1645,51 @@ func mustBase64Decode(b64 string) []byte {
 
 	return buf.Bytes()
 }
+
+var (
+	// A chain whose CAs can sign CRLs, for the revocation tests. The shared
+	// fixtures don't have the cRLSign key usage.
+	crlCAKeyUsage   = fakeca.KeyUsage(x509.KeyUsageCertSign | x509.KeyUsageCRLSign)
+	crlRoot         = fakeca.New(fakeca.IsCA, crlCAKeyUsage)
+	crlIntermediate = crlRoot.Issue(fakeca.IsCA, crlCAKeyUsage)
+
+	crlLeaf = crlIntermediate.Issue(
+		fakeca.NotBefore(time.Now().Add(-time.Hour)),
+		fakeca.NotAfter(time.Now().Add(time.Hour)),
+	)
+
+	// The timestamps still come from the shared TSA, so its root is trusted
+	// too.
+	crlRootOpts         = x509.VerifyOptions{Roots: newCertPool(crlRoot.Certificate, root.Certificate)}
+	crlIntermediateOpts = x509.VerifyOptions{Roots: crlIntermediate.ChainPool()}
+)
+
+func newCertPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
+
+func createRevocationList(t *testing.T, issuer *fakeca.Identity, thisUpdate time.Time, revoked ...x509.RevocationListEntry) *x509.RevocationList {
+	t.Helper()
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(time.Now().UnixNano()),
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: revoked,
+	}, issuer.Certificate, issuer.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crl
+}